@@ -0,0 +1,81 @@
+package gg
+
+import (
+	"image"
+
+	"github.com/golang/freetype/raster"
+)
+
+type Antialias int
+
+const (
+	AntialiasGray Antialias = iota
+	AntialiasNone
+)
+
+// SetAntialias sets the antialiasing mode used for fills, strokes, clipping
+// paths and text. With AntialiasNone every pixel is either painted or not,
+// depending on whether its coverage reaches the antialias threshold.
+func (dc *Context) SetAntialias(antialias Antialias) {
+	dc.antialias = antialias
+}
+
+// SetAntialiasThreshold sets the coverage, between 0 and 1, that a pixel
+// needs to be painted when antialiasing is disabled. The default is 0.5.
+func (dc *Context) SetAntialiasThreshold(threshold float64) {
+	dc.aaThreshold = threshold
+}
+
+func (dc *Context) threshold() uint32 {
+	t := dc.aaThreshold
+	if t <= 0 {
+		return 1
+	}
+	if t > 1 {
+		t = 1
+	}
+	return uint32(t * 0xffff)
+}
+
+// aliased wraps the painter so that it paints hard edges when antialiasing
+// is disabled.
+func (dc *Context) aliased(painter raster.Painter) raster.Painter {
+	if dc.antialias != AntialiasNone {
+		return painter
+	}
+	return &aliasedPainter{painter: painter, threshold: dc.threshold()}
+}
+
+type aliasedPainter struct {
+	painter   raster.Painter
+	threshold uint32
+	spans     []raster.Span
+}
+
+// Paint satisfies the Painter interface.
+func (r *aliasedPainter) Paint(ss []raster.Span, done bool) {
+	spans := r.spans[:0]
+	for _, s := range ss {
+		if s.Alpha >= r.threshold {
+			s.Alpha = 0xffff
+			spans = append(spans, s)
+		}
+	}
+	r.spans = spans
+	r.painter.Paint(spans, done)
+}
+
+// aliasedMask returns a copy of the part of mask within r where every alpha
+// value is either fully transparent or fully opaque.
+func (dc *Context) aliasedMask(mask image.Image, r image.Rectangle) *image.Alpha {
+	t := dc.threshold()
+	result := image.NewAlpha(r)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if _, _, _, a := mask.At(x, y).RGBA(); a >= t {
+				result.Pix[result.PixOffset(x, y)] = 255
+			}
+		}
+	}
+	return result
+}
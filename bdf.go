@@ -0,0 +1,178 @@
+package gg
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/font"
+)
+
+// LoadBDFFace loads a bitmap font face from a BDF (Glyph Bitmap Distribution
+// Format) file. Use SetFontFace to draw text with it.
+func LoadBDFFace(path string) (font.Face, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseBDFFace(data)
+}
+
+// ParseBDFFace is like LoadBDFFace, but reads the font from data. The
+// glyphs are mapped to Unicode from the charset of the font, and the
+// default character of the font is drawn for missing characters.
+func ParseBDFFace(data []byte) (font.Face, error) {
+	f, err := parseBDF(data)
+	if err != nil {
+		return nil, err
+	}
+	return f.face(), nil
+}
+
+// parseBDF parses the BDF font in data.
+func parseBDF(data []byte) (*bitmapFont, error) {
+	f := &bitmapFont{
+		properties:  map[string]string{},
+		glyphs:      map[int]*bitmapGlyph{},
+		defaultChar: -1,
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 1<<20)
+	line := 0
+	var fields []string
+	next := func() bool {
+		for scanner.Scan() {
+			line++
+			if fields = strings.Fields(scanner.Text()); len(fields) > 0 {
+				return true
+			}
+		}
+		return false
+	}
+	bad := func() error {
+		return fmt.Errorf("gg: invalid BDF font at line %d", line)
+	}
+	ints := func(n int) ([]int, bool) {
+		if len(fields) < n+1 {
+			return nil, false
+		}
+		values := make([]int, n)
+		for i := range values {
+			v, err := strconv.Atoi(fields[i+1])
+			if err != nil {
+				return nil, false
+			}
+			values[i] = v
+		}
+		return values, true
+	}
+
+	if !next() || fields[0] != "STARTFONT" {
+		return nil, fmt.Errorf("gg: not a BDF font")
+	}
+	// the advance and bounds of glyphs that do not give their own
+	advance := 0
+	var bounds image.Rectangle
+	for next() {
+		switch fields[0] {
+		case "FONTBOUNDINGBOX":
+			v, ok := ints(4)
+			if !ok {
+				return nil, bad()
+			}
+			if bounds, ok = bdfBounds(v); !ok {
+				return nil, bad()
+			}
+			f.ascent, f.descent = -bounds.Min.Y, bounds.Max.Y
+		case "DWIDTH":
+			v, ok := ints(1)
+			if !ok {
+				return nil, bad()
+			}
+			advance = v[0]
+		case "STARTPROPERTIES":
+			for next() && fields[0] != "ENDPROPERTIES" {
+				value := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), fields[0]))
+				f.properties[fields[0]] = strings.Trim(value, `"`)
+			}
+			f.defaultChar = f.property("DEFAULT_CHAR", -1)
+		case "STARTCHAR":
+			code := -1
+			g := &bitmapGlyph{advance: advance, mask: image.NewAlpha(bounds)}
+			for next() && fields[0] != "BITMAP" {
+				switch fields[0] {
+				case "ENCODING":
+					v, ok := ints(1)
+					if !ok {
+						return nil, bad()
+					}
+					code = v[0]
+				case "DWIDTH":
+					v, ok := ints(1)
+					if !ok {
+						return nil, bad()
+					}
+					g.advance = v[0]
+				case "BBX":
+					v, ok := ints(4)
+					if !ok {
+						return nil, bad()
+					}
+					b, ok := bdfBounds(v)
+					if !ok {
+						return nil, bad()
+					}
+					g = newBitmapGlyph(g.advance, b)
+				}
+			}
+			size := g.mask.Rect.Size()
+			for y := 0; next() && fields[0] != "ENDCHAR"; y++ {
+				row := fields[0]
+				if len(row)%2 != 0 {
+					row += "0"
+				}
+				bits, err := hex.DecodeString(row)
+				if err != nil || y >= size.Y {
+					return nil, bad()
+				}
+				for x := 0; x < size.X && x/8 < len(bits); x++ {
+					if bits[x/8]&(0x80>>uint(x%8)) != 0 {
+						g.set(x, y)
+					}
+				}
+			}
+			// unencoded glyphs have a code of -1
+			if code >= 0 {
+				f.glyphs[code] = g
+			}
+		case "ENDFONT":
+			return f, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("gg: BDF font has no ENDFONT")
+}
+
+// bdfBounds returns the bounds, with Y growing down, of the bounding box
+// given by the width, height and the offsets of the lower left corner of a
+// BBX or FONTBOUNDINGBOX line. ok is false for negative sizes and for boxes
+// beyond maxBitmapGlyphSize.
+func bdfBounds(v []int) (r image.Rectangle, ok bool) {
+	for _, x := range v {
+		if x < -maxBitmapGlyphSize || x > maxBitmapGlyphSize {
+			return r, false
+		}
+	}
+	if v[0] < 0 || v[1] < 0 {
+		return r, false
+	}
+	r = image.Rect(v[2], -v[1]-v[3], v[2]+v[0], -v[3])
+	return r, validBitmapBounds(r)
+}
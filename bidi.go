@@ -0,0 +1,603 @@
+package gg
+
+import "golang.org/x/text/unicode/bidi"
+
+// TextDirection is the base direction of a paragraph of text.
+type TextDirection int
+
+const (
+	// TextDirectionAuto takes the direction of each paragraph from its first
+	// strong character, as in rules P2 and P3 of the Unicode Bidirectional
+	// Algorithm. Paragraphs without strong characters are left to right.
+	TextDirectionAuto TextDirection = iota
+	TextDirectionLTR
+	TextDirectionRTL
+)
+
+// SetTextDirection sets the base direction used to lay out text. Mixed
+// left-to-right and right-to-left text is always reordered for display
+// according to the Unicode Bidirectional Algorithm; the base direction
+// decides how the runs are arranged and which side of the box AlignLeft and
+// AlignRight refer to in DrawStringWrapped.
+func (dc *Context) SetTextDirection(direction TextDirection) {
+	dc.textDirection = direction
+}
+
+// maxBidiDepth is the maximum explicit embedding level (BD2).
+const maxBidiDepth = 125
+
+// bidiRun is a range of runes, in logical order, that share an embedding
+// level and are therefore laid out in a single direction.
+type bidiRun struct {
+	start, end int
+	level      uint8
+}
+
+func (r bidiRun) rtl() bool {
+	return r.level&1 == 1
+}
+
+// bidiClasses returns the bidi class of each rune of text.
+func bidiClasses(text []rune) []bidi.Class {
+	classes := make([]bidi.Class, len(text))
+	for i, r := range text {
+		p, _ := bidi.LookupRune(r)
+		classes[i] = p.Class()
+	}
+	return classes
+}
+
+// firstStrong finds the first strong character from start on, skipping
+// isolated text (P2). If stopAtPDI is set the search ends at an unmatched
+// PDI, as it does for the text of an FSI.
+func firstStrong(classes []bidi.Class, start int, stopAtPDI bool) (rtl, ok bool) {
+	depth := 0
+	for _, c := range classes[start:] {
+		switch c {
+		case bidi.L:
+			if depth == 0 {
+				return false, true
+			}
+		case bidi.R, bidi.AL:
+			if depth == 0 {
+				return true, true
+			}
+		case bidi.LRI, bidi.RLI, bidi.FSI:
+			depth++
+		case bidi.PDI:
+			if depth > 0 {
+				depth--
+			} else if stopAtPDI {
+				return false, false
+			}
+		case bidi.B:
+			return false, false
+		}
+	}
+	return false, false
+}
+
+// paragraphRTL reports whether a paragraph with the given base direction is
+// laid out right to left.
+func paragraphRTL(text []rune, direction TextDirection) bool {
+	switch direction {
+	case TextDirectionLTR:
+		return false
+	case TextDirectionRTL:
+		return true
+	}
+	rtl, _ := firstStrong(bidiClasses(text), 0, false)
+	return rtl
+}
+
+// bidiLayout resolves the embedding levels of a single line of text and
+// returns its runs in visual order, from left to right.
+func bidiLayout(text []rune, direction TextDirection) []bidiRun {
+	if len(text) == 0 {
+		return nil
+	}
+	classes := bidiClasses(text)
+	var para uint8
+	switch direction {
+	case TextDirectionRTL:
+		para = 1
+	case TextDirectionAuto:
+		if rtl, _ := firstStrong(classes, 0, false); rtl {
+			para = 1
+		}
+	}
+	return reorderRuns(bidiLevels(classes, text, para))
+}
+
+// bidiLevels implements rules X1 to I2 and L1 of the Unicode Bidirectional
+// Algorithm (UAX #9), returning the resolved embedding level of each rune.
+func bidiLevels(classes []bidi.Class, text []rune, para uint8) []uint8 {
+	n := len(classes)
+	types := make([]bidi.Class, n)
+	copy(types, classes)
+	levels := make([]uint8, n)
+
+	// X1-X8: explicit levels and directions
+	type status struct {
+		level    uint8
+		override bidi.Class // ON for none
+		isolate  bool
+	}
+	stack := []status{{para, bidi.ON, false}}
+	overflowIsolates, overflowEmbeddings, validIsolates := 0, 0, 0
+	next := func(rtl bool) uint8 {
+		l := stack[len(stack)-1].level + 1
+		if (l&1 == 1) != rtl {
+			l++
+		}
+		return l
+	}
+	for i, c := range classes {
+		top := stack[len(stack)-1]
+		levels[i] = top.level
+		switch c {
+		case bidi.RLE, bidi.LRE, bidi.RLO, bidi.LRO:
+			l := next(c == bidi.RLE || c == bidi.RLO)
+			if l <= maxBidiDepth && overflowIsolates == 0 && overflowEmbeddings == 0 {
+				s := status{l, bidi.ON, false}
+				if c == bidi.RLO {
+					s.override = bidi.R
+				} else if c == bidi.LRO {
+					s.override = bidi.L
+				}
+				stack = append(stack, s)
+			} else if overflowIsolates == 0 {
+				overflowEmbeddings++
+			}
+			types[i] = bidi.BN
+		case bidi.RLI, bidi.LRI, bidi.FSI:
+			if top.override != bidi.ON {
+				types[i] = top.override
+			}
+			rtl := c == bidi.RLI
+			if c == bidi.FSI {
+				rtl, _ = firstStrong(classes, i+1, true)
+			}
+			l := next(rtl)
+			if l <= maxBidiDepth && overflowIsolates == 0 && overflowEmbeddings == 0 {
+				validIsolates++
+				stack = append(stack, status{l, bidi.ON, true})
+			} else {
+				overflowIsolates++
+			}
+		case bidi.PDI:
+			if overflowIsolates > 0 {
+				overflowIsolates--
+			} else if validIsolates > 0 {
+				overflowEmbeddings = 0
+				for !stack[len(stack)-1].isolate {
+					stack = stack[:len(stack)-1]
+				}
+				stack = stack[:len(stack)-1]
+				validIsolates--
+			}
+			top = stack[len(stack)-1]
+			levels[i] = top.level
+			if top.override != bidi.ON {
+				types[i] = top.override
+			}
+		case bidi.PDF:
+			switch {
+			case overflowIsolates > 0:
+			case overflowEmbeddings > 0:
+				overflowEmbeddings--
+			case !top.isolate && len(stack) > 1:
+				stack = stack[:len(stack)-1]
+			}
+			types[i] = bidi.BN
+		case bidi.B:
+			levels[i] = para
+			stack = stack[:1]
+			overflowIsolates, overflowEmbeddings, validIsolates = 0, 0, 0
+		case bidi.BN:
+		default:
+			if top.override != bidi.ON {
+				types[i] = top.override
+			}
+		}
+	}
+
+	// X9: characters of type BN are ignored from here on
+	var idx []int
+	for i, t := range types {
+		if t != bidi.BN {
+			idx = append(idx, i)
+		}
+	}
+
+	// BD9: matching isolate initiators and PDIs
+	match := make(map[int]int)
+	var open []int
+	for _, i := range idx {
+		switch classes[i] {
+		case bidi.LRI, bidi.RLI, bidi.FSI:
+			open = append(open, i)
+		case bidi.PDI:
+			if len(open) > 0 {
+				match[open[len(open)-1]] = i
+				open = open[:len(open)-1]
+			}
+		case bidi.B:
+			open = open[:0]
+		}
+	}
+
+	// X10: level runs linked into isolating run sequences
+	var runs [][]int
+	runOf := make(map[int]int) // first rune of a level run -> run
+	for k, i := range idx {
+		if k == 0 || levels[i] != levels[idx[k-1]] {
+			runOf[i] = len(runs)
+			runs = append(runs, nil)
+		}
+		runs[len(runs)-1] = append(runs[len(runs)-1], i)
+	}
+	isolated := make(map[int]bool) // level runs that continue a sequence
+	for _, run := range runs {
+		last := run[len(run)-1]
+		if j, ok := match[last]; ok {
+			if r, ok := runOf[j]; ok {
+				isolated[r] = true
+			}
+		}
+	}
+	pos := make(map[int]int) // rune -> position in idx
+	for k, i := range idx {
+		pos[i] = k
+	}
+	explicit := append([]uint8(nil), levels...)
+	for r, run := range runs {
+		if isolated[r] {
+			continue
+		}
+		seq := append([]int(nil), run...)
+		for {
+			last := seq[len(seq)-1]
+			j, ok := match[last]
+			if !ok {
+				break
+			}
+			cont, ok := runOf[j]
+			if !ok {
+				break
+			}
+			seq = append(seq, runs[cont]...)
+		}
+		level := explicit[seq[0]]
+		before, after := para, para
+		if k := pos[seq[0]]; k > 0 {
+			before = explicit[idx[k-1]]
+		}
+		last := seq[len(seq)-1]
+		switch classes[last] {
+		case bidi.LRI, bidi.RLI, bidi.FSI:
+		default:
+			if k := pos[last]; k+1 < len(idx) {
+				after = explicit[idx[k+1]]
+			}
+		}
+		sos, eos := levelClass(maxLevel(level, before)), levelClass(maxLevel(level, after))
+		resolveWeak(types, classes, seq, sos)
+		resolveBrackets(types, classes, text, seq, level, sos)
+		resolveNeutral(types, seq, level, sos, eos)
+		for _, i := range seq {
+			t := types[i]
+			if level&1 == 0 {
+				if t == bidi.R {
+					levels[i]++
+				} else if t == bidi.AN || t == bidi.EN {
+					levels[i] += 2
+				}
+			} else if t == bidi.L || t == bidi.EN || t == bidi.AN {
+				levels[i]++
+			}
+		}
+	}
+
+	// removed characters take the level of the preceding character
+	for i, t := range types {
+		if t == bidi.BN {
+			if i > 0 {
+				levels[i] = levels[i-1]
+			} else {
+				levels[i] = para
+			}
+		}
+	}
+
+	// L1: separators and trailing whitespace return to the paragraph level
+	trailing := true
+	for i := n - 1; i >= 0; i-- {
+		switch classes[i] {
+		case bidi.S, bidi.B:
+			levels[i] = para
+			trailing = true
+		case bidi.WS, bidi.LRI, bidi.RLI, bidi.FSI, bidi.PDI, bidi.BN,
+			bidi.LRE, bidi.RLE, bidi.LRO, bidi.RLO, bidi.PDF:
+			if trailing {
+				levels[i] = para
+			}
+		default:
+			trailing = false
+		}
+	}
+	return levels
+}
+
+func maxLevel(a, b uint8) uint8 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func levelClass(level uint8) bidi.Class {
+	if level&1 == 1 {
+		return bidi.R
+	}
+	return bidi.L
+}
+
+// resolveWeak applies rules W1 to W7 to an isolating run sequence.
+func resolveWeak(types, classes []bidi.Class, seq []int, sos bidi.Class) {
+	// W1
+	prev := sos
+	for _, i := range seq {
+		if types[i] == bidi.NSM {
+			types[i] = prev
+		}
+		switch classes[i] {
+		case bidi.LRI, bidi.RLI, bidi.FSI, bidi.PDI:
+			prev = bidi.ON
+		default:
+			prev = types[i]
+		}
+	}
+	// W2, W3
+	strong := sos
+	for _, i := range seq {
+		switch types[i] {
+		case bidi.L, bidi.R:
+			strong = types[i]
+		case bidi.AL:
+			strong = bidi.AL
+			types[i] = bidi.R
+		case bidi.EN:
+			if strong == bidi.AL {
+				types[i] = bidi.AN
+			}
+		}
+	}
+	// W4
+	for k := 1; k+1 < len(seq); k++ {
+		t, a, b := types[seq[k]], types[seq[k-1]], types[seq[k+1]]
+		if t == bidi.ES && a == bidi.EN && b == bidi.EN {
+			types[seq[k]] = bidi.EN
+		} else if t == bidi.CS && a == b && (a == bidi.EN || a == bidi.AN) {
+			types[seq[k]] = a
+		}
+	}
+	// W5
+	for k := 0; k < len(seq); k++ {
+		if types[seq[k]] != bidi.ET {
+			continue
+		}
+		end := k
+		for end < len(seq) && types[seq[end]] == bidi.ET {
+			end++
+		}
+		if (k > 0 && types[seq[k-1]] == bidi.EN) || (end < len(seq) && types[seq[end]] == bidi.EN) {
+			for ; k < end; k++ {
+				types[seq[k]] = bidi.EN
+			}
+		}
+		k = end - 1
+	}
+	// W6, W7
+	strong = sos
+	for _, i := range seq {
+		switch types[i] {
+		case bidi.ES, bidi.ET, bidi.CS:
+			types[i] = bidi.ON
+		case bidi.L, bidi.R:
+			strong = types[i]
+		case bidi.EN:
+			if strong == bidi.L {
+				types[i] = bidi.L
+			}
+		}
+	}
+}
+
+// strongDirection maps a resolved type to the direction it counts as when
+// resolving brackets and neutrals, or ON if it is neutral.
+func strongDirection(t bidi.Class) bidi.Class {
+	switch t {
+	case bidi.L:
+		return bidi.L
+	case bidi.R, bidi.EN, bidi.AN:
+		return bidi.R
+	}
+	return bidi.ON
+}
+
+// resolveBrackets applies rule N0 to an isolating run sequence.
+func resolveBrackets(types, classes []bidi.Class, text []rune, seq []int, level uint8, sos bidi.Class) {
+	// BD16: find bracket pairs
+	type pair struct{ open, close int }
+	type opener struct {
+		close rune
+		k     int
+	}
+	var pairs []pair
+	var stack []opener
+	for k, i := range seq {
+		if types[i] != bidi.ON {
+			continue
+		}
+		r := canonicalBracket(text[i])
+		p, _ := bidi.LookupRune(r)
+		if !p.IsBracket() {
+			continue
+		}
+		if p.IsOpeningBracket() {
+			m, ok := mirrorRunes[r]
+			if !ok {
+				continue
+			}
+			if len(stack) == 63 {
+				break
+			}
+			stack = append(stack, opener{m, k})
+			continue
+		}
+		for j := len(stack) - 1; j >= 0; j-- {
+			if stack[j].close == r {
+				pairs = append(pairs, pair{stack[j].k, k})
+				stack = stack[:j]
+				break
+			}
+		}
+	}
+	// pairs must be processed in order of their opening brackets
+	for a := 1; a < len(pairs); a++ {
+		for b := a; b > 0 && pairs[b].open < pairs[b-1].open; b-- {
+			pairs[b], pairs[b-1] = pairs[b-1], pairs[b]
+		}
+	}
+
+	embedding := levelClass(level)
+	for _, p := range pairs {
+		found := bidi.ON
+		for k := p.open + 1; k < p.close; k++ {
+			d := strongDirection(types[seq[k]])
+			if d == embedding {
+				found = d
+				break
+			}
+			if d != bidi.ON {
+				found = d
+			}
+		}
+		if found == bidi.ON {
+			continue
+		}
+		if found != embedding {
+			context := sos
+			for k := p.open - 1; k >= 0; k-- {
+				if d := strongDirection(types[seq[k]]); d != bidi.ON {
+					context = d
+					break
+				}
+			}
+			if context != found {
+				found = embedding
+			}
+		}
+		for _, k := range []int{p.open, p.close} {
+			types[seq[k]] = found
+			for k++; k < len(seq) && classes[seq[k]] == bidi.NSM; k++ {
+				types[seq[k]] = found
+			}
+		}
+	}
+}
+
+// resolveNeutral applies rules N1 and N2 to an isolating run sequence.
+func resolveNeutral(types []bidi.Class, seq []int, level uint8, sos, eos bidi.Class) {
+	for k := 0; k < len(seq); k++ {
+		if strongDirection(types[seq[k]]) != bidi.ON {
+			continue
+		}
+		end := k
+		for end < len(seq) && strongDirection(types[seq[end]]) == bidi.ON {
+			end++
+		}
+		before, after := sos, eos
+		if k > 0 {
+			before = strongDirection(types[seq[k-1]])
+		}
+		if end < len(seq) {
+			after = strongDirection(types[seq[end]])
+		}
+		d := levelClass(level)
+		if before == after {
+			d = before
+		}
+		for ; k < end; k++ {
+			types[seq[k]] = d
+		}
+		k = end - 1
+	}
+}
+
+// reorderRuns splits resolved levels into runs and arranges them in visual
+// order (L2).
+func reorderRuns(levels []uint8) []bidiRun {
+	var runs []bidiRun
+	for i, l := range levels {
+		if i == 0 || l != levels[i-1] {
+			runs = append(runs, bidiRun{start: i, level: l})
+		}
+		runs[len(runs)-1].end = i + 1
+	}
+	highest, lowestOdd := uint8(0), uint8(maxBidiDepth+2)
+	for _, r := range runs {
+		highest = maxLevel(highest, r.level)
+		if r.level&1 == 1 && r.level < lowestOdd {
+			lowestOdd = r.level
+		}
+	}
+	for l := highest; l >= lowestOdd && l > 0; l-- {
+		for i := 0; i < len(runs); i++ {
+			if runs[i].level < l {
+				continue
+			}
+			j := i
+			for j < len(runs) && runs[j].level >= l {
+				j++
+			}
+			for a, b := i, j-1; a < b; a, b = a+1, b-1 {
+				runs[a], runs[b] = runs[b], runs[a]
+			}
+			i = j
+		}
+	}
+	return runs
+}
+
+// canonicalBracket maps the deprecated angle brackets to their canonical
+// equivalents so that either form can close the other.
+func canonicalBracket(r rune) rune {
+	switch r {
+	case '\u2329':
+		return '\u3008'
+	case '\u232a':
+		return '\u3009'
+	}
+	return r
+}
+
+// mirrorRunes maps characters to the mirrored glyphs used to display them
+// in right-to-left text (L4). Shaped faces mirror through the font instead.
+var mirrorRunes = map[rune]rune{}
+
+func init() {
+	pairs := []string{
+		"()", "[]", "{}", "<>", "«»", "‹›", "≤≥", "≦≧", "≪≫", "⁅⁆", "⁽⁾", "₍₎",
+		"∈∋", "⊂⊃", "⊆⊇", "⌈⌉", "⌊⌋", "\u2329\u232a", "❨❩", "❪❫", "❬❭", "❮❯", "❰❱",
+		"❲❳", "❴❵", "⟅⟆", "⟦⟧", "⟨⟩", "⟪⟫", "⦃⦄", "⦅⦆", "⦇⦈", "⦉⦊", "〈〉", "《》",
+		"「」", "『』", "【】", "〔〕", "〖〗", "〘〙", "〚〛", "（）", "＜＞",
+		"［］", "｛｝", "｟｠", "｢｣",
+	}
+	for _, p := range pairs {
+		r := []rune(p)
+		mirrorRunes[r[0]] = r[1]
+		mirrorRunes[r[1]] = r[0]
+	}
+}
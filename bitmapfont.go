@@ -0,0 +1,208 @@
+package gg
+
+import (
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// bitmapFace is a font.Face of a bitmap font read from a BDF or PCF file.
+// Glyphs are drawn at whole pixels, and with the nearest pixel of the glyph
+// when the current matrix scales or rotates them, so that they stay sharp.
+// Bitmap fonts have no kerning. Unlike the faces of outline fonts, a
+// bitmapFace is safe for concurrent use.
+type bitmapFace struct {
+	glyphs  map[rune]*bitmapGlyph
+	metrics font.Metrics
+
+	// underline position and thickness in pixels, or 0 when the font does
+	// not give them
+	underlinePos, underlineThick int
+}
+
+// bitmapGlyph is a glyph of a bitmap font. The bounds of the mask are the
+// ink bounds of the glyph relative to its origin, with Y growing down.
+type bitmapGlyph struct {
+	advance int
+	mask    *image.Alpha
+}
+
+// maxBitmapGlyphSize is the largest width and height, and the largest
+// offset from the origin, of the glyphs of bitmap fonts, so that corrupt
+// fonts cannot make huge masks.
+const maxBitmapGlyphSize = 1 << 12
+
+// validBitmapBounds reports whether the ink bounds of a bitmap glyph are
+// within maxBitmapGlyphSize.
+func validBitmapBounds(r image.Rectangle) bool {
+	inRange := func(v int) bool {
+		return v >= -maxBitmapGlyphSize && v <= maxBitmapGlyphSize
+	}
+	return inRange(r.Min.X) && inRange(r.Min.Y) && inRange(r.Max.X) && inRange(r.Max.Y) &&
+		r.Dx() <= maxBitmapGlyphSize && r.Dy() <= maxBitmapGlyphSize
+}
+
+// newBitmapGlyph returns a glyph without ink with the given ink bounds,
+// whose pixels are then set with set.
+func newBitmapGlyph(advance int, bounds image.Rectangle) *bitmapGlyph {
+	return &bitmapGlyph{advance: advance, mask: image.NewAlpha(bounds.Canon())}
+}
+
+// set inks the pixel x, y of g, counted from the top left corner of its
+// bounds.
+func (g *bitmapGlyph) set(x, y int) {
+	r := g.mask.Rect
+	g.mask.Pix[g.mask.PixOffset(r.Min.X+x, r.Min.Y+y)] = 0xff
+}
+
+// bitmapFont is a bitmap font as read from a file, with its glyphs indexed
+// by their code in the encoding of the font, before it is made a face.
+type bitmapFont struct {
+	properties map[string]string
+	ascent     int
+	descent    int
+	glyphs     map[int]*bitmapGlyph
+	// defaultChar is the code of the glyph drawn for missing characters,
+	// or -1
+	defaultChar int
+}
+
+// property returns the integer property name of f, or def if f does not
+// have it.
+func (f *bitmapFont) property(name string, def int) int {
+	if v, err := strconv.Atoi(f.properties[name]); err == nil {
+		return v
+	}
+	return def
+}
+
+// face returns a face for f with the glyphs mapped to Unicode from the
+// charset given by the CHARSET_REGISTRY and CHARSET_ENCODING properties of
+// the font. The default character of the font, if any, is used for U+FFFD
+// when the font has no glyph for it, so that it is drawn for missing
+// characters.
+func (f *bitmapFont) face() *bitmapFace {
+	decode := charsetDecoder(f.properties["CHARSET_REGISTRY"], f.properties["CHARSET_ENCODING"])
+	face := &bitmapFace{glyphs: map[rune]*bitmapGlyph{}}
+	for code, g := range f.glyphs {
+		if r, ok := decode(code); ok {
+			face.glyphs[r] = g
+		}
+	}
+	if g, ok := f.glyphs[f.defaultChar]; ok && face.glyphs[unicode.ReplacementChar] == nil {
+		face.glyphs[unicode.ReplacementChar] = g
+	}
+
+	ascent := f.property("FONT_ASCENT", f.ascent)
+	descent := f.property("FONT_DESCENT", f.descent)
+	// the ink of x and H stands for the x-height and cap height of fonts
+	// that do not give them
+	height := func(name string, r rune) int {
+		def := 0
+		if g, ok := face.glyphs[r]; ok {
+			def = -g.mask.Rect.Min.Y
+		}
+		return f.property(name, def)
+	}
+	face.metrics = font.Metrics{
+		Height:     fixed.I(ascent + descent),
+		Ascent:     fixed.I(ascent),
+		Descent:    fixed.I(descent),
+		XHeight:    fixed.I(height("X_HEIGHT", 'x')),
+		CapHeight:  fixed.I(height("CAP_HEIGHT", 'H')),
+		CaretSlope: image.Point{X: 0, Y: 1},
+	}
+	face.underlinePos = f.property("UNDERLINE_POSITION", 0)
+	face.underlineThick = f.property("UNDERLINE_THICKNESS", 0)
+	return face
+}
+
+// charsetDecoder returns a function that maps the codes of a font in the
+// charset given by the XLFD registry and encoding names, such as
+// "ISO8859" and "2", to runes. Unicode fonts, ISO 8859-1 fonts and fonts
+// in unknown or font specific charsets are taken to use Unicode code
+// points.
+func charsetDecoder(registry, encoding string) func(code int) (rune, bool) {
+	unicodeCodes := func(code int) (rune, bool) {
+		return rune(code), code >= 0 && code <= unicode.MaxRune
+	}
+	name := normalizeCharset(registry + encoding)
+	switch {
+	case strings.HasPrefix(name, "microsoftcp"):
+		name = "windows" + strings.TrimPrefix(name, "microsoftcp")
+	case strings.HasPrefix(name, "ibmcp"):
+		name = "ibmcodepage" + strings.TrimPrefix(name, "ibmcp")
+	}
+	for _, e := range charmap.All {
+		m, ok := e.(*charmap.Charmap)
+		if !ok || normalizeCharset(fmt.Sprint(m)) != name {
+			continue
+		}
+		return func(code int) (rune, bool) {
+			if code < 0 || code > 0xff {
+				return 0, false
+			}
+			r := m.DecodeByte(byte(code))
+			return r, r != unicode.ReplacementChar
+		}
+	}
+	return unicodeCodes
+}
+
+// normalizeCharset returns the letters and digits of a charset name in
+// lower case, so that "ISO8859-2" and "ISO 8859-2" are the same.
+func normalizeCharset(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, name)
+}
+
+func (f *bitmapFace) Close() error {
+	return nil
+}
+
+// Glyph returns the glyph of r with its origin at dot rounded to whole
+// pixels.
+func (f *bitmapFace) Glyph(dot fixed.Point26_6, r rune) (dr image.Rectangle, mask image.Image, maskp image.Point, advance fixed.Int26_6, ok bool) {
+	g, ok := f.glyphs[r]
+	if !ok {
+		return image.Rectangle{}, nil, image.Point{}, 0, false
+	}
+	origin := image.Pt(dot.X.Round(), dot.Y.Round())
+	return g.mask.Rect.Add(origin), g.mask, g.mask.Rect.Min, fixed.I(g.advance), true
+}
+
+func (f *bitmapFace) GlyphBounds(r rune) (bounds fixed.Rectangle26_6, advance fixed.Int26_6, ok bool) {
+	g, ok := f.glyphs[r]
+	if !ok {
+		return fixed.Rectangle26_6{}, 0, false
+	}
+	b := g.mask.Rect
+	bounds = fixed.R(b.Min.X, b.Min.Y, b.Max.X, b.Max.Y)
+	return bounds, fixed.I(g.advance), true
+}
+
+func (f *bitmapFace) GlyphAdvance(r rune) (advance fixed.Int26_6, ok bool) {
+	g, ok := f.glyphs[r]
+	if !ok {
+		return 0, false
+	}
+	return fixed.I(g.advance), true
+}
+
+func (f *bitmapFace) Kern(r0, r1 rune) fixed.Int26_6 {
+	return 0
+}
+
+func (f *bitmapFace) Metrics() font.Metrics {
+	return f.metrics
+}
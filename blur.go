@@ -0,0 +1,91 @@
+package gg
+
+import "math"
+
+// boxesForGaussian returns the radii of n successive box blurs that together
+// approximate a Gaussian blur with the given standard deviation.
+// See http://www.peterkovesi.com/papers/FastGaussianSmoothing.pdf
+func boxesForGaussian(sigma float64, n int) []int {
+	wIdeal := math.Sqrt(12*sigma*sigma/float64(n) + 1)
+	wl := int(math.Floor(wIdeal))
+	if wl%2 == 0 {
+		wl--
+	}
+	wu := wl + 2
+	mIdeal := (12*sigma*sigma - float64(n*wl*wl) - float64(4*n*wl) - float64(3*n)) / float64(-4*wl-4)
+	m := int(math.Floor(mIdeal + 0.5))
+	radii := make([]int, n)
+	for i := range radii {
+		if i < m {
+			radii[i] = (wl - 1) / 2
+		} else {
+			radii[i] = (wu - 1) / 2
+		}
+	}
+	return radii
+}
+
+// gaussianReach returns how many pixels away gaussianBlur spreads a pixel
+// with the given standard deviation.
+func gaussianReach(sigma float64) int {
+	if sigma <= 0 {
+		return 0
+	}
+	reach := 0
+	for _, r := range boxesForGaussian(sigma, 3) {
+		if r > 0 {
+			reach += r
+		}
+	}
+	return reach
+}
+
+// gaussianBlur blurs the interleaved 8-bit pixel data in pix, which holds
+// channels bytes per pixel. Pixels outside of the image are treated as zero.
+func gaussianBlur(pix []uint8, w, h, stride, channels int, sigma float64) {
+	if sigma <= 0 || w <= 0 || h <= 0 {
+		return
+	}
+	tmp := make([]uint8, len(pix))
+	for _, r := range boxesForGaussian(sigma, 3) {
+		boxBlur(pix, tmp, w, h, stride, channels, r)
+	}
+}
+
+// boxBlur applies a box blur of the given radius to pix, using tmp (which
+// must be the same size as pix) as scratch space.
+func boxBlur(pix, tmp []uint8, w, h, stride, channels, r int) {
+	if r <= 0 {
+		return
+	}
+	boxBlurLines(pix, tmp, h, w, stride, channels, channels, r)
+	boxBlurLines(tmp, pix, w, h, channels, stride, channels, r)
+}
+
+// boxBlurLines blurs n lines of length pixels each from src into dst. Lines
+// start lineStep bytes apart and consecutive pixels of a line are pixelStep
+// bytes apart, which lets the same code run horizontal and vertical passes.
+func boxBlurLines(src, dst []uint8, n, length, lineStep, pixelStep, channels, r int) {
+	d := 2*r + 1
+	for line := 0; line < n; line++ {
+		base := line * lineStep
+		for c := 0; c < channels; c++ {
+			at := func(i int) int {
+				return base + i*pixelStep + c
+			}
+			sum := 0
+			for i := 0; i <= r && i < length; i++ {
+				sum += int(src[at(i)])
+			}
+			for i := 0; i < length; i++ {
+				dst[at(i)] = uint8((sum + d/2) / d)
+				if j := i + r + 1; j < length {
+					sum += int(src[at(j)])
+				}
+				if j := i - r; j >= 0 {
+					sum -= int(src[at(j)])
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package gg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math"
+
+	otfont "github.com/go-text/typesetting/font"
+	"github.com/go-text/typesetting/font/opentype"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/math/f64"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/image/tiff"
+)
+
+// colorLayer is a layer of a colour glyph of a COLR table: the outline of
+// glyph id filled with color, or with the color of the text if color is nil.
+type colorLayer struct {
+	id    otfont.GID
+	color color.Color
+}
+
+// parseColorGlyphs reads the layered colour glyphs of the version 0 COLR
+// table of a font, with the colors of the first palette of its CPAL table.
+// It returns nil for fonts without colour glyphs or with invalid tables.
+func parseColorGlyphs(ld *opentype.Loader) map[otfont.GID][]colorLayer {
+	colr, err := ld.RawTable(opentype.MustNewTag("COLR"))
+	if err != nil || len(colr) < 14 {
+		return nil
+	}
+	be := binary.BigEndian
+	numBase := int(be.Uint16(colr[2:]))
+	baseOffset := int(be.Uint32(colr[4:]))
+	layerOffset := int(be.Uint32(colr[8:]))
+	numLayers := int(be.Uint16(colr[12:]))
+	if baseOffset+6*numBase > len(colr) || layerOffset+4*numLayers > len(colr) {
+		return nil
+	}
+	palette := parsePalette(ld)
+	glyphs := make(map[otfont.GID][]colorLayer, numBase)
+	for i := 0; i < numBase; i++ {
+		base := colr[baseOffset+6*i:]
+		first, n := int(be.Uint16(base[2:])), int(be.Uint16(base[4:]))
+		if first+n > numLayers {
+			continue
+		}
+		layers := make([]colorLayer, n)
+		for j := range layers {
+			layer := colr[layerOffset+4*(first+j):]
+			layers[j].id = otfont.GID(be.Uint16(layer))
+			// the index 0xFFFF selects the color of the text
+			if k := int(be.Uint16(layer[2:])); k < len(palette) {
+				layers[j].color = palette[k]
+			}
+		}
+		glyphs[otfont.GID(be.Uint16(base))] = layers
+	}
+	return glyphs
+}
+
+// parsePalette returns the colors of the first palette of the CPAL table of
+// a font.
+func parsePalette(ld *opentype.Loader) []color.Color {
+	cpal, err := ld.RawTable(opentype.MustNewTag("CPAL"))
+	if err != nil || len(cpal) < 14 || binary.BigEndian.Uint16(cpal[4:]) == 0 {
+		return nil
+	}
+	be := binary.BigEndian
+	numEntries := int(be.Uint16(cpal[2:]))
+	numRecords := int(be.Uint16(cpal[6:]))
+	recordsOffset := int(be.Uint32(cpal[8:]))
+	first := int(be.Uint16(cpal[12:]))
+	if first+numEntries > numRecords || recordsOffset+4*numRecords > len(cpal) {
+		return nil
+	}
+	palette := make([]color.Color, numEntries)
+	for i := range palette {
+		// colors are stored as BGRA
+		c := cpal[recordsOffset+4*(first+i):]
+		palette[i] = color.NRGBA{c[2], c[1], c[0], c[3]}
+	}
+	return palette
+}
+
+// bitmap returns the image of glyph id from the sbix, CBDT or EBDT bitmap
+// strike of f closest to its size. Black and white images are returned as an
+// *image.Alpha. ok is false if the glyph has no bitmap or it cannot be
+// decoded.
+func (f *shapedFace) bitmap(id otfont.GID) (im image.Image, ok bool) {
+	if im, ok := f.bitmaps[id]; ok {
+		return im, im != nil
+	}
+	if data, isBitmap := f.ot.GlyphData(id).(otfont.GlyphBitmap); isBitmap {
+		im = decodeBitmap(data)
+	}
+	if f.bitmaps == nil {
+		f.bitmaps = map[otfont.GID]image.Image{}
+	}
+	f.bitmaps[id] = im
+	return im, im != nil
+}
+
+// decodeBitmap decodes the image of a bitmap glyph, or returns nil.
+func decodeBitmap(b otfont.GlyphBitmap) image.Image {
+	var im image.Image
+	var err error
+	switch b.Format {
+	case otfont.PNG:
+		im, err = png.Decode(bytes.NewReader(b.Data))
+	case otfont.JPG:
+		im, err = jpeg.Decode(bytes.NewReader(b.Data))
+	case otfont.TIFF:
+		im, err = tiff.Decode(bytes.NewReader(b.Data))
+	case otfont.BlackAndWhite:
+		// one bit per pixel, most significant bit first, rows not padded
+		alpha := image.NewAlpha(image.Rect(0, 0, b.Width, b.Height))
+		for i := range alpha.Pix {
+			if b.Data[i/8]&(0x80>>uint(i%8)) != 0 {
+				alpha.Pix[i] = 0xff
+			}
+		}
+		return alpha
+	default:
+		return nil
+	}
+	if err != nil {
+		return nil
+	}
+	return im
+}
+
+// bitmapBounds returns the position, relative to the origin of the glyph,
+// and the size at which the bitmap of glyph id is drawn.
+func (f *shapedFace) bitmapBounds(id otfont.GID) (x, y, w, h float64) {
+	e, _ := f.ot.GlyphExtents(id)
+	scale := f.size / float64(f.ot.Upem())
+	return float64(e.XBearing) * scale, -float64(e.YBearing) * scale,
+		float64(e.Width) * scale, -float64(e.Height) * scale
+}
+
+// bitmapMask returns the coverage mask of the bitmap of glyph id with its
+// origin at dot, for glyphs without outlines.
+func (f *shapedFace) bitmapMask(dot fixed.Point26_6, id otfont.GID) (image.Rectangle, *image.Alpha, bool) {
+	im, ok := f.bitmap(id)
+	if !ok {
+		return image.Rectangle{}, nil, false
+	}
+	x, y, w, h := f.bitmapBounds(id)
+	x += unfix(dot.X)
+	y += unfix(dot.Y)
+	dr := image.Rect(int(math.Floor(x)), int(math.Floor(y)), int(math.Ceil(x+w)), int(math.Ceil(y+h)))
+	if dr.Empty() {
+		return image.Rectangle{}, nil, false
+	}
+	mask := image.NewAlpha(image.Rect(0, 0, dr.Dx(), dr.Dy()))
+	draw.BiLinear.Scale(mask, mask.Bounds(), im, im.Bounds(), draw.Src, nil)
+	return dr, mask, true
+}
+
+// drawColorGlyph draws g onto im if it is a colour glyph, painting the
+// layers that use the color of the text with src, and reports whether it
+// did. Layered glyphs are drawn layer by layer, and colour bitmaps are scaled
+// to the size of the text with the transformer set by SetTranformer.
+func (dc *Context) drawColorGlyph(im draw.Image, g glyph, dot fixed.Point26_6, src image.Image) bool {
+	f, ok := g.face.(*shapedFace)
+	if !ok {
+		return false
+	}
+	if layers, ok := f.font.colorGlyphs[g.id]; ok {
+		for _, layer := range layers {
+			layerSrc := src
+			if layer.color != nil {
+				layerSrc = image.NewUniform(layer.color)
+			}
+			dc.drawGlyphMask(im, glyph{face: f, id: layer.id}, dot, layerSrc)
+		}
+		return true
+	}
+	bitmap, ok := f.bitmap(g.id)
+	if !ok {
+		return false
+	}
+	if _, mono := bitmap.(*image.Alpha); mono {
+		// black and white bitmaps are drawn as masks in the color of the text
+		return false
+	}
+	x, y, w, h := f.bitmapBounds(g.id)
+	b := bitmap.Bounds()
+	m := dc.matrix.Translate(unfix(dot.X)+x, unfix(dot.Y)+y).
+		Scale(w/float64(b.Dx()), h/float64(b.Dy())).
+		Translate(-float64(b.Min.X), -float64(b.Min.Y))
+	s2d := f64.Aff3{m.XX, m.XY, m.X0, m.YX, m.YY, m.Y0}
+	dc.transformer.Transform(im, s2d, bitmap, b, draw.Over, nil)
+	return true
+}
@@ -12,11 +12,13 @@ import (
 	"math"
 	"strings"
 
+	otfont "github.com/go-text/typesetting/font"
 	"github.com/goki/freetype/truetype"
 	"github.com/golang/freetype/raster"
 	"golang.org/x/image/draw"
 	"golang.org/x/image/font"
 	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/font/sfnt"
 	"golang.org/x/image/math/f64"
 	"golang.org/x/image/math/fixed"
 )
@@ -49,6 +51,7 @@ const (
 	AlignLeft Align = iota
 	AlignCenter
 	AlignRight
+	AlignJustify
 )
 
 var (
@@ -57,35 +60,61 @@ var (
 )
 
 type Context struct {
-	width         int
-	height        int
-	rasterizer    *raster.Rasterizer
-	im            *image.RGBA
-	mask          *image.Alpha
-	clipRect      image.Rectangle
-	transformer   draw.Transformer
-	color         color.Color
-	fillPattern   Pattern
-	strokePattern Pattern
-	strokePath    raster.Path
-	fillPath      raster.Path
-	start         Point
-	current       Point
-	hasCurrent    bool
-	dashes        []float64
-	lineWidth     float64
-	lineCap       LineCap
-	lineJoin      LineJoin
-	fillRule      FillRule
-	fontFace      font.Face
-	fontHeight    float64
-	dpi           float64
-	fontSize      float64
-	fontScale     float64
-	matrix        Matrix
-	font          *truetype.Font
-	glyphBuf      *truetype.GlyphBuf
-	stack         []*Context
+	width          int
+	height         int
+	rasterizer     *raster.Rasterizer
+	im             *image.RGBA
+	mask           *image.Alpha
+	clipRect       image.Rectangle
+	transformer    draw.Transformer
+	color          color.Color
+	fillPattern    Pattern
+	strokePattern  Pattern
+	strokePath     raster.Path
+	fillPath       raster.Path
+	start          Point
+	current        Point
+	hasCurrent     bool
+	segments       []pathSegment
+	dashes         []float64
+	lineWidth      float64
+	lineCap        LineCap
+	lineJoin       LineJoin
+	fillRule       FillRule
+	fontFace       font.Face
+	fontHeight     float64
+	dpi            float64
+	fontSize       float64
+	fontScale      float64
+	matrix         Matrix
+	font           *truetype.Font
+	sfntFont       *sfnt.Font
+	otFont         *openTypeFont
+	glyphBuf       *truetype.GlyphBuf
+	textDirection  TextDirection
+	fontFallbacks  []font.Face
+	fontRegistry   *FontRegistry
+	fontVariations []otfont.Variation
+	wrapMode       WrapMode
+	writingMode    WritingMode
+	textDecoration TextDecoration
+	textDecorColor color.Color
+	skipInk        bool
+	letterSpacing  float64
+	wordSpacing    float64
+	tabInterval    float64
+	tabStops       []float64
+	hyphenator     Hyphenator
+	shadowOffsetX  float64
+	shadowOffsetY  float64
+	shadowBlur     float64
+	shadowColor    color.Color
+	filters        []Filter
+	antialias      Antialias
+	aaThreshold    float64
+	measures       *measureCache
+	usedGlyphs     *glyphUsage
+	stack          []*Context
 }
 
 // NewContext creates a new image.RGBA with the specified width and height
@@ -116,6 +145,7 @@ func NewContextForRGBA(im *image.RGBA) *Context {
 		strokePattern: defaultStrokeStyle,
 		lineWidth:     1,
 		fillRule:      FillRuleWinding,
+		aaThreshold:   0.5,
 		fontFace:      basicfont.Face7x13,
 		fontHeight:    13,
 		dpi:           72,
@@ -126,6 +156,42 @@ func NewContextForRGBA(im *image.RGBA) *Context {
 	}
 }
 
+// NewLayer creates a new transparent context with the specified width and
+// height that draws text like dc: it has the fonts, font face, fallbacks,
+// font registry and text settings of dc, and its antialiasing and image
+// transformer. The other state, such as the matrix, the colors and the
+// clipping mask, starts out as in NewContext. Layers are useful to draw
+// parts of an image separately before compositing them onto it.
+func (dc *Context) NewLayer(width, height int) *Context {
+	layer := NewContext(width, height)
+	layer.transformer = dc.transformer
+	layer.fontFace = dc.fontFace
+	layer.fontHeight = dc.fontHeight
+	layer.dpi = dc.dpi
+	layer.fontSize = dc.fontSize
+	layer.fontScale = dc.fontScale
+	layer.font = dc.font
+	layer.sfntFont = dc.sfntFont
+	layer.otFont = dc.otFont
+	layer.textDirection = dc.textDirection
+	layer.fontFallbacks = dc.fontFallbacks
+	layer.fontRegistry = dc.fontRegistry
+	layer.fontVariations = dc.fontVariations
+	layer.wrapMode = dc.wrapMode
+	layer.writingMode = dc.writingMode
+	layer.textDecoration = dc.textDecoration
+	layer.textDecorColor = dc.textDecorColor
+	layer.skipInk = dc.skipInk
+	layer.letterSpacing = dc.letterSpacing
+	layer.wordSpacing = dc.wordSpacing
+	layer.tabInterval = dc.tabInterval
+	layer.tabStops = dc.tabStops
+	layer.hyphenator = dc.hyphenator
+	layer.antialias = dc.antialias
+	layer.aaThreshold = dc.aaThreshold
+	return layer
+}
+
 // GetCurrentPoint will return the current point and if there is a current point.
 // The point will have been transformed by the context's transformation matrix.
 func (dc *Context) GetCurrentPoint() (Point, bool) {
@@ -135,6 +201,23 @@ func (dc *Context) GetCurrentPoint() (Point, bool) {
 	return Point{}, false
 }
 
+// PathBounds returns the bounding box of the current path in user space.
+// Curves are measured by their flattened outline. ok is false when the path
+// is empty.
+func (dc *Context) PathBounds() (x0, y0, x1, y1 float64, ok bool) {
+	for _, path := range dc.userPath() {
+		for _, p := range path {
+			if !ok {
+				x0, y0, x1, y1, ok = p.X, p.Y, p.X, p.Y, true
+				continue
+			}
+			x0, y0 = math.Min(x0, p.X), math.Min(y0, p.Y)
+			x1, y1 = math.Max(x1, p.X), math.Max(y1, p.Y)
+		}
+	}
+	return
+}
+
 // Image returns the image that has been drawn by this context.
 func (dc *Context) Image() image.Image {
 	return dc.im
@@ -324,6 +407,7 @@ func (dc *Context) MoveTo(x, y float64) {
 	p := Point{x, y}
 	dc.strokePath.Start(p.Fixed())
 	dc.fillPath.Start(p.Fixed())
+	dc.segments = append(dc.segments, pathSegment{op: segmentMoveTo, points: [3]Point{p}})
 	dc.start = p
 	dc.current = p
 	dc.hasCurrent = true
@@ -339,6 +423,7 @@ func (dc *Context) LineTo(x, y float64) {
 		p := Point{x, y}
 		dc.strokePath.Add1(p.Fixed())
 		dc.fillPath.Add1(p.Fixed())
+		dc.segments = append(dc.segments, pathSegment{op: segmentLineTo, points: [3]Point{p}})
 		dc.current = p
 	}
 }
@@ -356,6 +441,7 @@ func (dc *Context) QuadraticTo(x1, y1, x2, y2 float64) {
 	p2 := Point{x2, y2}
 	dc.strokePath.Add2(p1.Fixed(), p2.Fixed())
 	dc.fillPath.Add2(p1.Fixed(), p2.Fixed())
+	dc.segments = append(dc.segments, pathSegment{op: segmentQuadTo, points: [3]Point{p1, p2}})
 	dc.current = p2
 }
 
@@ -372,6 +458,7 @@ func (dc *Context) CubicTo(x1, y1, x2, y2, x3, y3 float64) {
 	x2, y2 = dc.TransformPoint(x2, y2)
 	x3, y3 = dc.TransformPoint(x3, y3)
 	points := CubicBezier(x0, y0, x1, y1, x2, y2, x3, y3)
+	dc.segments = append(dc.segments, pathSegment{op: segmentCubeTo, points: [3]Point{{x1, y1}, {x2, y2}, {x3, y3}}})
 	previous := dc.current.Fixed()
 	for _, p := range points[1:] {
 		f := p.Fixed()
@@ -392,6 +479,7 @@ func (dc *Context) ClosePath() {
 	if dc.hasCurrent {
 		dc.strokePath.Add1(dc.start.Fixed())
 		dc.fillPath.Add1(dc.start.Fixed())
+		dc.segments = append(dc.segments, pathSegment{op: segmentClose})
 		dc.current = dc.start
 	}
 }
@@ -401,6 +489,7 @@ func (dc *Context) ClosePath() {
 func (dc *Context) ClearPath() {
 	dc.strokePath.Clear()
 	dc.fillPath.Clear()
+	dc.segments = nil
 	dc.hasCurrent = false
 }
 
@@ -437,6 +526,120 @@ func (dc *Context) joiner() raster.Joiner {
 	return nil
 }
 
+// painter returns a raster.Painter that paints the pattern onto im through
+// the optional mask.
+func (dc *Context) painter(im *image.RGBA, mask *image.Alpha, pattern Pattern) raster.Painter {
+	if mask == nil {
+		if pattern, ok := pattern.(*solidPattern); ok {
+			// with a nil mask and a solid color pattern, we can be more efficient
+			// TODO: refactor so we don't have to do this type assertion stuff?
+			p := raster.NewRGBAPainter(im)
+			p.SetColor(pattern.color)
+			return p
+		}
+	}
+	return newPatternPainter(im, mask, pattern, dc.matrix, dc.im.Rect.Min)
+}
+
+// composite runs a drawing operation. render draws onto im through the
+// optional mask. When filters or shadows are active, the operation is first
+// rendered onto an offscreen layer which is filtered, casts its shadow and is
+// then composited onto the image through the clipping mask. The layer only
+// covers bounds, the rectangle of the image that the operation draws in, and
+// the pixels around it that the filters reach.
+func (dc *Context) composite(bounds image.Rectangle, render func(im *image.RGBA, mask *image.Alpha)) {
+	if len(dc.filters) == 0 && !dc.hasShadow() {
+		render(dc.im, dc.mask)
+		return
+	}
+	r := dc.im.Bounds()
+	if margin, ok := filtersMargin(dc.filters); ok {
+		r = bounds.Intersect(r).Inset(-margin).Intersect(r)
+	}
+	if r.Empty() {
+		return
+	}
+	layer := image.NewRGBA(r)
+	render(layer, nil)
+	for _, f := range dc.filters {
+		f.Apply(layer, r)
+	}
+	if dc.hasShadow() {
+		dc.drawShadow(layer)
+	}
+	if dc.mask == nil {
+		draw.Draw(dc.im, r, layer, r.Min, draw.Over)
+	} else {
+		draw.DrawMask(dc.im, r, layer, r.Min, dc.mask, r.Min, draw.Over)
+	}
+}
+
+// pathBounds returns the rectangle of the image that holds the points of a
+// path, with a margin of pad pixels.
+func pathBounds(path raster.Path, pad float64) image.Rectangle {
+	var points []fixed.Point26_6
+	for i := 0; i < len(path); {
+		n := 1
+		switch path[i] {
+		case 2:
+			n = 2
+		case 3:
+			n = 3
+		}
+		for j := 0; j < n; j++ {
+			points = append(points, fixed.Point26_6{X: path[i+1+2*j], Y: path[i+2+2*j]})
+		}
+		i += 2*n + 2
+	}
+	if len(points) == 0 {
+		return image.Rectangle{}
+	}
+	x0, y0, x1, y1 := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+	for _, p := range points {
+		x, y := unfix(p.X), unfix(p.Y)
+		x0, y0 = math.Min(x0, x), math.Min(y0, y)
+		x1, y1 = math.Max(x1, x), math.Max(y1, y)
+	}
+	return deviceRect(x0-pad, y0-pad, x1+pad, y1+pad)
+}
+
+// transformedBounds returns the rectangle of the image that holds the
+// rectangle x0, y0, x1, y1 transformed by m.
+func transformedBounds(m Matrix, x0, y0, x1, y1 float64) image.Rectangle {
+	bx0, by0, bx1, by1 := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+	for _, p := range [][2]float64{{x0, y0}, {x1, y0}, {x1, y1}, {x0, y1}} {
+		x, y := m.TransformPoint(p[0], p[1])
+		bx0, by0 = math.Min(bx0, x), math.Min(by0, y)
+		bx1, by1 = math.Max(bx1, x), math.Max(by1, y)
+	}
+	return deviceRect(bx0-1, by0-1, bx1+1, by1+1)
+}
+
+// glyphsBounds returns the rectangle of the image that holds glyphs drawn
+// with their origin at x, y under the matrix m. Each glyph is given a
+// margin of a line height of its face, for overhangs and decorations.
+func glyphsBounds(glyphs []glyph, x, y float64, m Matrix) image.Rectangle {
+	var r image.Rectangle
+	for _, g := range glyphs {
+		metrics := g.face.Metrics()
+		h := unfix(metrics.Height)
+		gx, gy := x+unfix(g.dot.X), y+unfix(g.dot.Y)
+		r = r.Union(transformedBounds(m, gx-h, gy-unfix(metrics.Ascent)-h,
+			gx+unfix(g.advance)+h, gy+unfix(metrics.Descent)+h))
+	}
+	return r
+}
+
+// deviceRect returns the rectangle of the pixels that the rectangle x0, y0,
+// x1, y1 touches, limited to the range of int32 so that it cannot
+// overflow.
+func deviceRect(x0, y0, x1, y1 float64) image.Rectangle {
+	clamp := func(v float64) int {
+		return int(math.Max(math.MinInt32, math.Min(math.MaxInt32, v)))
+	}
+	return image.Rect(clamp(math.Floor(x0)), clamp(math.Floor(y0)), clamp(math.Ceil(x1)), clamp(math.Ceil(y1)))
+}
+
 func (dc *Context) stroke(painter raster.Painter) {
 	path := dc.strokePath
 	if len(dc.dashes) > 0 {
@@ -450,7 +653,7 @@ func (dc *Context) stroke(painter raster.Painter) {
 	r.UseNonZeroWinding = true
 	r.Clear()
 	r.AddStroke(path, fix(dc.lineWidth), dc.capper(), dc.joiner())
-	r.Rasterize(painter)
+	r.Rasterize(dc.aliased(painter))
 }
 
 func (dc *Context) fill(painter raster.Painter) {
@@ -464,27 +667,17 @@ func (dc *Context) fill(painter raster.Painter) {
 	r.UseNonZeroWinding = dc.fillRule == FillRuleWinding
 	r.Clear()
 	r.AddPath(path)
-	r.Rasterize(painter)
+	r.Rasterize(dc.aliased(painter))
 }
 
 // StrokePreserve strokes the current path with the current color, line width,
 // line cap, line join and dash settings. The path is preserved after this
 // operation.
 func (dc *Context) StrokePreserve() {
-	var painter raster.Painter
-	if dc.mask == nil {
-		if pattern, ok := dc.strokePattern.(*solidPattern); ok {
-			// with a nil mask and a solid color pattern, we can be more efficient
-			// TODO: refactor so we don't have to do this type assertion stuff?
-			p := raster.NewRGBAPainter(dc.im)
-			p.SetColor(pattern.color)
-			painter = p
-		}
-	}
-	if painter == nil {
-		painter = newPatternPainter(dc.im, dc.mask, dc.strokePattern, dc.matrix)
-	}
-	dc.stroke(painter)
+	// no cap or join reaches further than the line width from the path
+	dc.composite(pathBounds(dc.strokePath, dc.lineWidth+1), func(im *image.RGBA, mask *image.Alpha) {
+		dc.stroke(dc.painter(im, mask, dc.strokePattern))
+	})
 }
 
 // Stroke strokes the current path with the current color, line width,
@@ -495,6 +688,62 @@ func (dc *Context) Stroke() {
 	dc.ClearPath()
 }
 
+// StrokeToPath replaces the current path with the outline of its stroke,
+// with the current line width, line cap, line join and dash settings taken
+// in user space. Filling the outline with FillRuleWinding paints the stroke
+// as the current matrix transforms it: unlike Stroke, whose line width is
+// in pixels, a non-uniform scale makes the stroke wider along the axis that
+// is scaled more, and a skew slants its caps and joins.
+func (dc *Context) StrokeToPath() {
+	// the outline is made in user space scaled so that its unit is about a
+	// pixel, which keeps the precision of fixed point
+	k := math.Sqrt(math.Abs(dc.matrix.Determinant()))
+	if k == 0 || math.IsNaN(k) || math.IsInf(k, 0) {
+		dc.ClearPath()
+		return
+	}
+	toUser := dc.matrix.Inverse().Multiply(Scale(k, k))
+	paths := flattenPath(dc.strokePath)
+	for _, path := range paths {
+		for i, p := range path {
+			x, y := toUser.TransformPoint(p.X, p.Y)
+			path[i] = Point{x, y}
+		}
+	}
+	dashes := make([]float64, len(dc.dashes))
+	for i, d := range dc.dashes {
+		dashes[i] = d * k
+	}
+	var outline raster.Path
+	raster.Stroke(&outline, rasterPath(dashPath(paths, dashes)), fix(dc.lineWidth*k), dc.capper(), dc.joiner())
+
+	dc.ClearPath()
+	pt := func(i int) (float64, float64) {
+		return unfix(outline[i]) / k, unfix(outline[i+1]) / k
+	}
+	for i := 0; i < len(outline); {
+		switch outline[i] {
+		case 0:
+			dc.MoveTo(pt(i + 1))
+			i += 4
+		case 1:
+			dc.LineTo(pt(i + 1))
+			i += 4
+		case 2:
+			x1, y1 := pt(i + 1)
+			x2, y2 := pt(i + 3)
+			dc.QuadraticTo(x1, y1, x2, y2)
+			i += 6
+		default:
+			x1, y1 := pt(i + 1)
+			x2, y2 := pt(i + 3)
+			x3, y3 := pt(i + 5)
+			dc.CubicTo(x1, y1, x2, y2, x3, y3)
+			i += 8
+		}
+	}
+}
+
 func (dc *Context) FillStroke() {
 	dc.FillPreserve()
 	dc.Stroke()
@@ -503,20 +752,9 @@ func (dc *Context) FillStroke() {
 // FillPreserve fills the current path with the current color. Open subpaths
 // are implicity closed. The path is preserved after this operation.
 func (dc *Context) FillPreserve() {
-	var painter raster.Painter
-	if dc.mask == nil {
-		if pattern, ok := dc.fillPattern.(*solidPattern); ok {
-			// with a nil mask and a solid color pattern, we can be more efficient
-			// TODO: refactor so we don't have to do this type assertion stuff?
-			p := raster.NewRGBAPainter(dc.im)
-			p.SetColor(pattern.color)
-			painter = p
-		}
-	}
-	if painter == nil {
-		painter = newPatternPainter(dc.im, dc.mask, dc.fillPattern, dc.matrix)
-	}
-	dc.fill(painter)
+	dc.composite(pathBounds(dc.fillPath, 1), func(im *image.RGBA, mask *image.Alpha) {
+		dc.fill(dc.painter(im, mask, dc.fillPattern))
+	})
 }
 
 // Fill fills the current path with the current color. Open subpaths
@@ -770,20 +1008,29 @@ func (dc *Context) DrawImageAnchored(im image.Image, x, y int, ax, ay float64) {
 	fx, fy := float64(x), float64(y)
 	m := dc.matrix.Translate(fx, fy)
 	s2d := f64.Aff3{m.XX, m.XY, m.X0, m.YX, m.YY, m.Y0}
-	if dc.mask == nil {
-		dc.transformer.Transform(dc.im, s2d, im, im.Bounds(), draw.Over, nil)
-	} else {
-		dc.transformer.Transform(dc.im, s2d, im, im.Bounds(), draw.Over, &draw.Options{
-			DstMask:  dc.mask,
-			DstMaskP: image.ZP,
-		})
-	}
+	b := im.Bounds()
+	bounds := transformedBounds(m, 0, 0, float64(b.Dx()), float64(b.Dy()))
+	dc.composite(bounds, func(dst *image.RGBA, mask *image.Alpha) {
+		if mask == nil {
+			dc.transformer.Transform(dst, s2d, im, im.Bounds(), draw.Over, nil)
+		} else {
+			dc.transformer.Transform(dst, s2d, im, im.Bounds(), draw.Over, &draw.Options{
+				DstMask:  mask,
+				DstMaskP: image.ZP,
+			})
+		}
+	})
 }
 
 // Font
 
+// SetFont sets the font used by SetFontSize and CreateStringPath. Text
+// drawn with a font set this way is not shaped; use LoadFont or LoadFontData
+// to enable OpenType shaping.
 func (dc *Context) SetFont(font *truetype.Font) {
 	dc.font = font
+	dc.sfntFont = nil
+	dc.otFont = nil
 }
 
 func (dc *Context) LoadFont(path string) error {
@@ -791,37 +1038,93 @@ func (dc *Context) LoadFont(path string) error {
 	if err != nil {
 		return err
 	}
-	f, err := truetype.Parse(fontBytes)
-	if err != nil {
-		return err
-	}
-	dc.font = f
-	return nil
+	return dc.LoadFontData(fontBytes)
 }
 
+// LoadFontData loads a TrueType or OpenType font, with TrueType or CFF
+// outlines. For font collections, the first font is loaded.
 func (dc *Context) LoadFontData(ttf []byte) error {
-	f, err := truetype.Parse(ttf)
+	return dc.LoadFontDataIndex(ttf, 0)
+}
+
+// LoadFontIndex loads the font with the given index from a font collection
+// (.ttc or .otc file). Use FontInfos to list the fonts of a collection.
+func (dc *Context) LoadFontIndex(path string, index int) error {
+	fontBytes, err := ioutil.ReadFile(path)
 	if err != nil {
 		return err
 	}
+	return dc.LoadFontDataIndex(fontBytes, index)
+}
+
+// LoadFontDataIndex is like LoadFontIndex, but loads the font from data.
+func (dc *Context) LoadFontDataIndex(ttf []byte, index int) error {
+	var f *truetype.Font
+	var sf *sfnt.Font
+	var err error
+	if index == 0 {
+		// truetype reads the first font of collections
+		f, err = truetype.Parse(ttf)
+	}
+	if f == nil {
+		// fonts with CFF outlines, and all but the first font of
+		// collections, are only read by sfnt
+		if sf, err = parseSFNT(ttf, index); err != nil {
+			return err
+		}
+	}
 	dc.font = f
+	dc.sfntFont = sf
+	// without OpenType data text is laid out rune by rune
+	dc.otFont, _ = parseOTFont(ttf, index)
 	return nil
 }
 
 func (dc *Context) SetFontSize(points float64) {
-	if dc.font == nil {
+	if dc.font == nil && dc.sfntFont == nil {
 		log.Println("must load font")
 		return
 	}
-	dc.fontFace = truetype.NewFace(dc.font, &truetype.Options{
-		Size: points,
-		// Hinting: font.HintingFull,
-	})
+	dc.fontFace = dc.newFace(points)
 	dc.fontHeight = float64(dc.fontFace.Metrics().Height) / 64
 	dc.fontSize = points
 	dc.fontScale = dc.fontSize * dc.dpi * 64 / 72
 }
 
+// HasScalableFont reports whether a font was set with SetFont, LoadFont or
+// LoadFontData, so that SetFontSize can make a face of it at any size.
+func (dc *Context) HasScalableFont() bool {
+	return dc.font != nil || dc.sfntFont != nil
+}
+
+// newFace returns a face for the font set with SetFont, LoadFont or
+// LoadFontData at the given size in points.
+func (dc *Context) newFace(points float64) font.Face {
+	if dc.font == nil {
+		face := newSFNTFace(dc.sfntFont, points)
+		if dc.otFont != nil {
+			return dc.newShapedFace(face, points)
+		}
+		return face
+	}
+	face := truetype.NewFace(dc.font, &truetype.Options{
+		Size: points,
+		// Hinting: font.HintingFull,
+	})
+	if dc.otFont != nil {
+		return dc.newShapedFace(face, points)
+	}
+	return &truetypeFace{face, dc.font, fixed.Int26_6(points * dc.dpi * 64 / 72)}
+}
+
+// newShapedFace returns a shaped face for the loaded OpenType font with the
+// font variations of dc.
+func (dc *Context) newShapedFace(face font.Face, points float64) *shapedFace {
+	f := newShapedFace(face, dc.otFont, points)
+	f.ot.SetVariations(dc.fontVariations)
+	return f
+}
+
 // Text Functions
 
 func (dc *Context) SetFontFace(fontFace font.Face) {
@@ -834,100 +1137,252 @@ func (dc *Context) SetFontFace(fontFace font.Face) {
 func (dc *Context) LoadFontFace(path string, points float64) error {
 	face, err := LoadFontFace(path, points)
 	if err == nil {
+		if f, ok := face.(*shapedFace); ok {
+			face = f.vary(dc.fontVariations)
+		}
 		dc.fontFace = face
-		dc.fontHeight = points * 72 / 96
+		dc.fontHeight = float64(face.Metrics().Height) / 64
 		dc.fontSize = points
 		dc.fontScale = dc.fontSize * dc.dpi * 64 / 72
 	}
 	return err
 }
 
+// FontHeight returns the line height of the current font face, the distance
+// between the baselines of consecutive lines of wrapped text with a line
+// spacing of 1.
 func (dc *Context) FontHeight() float64 {
 	return dc.fontHeight
 }
 
-func (dc *Context) drawString(im *image.RGBA, s string, x, y float64) {
-	d := &font.Drawer{
-		Dst:  im,
-		Src:  image.NewUniform(dc.color),
-		Face: dc.fontFace,
-		Dot:  fixp(x, y),
-	}
-	// based on Drawer.DrawString() in golang.org/x/image/font/font.go
-	prevC := rune(-1)
-	for _, c := range s {
-		if prevC >= 0 {
-			d.Dot.X += d.Face.Kern(prevC, c)
+// fontAscent returns the distance from the baseline up to the top of the
+// current font face.
+func (dc *Context) fontAscent() float64 {
+	return float64(dc.fontFace.Metrics().Ascent) / 64
+}
+
+// drawGlyphs draws glyphs positioned relative to x, y in user space onto im,
+// painting them with src. Colour glyphs keep their own colors.
+func (dc *Context) drawGlyphs(im draw.Image, glyphs []glyph, x, y float64, src image.Image) {
+	origin := fixp(x, y)
+	for i := range glyphs {
+		if dc.usedGlyphs != nil {
+			dc.recordGlyph(glyphs[i])
 		}
-		dr, mask, maskp, advance, ok := d.Face.Glyph(d.Dot, c)
-		if !ok {
-			// TODO: is falling back on the U+FFFD glyph the responsibility of
-			// the Drawer or the Face?
-			// TODO: set prevC = '\ufffd'?
-			continue
+		dot := origin.Add(glyphs[i].dot)
+		if !dc.drawColorGlyph(im, glyphs[i], dot, src) {
+			dc.drawGlyphMask(im, glyphs[i], dot, src)
 		}
-		sr := dr.Sub(dr.Min)
-		transformer := draw.BiLinear
-		fx, fy := float64(dr.Min.X), float64(dr.Min.Y)
-		m := dc.matrix.Translate(fx, fy)
-		s2d := f64.Aff3{m.XX, m.XY, m.X0, m.YX, m.YY, m.Y0}
-		transformer.Transform(d.Dst, s2d, d.Src, sr, draw.Over, &draw.Options{
-			SrcMask:  mask,
-			SrcMaskP: maskp,
-		})
-		d.Dot.X += advance
-		prevC = c
 	}
 }
 
-// DrawString draws the specified text at the specified point.
+// drawGlyphMask draws the coverage mask of g with its origin at dot onto im,
+// painting it with src. When the matrix only translates, the mask is
+// rendered at the subpixel offset of the glyph on the image and copied
+// without being transformed.
+func (dc *Context) drawGlyphMask(im draw.Image, g glyph, dot fixed.Point26_6, src image.Image) {
+	m := dc.matrix
+	if m.XX == 1 && m.XY == 0 && m.YX == 0 && m.YY == 1 {
+		dr, mask, ok := dc.glyphMask(g, dot.Add(fixp(m.X0, m.Y0)))
+		if ok {
+			draw.DrawMask(im, dr, src, image.ZP, mask, image.ZP, draw.Over)
+		}
+		return
+	}
+	dr, mask, ok := dc.glyphMask(g, dot)
+	if !ok {
+		return
+	}
+	var transformer draw.Transformer = draw.BiLinear
+	if _, bitmap := g.face.(*bitmapFace); bitmap || dc.antialias == AntialiasNone {
+		// bitmap glyphs keep their pixels sharp
+		transformer = draw.NearestNeighbor
+	}
+	m = m.Translate(float64(dr.Min.X), float64(dr.Min.Y))
+	s2d := f64.Aff3{m.XX, m.XY, m.X0, m.YX, m.YY, m.Y0}
+	transformer.Transform(im, s2d, src, mask.Bounds(), draw.Over, &draw.Options{
+		SrcMask: mask,
+	})
+}
+
+// DrawString draws the specified text at the specified point. The text is
+// painted with the fill pattern, which is the current color unless set with
+// SetFillStyle, through the clipping mask.
 func (dc *Context) DrawString(s string, x, y float64) {
 	dc.DrawStringAnchored(s, x, y, 0, 0)
 }
 
 // DrawStringAnchored draws the specified text at the specified anchor point.
-// The anchor point is x - w * ax, y - h * ay, where w, h is the size of the
-// text. Use ax=0.5, ay=0.5 to center the text at the specified point.
+// The anchor point is x - w * ax, y - h * ay, where w is the width of the text
+// and h the ascent of the font, measured from the baseline. So ay=0 puts the
+// baseline at y and ay=1 the top of the font. Use ax=0.5, ay=0.5 to center
+// the text at the specified point.
 func (dc *Context) DrawStringAnchored(s string, x, y, ax, ay float64) {
-	w, h := dc.MeasureString(s)
-	x -= ax * w
-	y += ay * h
-	if dc.mask == nil {
-		dc.drawString(dc.im, s, x, y)
-	} else {
+	if dc.vertical() {
+		dc.drawStringVertical(s, x, y, ax, ay)
+		return
+	}
+	glyphs, a := dc.shapeString(s)
+	x -= ax * float64(a>>6)
+	y += ay * dc.fontAscent()
+	dc.drawText(glyphs, x, y)
+}
+
+// DrawStringStroke strokes the outlines of the glyphs of the specified text
+// at the specified point, like DrawString, with the current stroke pattern,
+// line width, line cap, line join and dash settings. The current path is
+// left unchanged. Text is always stroked horizontally.
+func (dc *Context) DrawStringStroke(s string, x, y float64) {
+	dc.DrawStringStrokeAnchored(s, x, y, 0, 0)
+}
+
+// DrawStringStrokeAnchored strokes the outlines of the glyphs of the
+// specified text like DrawStringStroke, at the anchor point described for
+// DrawStringAnchored.
+func (dc *Context) DrawStringStrokeAnchored(s string, x, y, ax, ay float64) {
+	_, a := dc.layoutString(dc.pathFaces(), s)
+	x -= ax * float64(a>>6)
+	y += ay * dc.fontAscent()
+
+	strokePath, fillPath, segments := dc.strokePath, dc.fillPath, dc.segments
+	start, current, hasCurrent := dc.start, dc.current, dc.hasCurrent
+	dc.strokePath, dc.fillPath, dc.segments = nil, nil, nil
+	dc.hasCurrent = false
+	dc.CreateStringPath(s, x, y)
+	dc.Stroke()
+	dc.strokePath, dc.fillPath, dc.segments = strokePath, fillPath, segments
+	dc.start, dc.current, dc.hasCurrent = start, current, hasCurrent
+}
+
+// drawText draws a line of glyphs with its baseline starting at x, y, and
+// its decorations. Strikethroughs are drawn over the glyphs and the other
+// decorations under them.
+func (dc *Context) drawText(glyphs []glyph, x, y float64) {
+	dc.composite(glyphsBounds(glyphs, x, y, dc.matrix), func(dst *image.RGBA, mask *image.Alpha) {
+		dc.drawDecorations(dst, mask, glyphs, x, y, TextDecorationUnderline|TextDecorationOverline)
+		dc.paintGlyphs(dst, mask, func(im draw.Image, src image.Image) {
+			dc.drawGlyphs(im, glyphs, x, y, src)
+		})
+		dc.drawDecorations(dst, mask, glyphs, x, y, TextDecorationStrikethrough)
+	})
+}
+
+// paintGlyphs paints glyphs with the fill pattern onto dst through the
+// optional mask. render draws the glyphs onto im, taking their color from
+// src: a solid color is drawn directly, and other patterns paint the
+// coverage of the glyphs, so colour glyphs are then filled like the others.
+func (dc *Context) paintGlyphs(dst *image.RGBA, mask *image.Alpha, render func(im draw.Image, src image.Image)) {
+	if pattern, ok := dc.fillPattern.(*solidPattern); ok {
+		src := image.NewUniform(pattern.color)
+		if mask == nil {
+			render(dst, src)
+			return
+		}
 		im := image.NewRGBA(image.Rect(0, 0, dc.width, dc.height))
-		dc.drawString(im, s, x, y)
-		draw.DrawMask(dc.im, dc.im.Bounds(), im, image.ZP, dc.mask, image.ZP, draw.Over)
+		render(im, src)
+		draw.DrawMask(dst, dst.Bounds(), im, image.ZP, mask, image.ZP, draw.Over)
+		return
 	}
+	alpha := image.NewAlpha(dst.Bounds())
+	render(alpha, image.Opaque)
+	paintAlpha(dc.aliased(dc.painter(dst, mask, dc.fillPattern)), alpha)
 }
 
 // DrawStringWrapped word-wraps the specified string to the given max width
 // and then draws it at the specified anchor point using the given line
-// spacing and text alignment.
+// spacing and text alignment. AlignLeft and AlignRight refer to the start
+// and end of each line, so they are swapped for right-to-left paragraphs.
+// AlignJustify stretches every line but the last of each paragraph to the
+// full width.
 func (dc *Context) DrawStringWrapped(s string, x, y, ax, ay, width, lineSpacing float64, align Align) {
-	lines := dc.WordWrap(s, width)
+	dc.drawLines(dc.wrapLines(s, width, align), x, y, ax, ay, width, lineSpacing, align)
+}
+
+// textLine is a line of word-wrapped text as drawn by DrawStringWrapped.
+type textLine struct {
+	text    string
+	hyphen  bool // the text ends with a hyphen added by word-wrapping
+	rtl     bool // the line belongs to a right-to-left paragraph
+	justify bool // the line is stretched to the full width
+	// start and end are the byte offsets of the line in the wrapped text,
+	// without the hyphen added by word-wrapping
+	start, end int
+}
+
+// wrapLines word-wraps s to width for drawing with the given alignment.
+func (dc *Context) wrapLines(s string, width float64, align Align) []textLine {
+	var lines []textLine
+	offset := 0
+	for _, paragraph := range strings.Split(s, "\n") {
+		rtl := paragraphRTL([]rune(paragraph), dc.textDirection)
+		for _, line := range dc.wrapString(paragraph, width) {
+			lines = append(lines, textLine{
+				text:    line.text(paragraph),
+				start:   offset + line.start,
+				end:     offset + line.end,
+				hyphen:  line.hyphen,
+				rtl:     rtl,
+				justify: align == AlignJustify && !line.last,
+			})
+		}
+		offset += len(paragraph) + 1
+	}
+	return lines
+}
 
+// drawLines draws word-wrapped lines like DrawStringWrapped.
+func (dc *Context) drawLines(lines []textLine, x, y, ax, ay, width, lineSpacing float64, align Align) {
+	if dc.vertical() {
+		dc.drawLinesVertical(lines, x, y, ax, ay, width, lineSpacing, align)
+		return
+	}
 	// sync h formula with MeasureMultilineString
 	h := float64(len(lines)) * dc.fontHeight * lineSpacing
 	h -= (lineSpacing - 1) * dc.fontHeight
 
 	x -= ax * width
 	y -= ay * h
+	direction := dc.textDirection
+	defer dc.SetTextDirection(direction)
+	for _, line := range lines {
+		dc.SetTextDirection(TextDirectionLTR)
+		if line.rtl {
+			dc.SetTextDirection(TextDirectionRTL)
+		}
+		if line.justify {
+			glyphs, a := dc.shapeString(line.text)
+			justify(glyphs, fix(width)-a)
+			dc.drawText(glyphs, x, y+dc.fontAscent())
+		} else {
+			lx, ax := lineAnchor(align, line.rtl, x, width)
+			dc.DrawStringAnchored(line.text, lx, y, ax, 1)
+		}
+		y += dc.fontHeight * lineSpacing
+	}
+}
+
+// lineAnchor returns the x coordinate and horizontal anchor at which a line
+// is drawn to align it within a box of the given width starting at x. The
+// alignment is mirrored for right-to-left paragraphs.
+func lineAnchor(align Align, rtl bool, x, width float64) (lx, ax float64) {
+	if align == AlignJustify {
+		align = AlignLeft
+	}
+	if rtl {
+		switch align {
+		case AlignLeft:
+			align = AlignRight
+		case AlignRight:
+			align = AlignLeft
+		}
+	}
 	switch align {
-	case AlignLeft:
-		ax = 0
 	case AlignCenter:
-		ax = 0.5
-		x += width / 2
+		return x + width/2, 0.5
 	case AlignRight:
-		ax = 1
-		x += width
-	}
-	ay = 1
-	for _, line := range lines {
-		dc.DrawStringAnchored(line, x, y, ax, ay)
-		y += dc.fontHeight * lineSpacing
+		return x + width, 1
 	}
+	return x, 0
 }
 
 func (dc *Context) MeasureMultilineString(s string, lineSpacing float64) (width, height float64) {
@@ -937,36 +1392,51 @@ func (dc *Context) MeasureMultilineString(s string, lineSpacing float64) (width,
 	height = float64(len(lines)) * dc.fontHeight * lineSpacing
 	height -= (lineSpacing - 1) * dc.fontHeight
 
-	d := &font.Drawer{
-		Face: dc.fontFace,
-	}
-
 	// max width from lines
 	for _, line := range lines {
-		adv := d.MeasureString(line)
-		currentWidth := float64(adv >> 6) // from gg.Context.MeasureString
+		currentWidth := dc.lineLength(line)
 		if currentWidth > width {
 			width = currentWidth
 		}
 	}
 
+	if dc.vertical() {
+		return height, width
+	}
 	return width, height
 }
 
 // MeasureString returns the rendered width and height of the specified text
-// given the current font face.
+// given the current font face. The height is the line height of the font, as
+// returned by FontHeight. Use MeasureText for the ascent, descent and ink
+// bounds of the text. In the vertical writing modes, the width is the line
+// height of the font and the height is the length of the column.
 func (dc *Context) MeasureString(s string) (w, h float64) {
-	d := &font.Drawer{
-		Face: dc.fontFace,
+	if dc.vertical() {
+		return dc.fontHeight, dc.lineLength(s)
 	}
-	a := d.MeasureString(s)
-	return float64(a >> 6), dc.fontHeight
+	return dc.lineLength(s), dc.fontHeight
 }
 
 // WordWrap wraps the specified string to the given max width and current
 // font face.
 func (dc *Context) WordWrap(s string, w float64) []string {
-	return wordWrap(dc, s, w)
+	var result []string
+	for _, line := range dc.wrapString(s, w) {
+		result = append(result, line.text(s))
+	}
+	return result
+}
+
+// wrapString word-wraps s to width with the current font face.
+func (dc *Context) wrapString(s string, width float64) []wrapLine {
+	return dc.wordWrap(s, width, func(i, j int, hyphen bool) float64 {
+		t := s[i:j]
+		if hyphen {
+			t += "-"
+		}
+		return dc.lineLength(t)
+	})
 }
 
 // Transformation Matrix Operations
@@ -1061,8 +1531,10 @@ func (dc *Context) Pop() {
 	x, s := s[len(s)-1], s[:len(s)-1]
 	*dc = *x
 	dc.mask = before.mask
+	dc.usedGlyphs = before.usedGlyphs
 	dc.strokePath = before.strokePath
 	dc.fillPath = before.fillPath
+	dc.segments = before.segments
 	dc.start = before.start
 	dc.current = before.current
 	dc.hasCurrent = before.hasCurrent
@@ -1072,8 +1544,8 @@ func (dc *Context) Pop() {
 // The returned value is the same thing measured in floating point and positive Y
 // going downwards.
 
-func (dc *Context) drawGlyph(glyph truetype.Index, dx, dy float64) error {
-	if err := dc.glyphBuf.Load(dc.font, fixed.Int26_6(dc.fontScale), glyph, font.HintingNone); err != nil {
+func (dc *Context) drawGlyph(f *truetype.Font, scale fixed.Int26_6, glyph truetype.Index, dx, dy float64) error {
+	if err := dc.glyphBuf.Load(f, scale, glyph, font.HintingNone); err != nil {
 		return err
 	}
 	e0 := 0
@@ -1090,29 +1562,59 @@ func (dc *Context) drawGlyph(glyph truetype.Index, dx, dy float64) error {
 // above and to the right of the point, but some may be below or to the left.
 // For example, drawing a string that starts with a 'J' in an italic font may
 // affect pixels below and left of the point.
+// Glyphs are taken from the font fallbacks like in DrawString. Outlines are
+// loaded from TrueType and OpenType fonts, including CFF fonts. Glyphs of
+// faces without vector outlines, such as bitmap fonts, add nothing to the
+// path, but their advance is still counted.
 func (dc *Context) CreateStringPath(s string, x, y float64) float64 {
-	if dc.font == nil {
-		log.Println("must load font")
-		return 0.0
-	}
+	faces := dc.pathFaces()
 	//dc.NewSubPath()
 	//defer dc.ClosePath()
-	startx := x
-	prev, hasPrev := truetype.Index(0), false
-	for _, rune := range s {
-		index := dc.font.Index(rune)
-		if hasPrev {
-			x += fUnitsToFloat64(dc.font.Kern(fixed.Int26_6(dc.fontScale), prev, index))
-		}
-		err := dc.drawGlyph(index, x, y)
-		if err != nil {
+	glyphs, advance := dc.layoutString(faces, s)
+	for _, g := range glyphs {
+		gx, gy := x+unfix(g.dot.X), y+unfix(g.dot.Y)
+		if err := dc.glyphPath(g, gx, gy); err != nil {
 			log.Println(err)
-			return startx - x
+			return gx - x
+		}
+	}
+	return unfix(advance)
+}
+
+// pathFaces returns the faces used to lay out text for CreateStringPath.
+func (dc *Context) pathFaces() []font.Face {
+	faces := dc.faces()
+	switch faces[0].(type) {
+	case *shapedFace, *truetypeFace, *sfntFace, *bitmapFace:
+	default:
+		if dc.font != nil {
+			// take the outlines from the font set with SetFont
+			face := truetype.NewFace(dc.font, &truetype.Options{Size: dc.fontScale / 64})
+			faces[0] = &truetypeFace{face, dc.font, fixed.Int26_6(dc.fontScale)}
 		}
-		x += fUnitsToFloat64(dc.font.HMetric(fixed.Int26_6(dc.fontScale), index).AdvanceWidth)
-		prev, hasPrev = index, true
 	}
-	return x - startx
+	return faces
+}
+
+// glyphPath adds the outline of a glyph with its origin at x, y to the
+// current path. Glyphs of faces that have no vector outlines, or whose
+// outlines cannot be reached through the font.Face interface, are skipped.
+func (dc *Context) glyphPath(g glyph, x, y float64) error {
+	switch f := g.face.(type) {
+	case *shapedFace:
+		if layers, ok := f.font.colorGlyphs[g.id]; ok {
+			for _, layer := range layers {
+				dc.glyphPath(glyph{face: f, id: layer.id}, x, y)
+			}
+		} else if outline, ok := f.outline(g.id); ok {
+			dc.drawOutline(outline, f.size/float64(f.ot.Upem()), x, y)
+		}
+	case *truetypeFace:
+		return dc.drawGlyph(f.font, f.scale, f.font.Index(g.r), x, y)
+	case *sfntFace:
+		return dc.sfntGlyphPath(f, g.r, x, y)
+	}
+	return nil
 }
 
 func pointToF64Point(p truetype.Point) (x, y float64) {
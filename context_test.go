@@ -1,12 +1,34 @@
 package gg
 
 import (
+	"bytes"
+	"compress/gzip"
 	"crypto/md5"
+	"encoding/binary"
 	"flag"
 	"fmt"
+	"image"
 	"image/color"
+	"image/draw"
+	"image/png"
+	"io/ioutil"
+	"math"
 	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
+
+	"github.com/goki/freetype/truetype"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/gobolditalic"
+	"golang.org/x/image/font/gofont/goitalic"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/math/fixed"
 )
 
 var save bool
@@ -201,7 +223,7 @@ func TestDrawStringWrapped(t *testing.T) {
 	dc.SetRGB(0, 0, 0)
 	dc.DrawStringWrapped("Hello, world! How are you?", 50, 50, 0.5, 0.5, 90, 1.5, AlignCenter)
 	saveImage(dc, "TestDrawStringWrapped")
-	checkHash(t, dc, "8d92f6aae9e8b38563f171abd00893f8")
+	checkHash(t, dc, "e641db4e1d26ab308d9ab698a6ce41f9")
 }
 
 func TestDrawImage(t *testing.T) {
@@ -321,3 +343,1714 @@ func BenchmarkCircles(b *testing.B) {
 		dc.Fill()
 	}
 }
+
+type recordable interface {
+	SetRGB(r, g, b float64)
+	SetLineWidth(lineWidth float64)
+	SetFillStyle(pattern Pattern)
+	Clear()
+	DrawCircle(x, y, r float64)
+	DrawRectangle(x, y, w, h float64)
+	Fill()
+	Stroke()
+	Push()
+	Pop()
+	RotateAbout(angle, x, y float64)
+}
+
+func TestRecorderReplay(t *testing.T) {
+	draw := func(dc recordable) {
+		dc.SetRGB(1, 1, 1)
+		dc.Clear()
+		g := NewLinearGradient(0, 0, 100, 100)
+		g.AddColorStop(0, color.RGBA{255, 0, 0, 255})
+		g.AddColorStop(1, color.RGBA{0, 0, 255, 255})
+		dc.SetFillStyle(g)
+		dc.DrawCircle(50, 50, 30)
+		dc.Fill()
+		dc.Push()
+		dc.RotateAbout(Radians(30), 50, 50)
+		dc.DrawRectangle(20, 40, 60, 20)
+		dc.SetRGB(0, 0, 0)
+		dc.SetLineWidth(3)
+		dc.Stroke()
+		dc.Pop()
+	}
+
+	expected := NewContext(100, 100)
+	draw(expected)
+
+	rec := NewRecorder()
+	draw(rec)
+	dc := NewContext(100, 100)
+	if err := rec.Replay(dc, Identity()); err != nil {
+		t.Fatal(err)
+	}
+	saveImage(dc, "TestRecorderReplay")
+	checkHash(t, dc, hash(expected))
+
+	var buf bytes.Buffer
+	if err := rec.Encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeRecorder(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dc = NewContext(100, 100)
+	if err := decoded.Replay(dc, Identity()); err != nil {
+		t.Fatal(err)
+	}
+	checkHash(t, dc, hash(expected))
+
+	// replaying at twice the size matches drawing with a scaled matrix
+	expected = NewContext(200, 200)
+	expected.Scale(2, 2)
+	draw(expected)
+	dc = NewContext(200, 200)
+	if err := rec.Replay(dc, Scale(2, 2)); err != nil {
+		t.Fatal(err)
+	}
+	checkHash(t, dc, hash(expected))
+
+	// commands with missing arguments are errors
+	for _, s := range []string{
+		`[{"op":"MoveTo"}]`,
+		`[{"op":"SetShadow","args":[1,2,3]}]`,
+		`[{"op":"SetTextDecorationColor","args":[255]}]`,
+		`[{"op":"SetFillStyle","text":"radial","args":[1,2,3]}]`,
+	} {
+		decoded, err := DecodeRecorder(strings.NewReader(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := decoded.Replay(NewContext(10, 10), Identity()); err == nil {
+			t.Errorf("%s: expected an error", s)
+		}
+	}
+
+	// SVG path data with an error is rejected when it is recorded
+	rec = NewRecorder()
+	if err := rec.DrawSVGPath("M10 10 L20 20 X"); err == nil {
+		t.Error("expected an error")
+	}
+	if err := rec.DrawSVGPath("M10 10 L20 20"); err != nil {
+		t.Error(err)
+	}
+	if n := len(rec.Commands()); n != 1 {
+		t.Errorf("recorded %d commands, expected 1", n)
+	}
+}
+
+func TestRecorderDeviceSpace(t *testing.T) {
+	// the pixels, masks and gradients of a recording follow the matrix it
+	// is replayed with
+	rec := NewRecorder()
+	mask := image.NewAlpha(image.Rect(0, 0, 100, 100))
+	draw.Draw(mask, image.Rect(0, 0, 50, 100), image.Opaque, image.ZP, draw.Src)
+	rec.SetMask(mask)
+	g := NewLinearGradient(0, 0, 100, 0)
+	g.AddColorStop(0, color.RGBA{255, 0, 0, 255})
+	g.AddColorStop(1, color.RGBA{0, 0, 255, 255})
+	rec.SetFillStyle(g)
+	rec.DrawRectangle(0, 0, 100, 100)
+	rec.Fill()
+	rec.ResetClip()
+	rec.SetRGB(0, 1, 0)
+	rec.SetPixel(80, 20)
+
+	dc := NewContext(200, 200)
+	if err := rec.Replay(dc, Scale(2, 2)); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		x, y int
+		c    color.RGBA
+	}{
+		{2, 100, color.RGBA{253, 0, 2, 255}},
+		{98, 100, color.RGBA{130, 0, 125, 255}},
+		{102, 100, color.RGBA{}},
+		{160, 40, color.RGBA{0, 255, 0, 255}},
+		{161, 41, color.RGBA{0, 255, 0, 255}},
+		{162, 40, color.RGBA{}},
+	} {
+		if c := dc.Image().At(test.x, test.y); c != test.c {
+			t.Errorf("pixel %d, %d is %v, expected %v", test.x, test.y, c, test.c)
+		}
+	}
+}
+
+func TestRecorderFilters(t *testing.T) {
+	expected := NewContext(100, 100)
+	rec := NewRecorder()
+	for _, dc := range []interface {
+		recordable
+		SetFilter(filters ...Filter)
+		ApplyFilter(filters ...Filter)
+		ApplyFilterRect(r image.Rectangle, filters ...Filter)
+	}{expected, rec} {
+		dc.SetRGB(1, 1, 0)
+		dc.Clear()
+		dc.SetFilter(NewBoxBlurFilter(2))
+		dc.SetRGB(1, 0, 0)
+		dc.DrawCircle(50, 50, 30)
+		dc.Fill()
+		dc.SetFilter()
+		dc.ApplyFilterRect(image.Rect(0, 0, 50, 100), NewGrayscaleFilter(1))
+		dc.ApplyFilter(NewOpacityFilter(0.5))
+	}
+	dc := NewContext(100, 100)
+	if err := rec.Replay(dc, Identity()); err != nil {
+		t.Fatal(err)
+	}
+	checkHash(t, dc, hash(expected))
+	if err := rec.Encode(ioutil.Discard); err == nil {
+		t.Error("recording with filters was encoded")
+	}
+}
+
+type textRecordable interface {
+	LoadFontData(ttf []byte)
+	SetFontSize(points float64)
+	SetRGB(r, g, b float64)
+	SetLineWidth(lineWidth float64)
+	SetLineJoinRound()
+	CreateStringPath(s string, x, y float64)
+	Stroke()
+	DrawStringStrokeAnchored(s string, x, y, ax, ay float64)
+}
+
+// contextText adapts the methods of Context that return errors or values to
+// textRecordable.
+type contextText struct{ *Context }
+
+func (dc contextText) LoadFontData(ttf []byte)                 { dc.Context.LoadFontData(ttf) }
+func (dc contextText) CreateStringPath(s string, x, y float64) { dc.Context.CreateStringPath(s, x, y) }
+
+func TestRecorderText(t *testing.T) {
+	draw := func(dc textRecordable) {
+		dc.LoadFontData(goregular.TTF)
+		dc.SetFontSize(40)
+		dc.SetRGB(0, 0, 1)
+		dc.SetLineWidth(3)
+		dc.SetLineJoinRound()
+		dc.CreateStringPath("Wave", 5, 45)
+		dc.Stroke()
+		dc.DrawStringStrokeAnchored("Wave", 115, 100, 1, 0)
+	}
+	expected := NewContext(120, 110)
+	draw(contextText{expected})
+
+	rec := NewRecorder()
+	draw(rec)
+	var buf bytes.Buffer
+	if err := rec.Encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeRecorder(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dc := NewContext(120, 110)
+	if err := decoded.Replay(dc, Identity()); err != nil {
+		t.Fatal(err)
+	}
+	saveImage(dc, "TestRecorderText")
+	checkHash(t, dc, hash(expected))
+}
+
+func TestShadow(t *testing.T) {
+	dc := NewContext(100, 100)
+	dc.SetRGB(1, 1, 1)
+	dc.Clear()
+	dc.SetShadow(5, 5, 6, color.RGBA{0, 0, 0, 128})
+	dc.SetRGB(1, 0, 0)
+	dc.DrawRectangle(20, 20, 50, 40)
+	dc.Fill()
+	dc.Push()
+	dc.SetShadow(0, 0, 0, color.Transparent)
+	dc.DrawCircle(70, 70, 15)
+	dc.SetRGB(0, 0, 1)
+	dc.Fill()
+	dc.Pop()
+	dc.SetLineWidth(4)
+	dc.DrawLine(10, 90, 90, 85)
+	dc.Stroke()
+	saveImage(dc, "TestShadow")
+	checkHash(t, dc, "d149511e9fd12b233c0e7784de0f2187")
+}
+
+func TestShadowNegativeOffset(t *testing.T) {
+	dc := NewContext(40, 40)
+	dc.SetShadow(-3.6, -2.4, 0, color.Black)
+	dc.SetRGB(1, 0, 0)
+	dc.DrawRectangle(20, 20, 10, 10)
+	dc.Fill()
+	// the shadow is offset by -4, -2 pixels
+	for _, p := range []struct {
+		x, y int
+		a    uint8
+	}{{16, 18, 255}, {15, 18, 0}, {16, 17, 0}, {25, 25, 255}} {
+		if a := dc.im.RGBAAt(p.x, p.y).A; a != p.a {
+			t.Errorf("alpha at %d,%d is %d, expected %d", p.x, p.y, a, p.a)
+		}
+	}
+}
+
+// wholeImageFilter is a filter that leaves the image unchanged but is not
+// known to, so that layers cover the whole image.
+type wholeImageFilter struct{}
+
+func (wholeImageFilter) Apply(im *image.RGBA, r image.Rectangle) {}
+
+func TestShadowLayerBounds(t *testing.T) {
+	// the layers of shadowed and filtered operations only cover what they
+	// draw, with the same result as layers that cover the whole image
+	paint := func(filters ...Filter) *Context {
+		dc := NewContext(120, 100)
+		if err := dc.LoadFontData(goregular.TTF); err != nil {
+			t.Fatal(err)
+		}
+		dc.SetFontSize(20)
+		dc.SetShadow(4, -3, 5, color.RGBA{0, 0, 0, 128})
+		dc.SetFilter(append([]Filter{NewGaussianBlurFilter(1.5)}, filters...)...)
+		g := NewLinearGradient(0, 0, 120, 0)
+		g.AddColorStop(0, color.RGBA{255, 0, 0, 255})
+		g.AddColorStop(1, color.RGBA{0, 0, 255, 255})
+		dc.SetFillStyle(g)
+		dc.SetStrokeStyle(g)
+		dc.DrawCircle(20, 20, 10)
+		dc.Fill()
+		dc.RotateAbout(Radians(20), 60, 50)
+		dc.SetLineWidth(5)
+		dc.DrawLine(50, 30, 110, 40)
+		dc.Stroke()
+		dc.DrawString("Shadow", 30, 80)
+		im := image.NewRGBA(image.Rect(0, 0, 10, 10))
+		draw.Draw(im, im.Bounds(), image.Black, image.ZP, draw.Src)
+		dc.DrawImage(im, 100, 70)
+		return dc
+	}
+	bounded := paint()
+	whole := paint(wholeImageFilter{})
+	if !bytes.Equal(bounded.im.Pix, whole.im.Pix) {
+		t.Error("bounded layers changed the image")
+	}
+	if margin, ok := filtersMargin([]Filter{NewGaussianBlurFilter(1.5), NewBoxBlurFilter(2)}); !ok || margin != 5 {
+		t.Errorf("margin is %d, %v, expected 5, true", margin, ok)
+	}
+	if _, ok := filtersMargin([]Filter{wholeImageFilter{}}); ok {
+		t.Error("unknown filter has a margin")
+	}
+}
+
+func TestFilters(t *testing.T) {
+	dc := NewContext(100, 100)
+	dc.SetRGB(1, 1, 1)
+	dc.Clear()
+	dc.SetRGB(1, 0.5, 0)
+	dc.DrawCircle(30, 30, 20)
+	dc.Fill()
+	dc.SetFilter(NewGaussianBlurFilter(3), NewOpacityFilter(0.75))
+	dc.SetRGB(0, 0.5, 1)
+	dc.DrawRectangle(40, 40, 50, 50)
+	dc.Fill()
+	dc.SetFilter()
+	dc.ApplyFilterRect(image.Rect(0, 0, 50, 100), NewGrayscaleFilter(1))
+	dc.ApplyFilterRect(image.Rect(50, 0, 100, 50), NewHueRotateFilter(Radians(90)))
+	// the identity kernel leaves any part of the image unchanged
+	before := append([]uint8(nil), dc.im.Pix...)
+	dc.ApplyFilterRect(image.Rect(35, 35, 60, 60), NewConvolutionFilter(3, 3, []float64{
+		0, 0, 0,
+		0, 1, 0,
+		0, 0, 0,
+	}))
+	if !bytes.Equal(before, dc.im.Pix) {
+		t.Error("identity convolution of a rectangle changed the image")
+	}
+	dc.ApplyFilter(NewConvolutionFilter(3, 3, []float64{
+		0, -1, 0,
+		-1, 5, -1,
+		0, -1, 0,
+	}))
+	saveImage(dc, "TestFilters")
+	checkHash(t, dc, "0fb6879e62068a5bd7b7a664ead13bc4")
+}
+
+func TestAntialiasNone(t *testing.T) {
+	dc := NewContext(100, 100)
+	dc.SetRGB(1, 1, 1)
+	dc.Clear()
+	dc.SetAntialias(AntialiasNone)
+	dc.SetRGB(0, 0, 0)
+	dc.DrawCircle(50, 50, 30)
+	dc.Fill()
+	dc.SetLineWidth(3)
+	dc.DrawLine(10, 90, 90, 70)
+	dc.Stroke()
+	dc.Push()
+	dc.Rotate(Radians(10))
+	dc.DrawString("Hello", 10, 20)
+	dc.Pop()
+	for i, v := range dc.im.Pix {
+		if v != 0 && v != 255 {
+			t.Fatalf("pixel %d has partial value %d", i/4, v)
+		}
+	}
+	saveImage(dc, "TestAntialiasNone")
+	checkHash(t, dc, "f0a1ef05add010a89de94df8be2ee287")
+}
+
+func TestShapedText(t *testing.T) {
+	dc := NewContext(200, 100)
+	dc.SetRGB(1, 1, 1)
+	dc.Clear()
+	dc.SetRGB(0, 0, 0)
+	// the Go fonts have no OpenType layout tables, so a ligature of f and i
+	// is added
+	if err := dc.LoadFontData(ligatureFont(t, goregular.TTF, 'f', 'i', '\ufb01')); err != nil {
+		t.Fatal(err)
+	}
+	dc.SetFontSize(24)
+	if _, ok := dc.fontFace.(*shapedFace); !ok {
+		t.Fatal("expected a shaped font face")
+	}
+	dc.DrawString("AVAT office", 10, 40)
+	w, _ := dc.MeasureString("AVAT office")
+	pw := dc.CreateStringPath("AVAT office", 10, 80)
+	dc.Fill()
+	if math.Abs(w-pw) > 1 {
+		t.Fatalf("measured width %v != path width %v", w, pw)
+	}
+	// shaping applies the ligatures of the font
+	if glyphs, _ := dc.shapeString("fi"); len(glyphs) != 1 || glyphs[0].cluster != 0 {
+		t.Errorf("fi is shaped to %d glyphs, expected one ligature", len(glyphs))
+	}
+	saveImage(dc, "TestShapedText")
+	checkHash(t, dc, "f008e8e81a72a0e19d990b10c9823889")
+}
+
+func TestBidi(t *testing.T) {
+	f, err := truetype.Parse(goregular.TTF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	faces := []font.Face{truetype.NewFace(f, &truetype.Options{Size: 12})}
+	clusters := func(direction TextDirection) []int {
+		glyphs, _ := layoutLine(faces, []rune("ab אבג 12"), direction)
+		var result []int
+		for _, g := range glyphs {
+			result = append(result, g.cluster)
+		}
+		return result
+	}
+	expected := map[TextDirection][]int{
+		TextDirectionAuto: {0, 1, 2, 7, 8, 6, 5, 4, 3},
+		TextDirectionRTL:  {7, 8, 6, 5, 4, 3, 2, 0, 1},
+	}
+	for direction, want := range expected {
+		if got := clusters(direction); !reflect.DeepEqual(got, want) {
+			t.Errorf("direction %d: visual order %v, expected %v", direction, got, want)
+		}
+	}
+
+	// AlignLeft is the start of a right-to-left paragraph
+	draw := func(direction TextDirection, align Align) []uint8 {
+		dc := NewContext(200, 50)
+		dc.SetRGB(0, 0, 0)
+		dc.SetTextDirection(direction)
+		dc.DrawStringWrapped("hello world", 10, 10, 0, 0, 180, 1, align)
+		return dc.im.Pix
+	}
+	if !bytes.Equal(draw(TextDirectionRTL, AlignLeft), draw(TextDirectionLTR, AlignRight)) {
+		t.Error("AlignLeft in a right-to-left paragraph should align to the right")
+	}
+}
+
+func TestFontFallbacks(t *testing.T) {
+	fallback := NewContext(1, 1)
+	if err := fallback.LoadFontData(goregular.TTF); err != nil {
+		t.Fatal(err)
+	}
+	fallback.SetFontSize(13)
+	dc := NewContext(200, 50)
+	dc.SetFontFallbacks(fallback.fontFace)
+	glyphs, _ := dc.shapeString("a αβ 漢")
+	expected := []struct {
+		face font.Face
+		r    rune
+	}{
+		{dc.fontFace, 'a'},
+		{dc.fontFace, ' '},
+		{fallback.fontFace, 'α'},
+		{fallback.fontFace, 'β'},
+		{dc.fontFace, ' '},
+		{dc.fontFace, '\ufffd'},
+	}
+	if len(glyphs) != len(expected) {
+		t.Fatalf("got %d glyphs, expected %d", len(glyphs), len(expected))
+	}
+	for i, e := range expected {
+		if glyphs[i].face != e.face || glyphs[i].r != e.r {
+			t.Errorf("glyph %d: got %q, expected %q from the other face", i, glyphs[i].r, e.r)
+		}
+	}
+	w, _ := dc.MeasureString("a αβ 漢")
+	if pw := dc.CreateStringPath("αβ", 0, 0); pw <= 0 || pw >= w {
+		t.Errorf("unexpected path width %v for measured width %v", pw, w)
+	}
+}
+
+func TestRichText(t *testing.T) {
+	dc := NewContext(300, 150)
+	dc.SetRGB(1, 1, 1)
+	dc.Clear()
+	dc.SetRGB(0, 0, 0)
+	if err := dc.LoadFontData(goregular.TTF); err != nil {
+		t.Fatal(err)
+	}
+	dc.SetFontSize(18)
+	w, _ := dc.MeasureString("plain text")
+	if sw, _ := dc.MeasureSpans([]Span{{Text: "plain "}, {Text: "text"}}); sw != w {
+		t.Errorf("spans measured %v, string measured %v", sw, w)
+	}
+	spans := []Span{
+		{Text: "Some "},
+		{Text: "red", Color: color.RGBA{255, 0, 0, 255}, Underline: true},
+		{Text: " and "},
+		{Text: "large", Size: 30},
+		{Text: " text with x"},
+		{Text: "2", Size: 10, BaselineShift: 8},
+		{Text: " wrapped to the box"},
+	}
+	lines := dc.WordWrapSpans(spans, 280)
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, expected 2", len(lines))
+	}
+	dc.DrawSpansWrapped(spans, 10, 10, 0, 0, 280, 1.2, AlignCenter)
+	saveImage(dc, "TestRichText")
+	checkHash(t, dc, "1d30d01a64229ad49784b7b8abf70802")
+}
+
+// fontCollection returns a TrueType collection of fonts, moving the tables
+// of each font to where it is placed in the collection.
+func fontCollection(fonts ...[]byte) []byte {
+	header := 12 + 4*len(fonts)
+	data := make([]byte, header)
+	copy(data, "ttcf")
+	binary.BigEndian.PutUint32(data[4:], 0x00010000)
+	binary.BigEndian.PutUint32(data[8:], uint32(len(fonts)))
+	for i, f := range fonts {
+		base := len(data)
+		binary.BigEndian.PutUint32(data[12+4*i:], uint32(base))
+		f = append([]byte(nil), f...)
+		numTables := int(binary.BigEndian.Uint16(f[4:]))
+		for j := 0; j < numTables; j++ {
+			p := f[12+16*j+8:]
+			binary.BigEndian.PutUint32(p, binary.BigEndian.Uint32(p)+uint32(base))
+		}
+		data = append(data, f...)
+	}
+	return data
+}
+
+func TestRichTextSFNT(t *testing.T) {
+	// the second font of a collection is only read by sfnt, and its spans
+	// are sized like those of other fonts
+	dc := NewContext(100, 100)
+	if err := dc.LoadFontDataIndex(fontCollection(gobold.TTF, goregular.TTF), 1); err != nil {
+		t.Fatal(err)
+	}
+	if dc.font != nil || dc.sfntFont == nil {
+		t.Fatal("the font was not loaded with sfnt")
+	}
+	dc.SetFontSize(10)
+	small, _ := dc.MeasureSpans([]Span{{Text: "text"}})
+	large, _ := dc.MeasureSpans([]Span{{Text: "text", Size: 30}})
+	if math.Abs(large-3*small) > 1 {
+		t.Errorf("span of size 30 measured %v, expected about %v", large, 3*small)
+	}
+}
+
+func TestTextOnPath(t *testing.T) {
+	dc := NewContext(300, 200)
+	dc.SetRGB(1, 1, 1)
+	dc.Clear()
+	dc.SetRGB(0, 0, 0)
+	if err := dc.LoadFontData(goregular.TTF); err != nil {
+		t.Fatal(err)
+	}
+	dc.SetFontSize(18)
+	dc.DrawArc(150, 150, 100, math.Pi, 2*math.Pi)
+	dc.DrawStringOnPath("text on an arc", TextOnPath{Offset: 50 * math.Pi, Align: AlignCenter})
+	dc.Stroke()
+	points := []Point{{0, 180}, {150, 150}, {300, 180}}
+	dc.CreateStringPathOnPath("below a line", TextOnPath{Points: points, Offset: 150, Align: AlignCenter, Side: TextSideRight})
+	dc.Fill()
+	// glyphs beyond the end of the path are dropped
+	dc.CreateStringPathOnPath("text", TextOnPath{Points: []Point{{0, 0}, {1, 0}}, Offset: 10})
+	if _, ok := dc.GetCurrentPoint(); ok {
+		t.Error("glyphs past the end of the path were added")
+	}
+	saveImage(dc, "TestTextOnPath")
+	checkHash(t, dc, "0d4a3d5578777b34c000d1749524e230")
+}
+
+func TestLineBreaking(t *testing.T) {
+	wrap := func(s string, width float64, hyphenate Hyphenator, mode WrapMode) []string {
+		measure := func(i, j int, hyphen bool) float64 {
+			if hyphen {
+				j++
+			}
+			return float64(j - i)
+		}
+		var result []string
+		for _, line := range wrapRanges(s, width, measure, hyphenate, mode) {
+			result = append(result, line.text(s))
+		}
+		return result
+	}
+	s := "aaa bb cc ddddd"
+	if got, want := wrap(s, 6, nil, WrapGreedy), []string{"aaa bb", "cc", "ddddd"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("greedy: %q, expected %q", got, want)
+	}
+	if got, want := wrap(s, 6, nil, WrapOptimal), []string{"aaa", "bb cc", "ddddd"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("optimal: %q, expected %q", got, want)
+	}
+	hyphenate := func(word string) []int {
+		if word == "ddddd" {
+			return []int{2}
+		}
+		return nil
+	}
+	if got, want := wrap("cc ddddd", 6, hyphenate, WrapGreedy), []string{"cc dd-", "ddd"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("hyphenated: %q, expected %q", got, want)
+	}
+
+	// Chinese and Japanese text breaks between characters, but not before
+	// closing punctuation
+	dc := NewContext(100, 100)
+	if err := dc.LoadFontData(goregular.TTF); err != nil {
+		t.Fatal(err)
+	}
+	w, _ := dc.MeasureString("\ufffd\ufffd")
+	got := dc.WordWrap("漢字。漢字", w)
+	if want := []string{"漢", "字。", "漢字"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("CJK: %q, expected %q", got, want)
+	}
+}
+
+func TestAlignJustify(t *testing.T) {
+	dc := NewContext(200, 120)
+	dc.SetRGB(1, 1, 1)
+	dc.Clear()
+	dc.SetRGB(0, 0, 0)
+	if err := dc.LoadFontData(goregular.TTF); err != nil {
+		t.Fatal(err)
+	}
+	dc.SetFontSize(14)
+	dc.DrawStringWrapped("Justified text is stretched to fill the width of the box, except on the last line.", 10, 10, 0, 0, 180, 1.2, AlignJustify)
+	saveImage(dc, "TestAlignJustify")
+	checkHash(t, dc, "2969ac8e9436a7b4f697eb6ed34857fd")
+}
+
+func TestTruncation(t *testing.T) {
+	dc := NewContext(100, 100)
+	if err := dc.LoadFontData(goregular.TTF); err != nil {
+		t.Fatal(err)
+	}
+	s := "one two three four five six"
+	width, _ := dc.MeasureString("one two three")
+	tests := []struct {
+		t    Truncation
+		want []string
+	}{
+		{Truncation{MaxLines: 1}, []string{"one two three…"}},
+		{Truncation{MaxLines: 1, Ellipsis: "..."}, []string{"one two thr..."}},
+		{Truncation{MaxLines: 1, Position: TruncateStart}, []string{"…four five six"}},
+		{Truncation{MaxLines: 1, Position: TruncateMiddle}, []string{"one two…ve six"}},
+		{Truncation{MaxHeight: dc.FontHeight() * 2}, []string{"one two three", "four five six"}},
+	}
+	for _, test := range tests {
+		got, truncated := dc.WordWrapTruncated(s, width+dc.FontHeight(), 1, test.t)
+		if !reflect.DeepEqual(got, test.want) || truncated != (len(test.want) == 1) {
+			t.Errorf("%+v: %q %v, expected %q", test.t, got, truncated, test.want)
+		}
+	}
+
+	// text broken between ideographs is cut without adding spaces
+	cjk := "日本語の文章を折り返す"
+	w, _ := dc.MeasureString("日本語の文")
+	for _, position := range []TruncatePosition{TruncateEnd, TruncateStart, TruncateMiddle} {
+		got, truncated := dc.WordWrapTruncated(cjk, w, 1, Truncation{MaxLines: 1, Position: position})
+		if !truncated || len(got) != 1 {
+			t.Fatalf("position %d: %q %v, expected a truncated line", position, got, truncated)
+		}
+		for _, part := range strings.Split(got[0], "…") {
+			if !strings.Contains(cjk, part) {
+				t.Errorf("position %d: %q is not in the text", position, part)
+			}
+		}
+	}
+
+	// recorded truncated text keeps the truncation and its ellipsis
+	trunc := Truncation{MaxLines: 1, Ellipsis: "...", Position: TruncateMiddle}
+	dc.SetRGB(0, 0, 0)
+	dc.DrawStringTruncated(s, 0, 0, 0, 0, width, 1, AlignLeft, trunc)
+	rec := NewRecorder()
+	rec.DrawStringTruncated(s, 0, 0, 0, 0, width, 1, AlignLeft, trunc)
+	var buf bytes.Buffer
+	if err := rec.Encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeRecorder(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed := NewContext(100, 100)
+	if err := replayed.LoadFontData(goregular.TTF); err != nil {
+		t.Fatal(err)
+	}
+	replayed.SetRGB(0, 0, 0)
+	if err := decoded.Replay(replayed, Identity()); err != nil {
+		t.Fatal(err)
+	}
+	checkHash(t, replayed, hash(dc))
+}
+
+func TestTextMetrics(t *testing.T) {
+	dc := NewContext(100, 100)
+	if err := dc.LoadFontData(goregular.TTF); err != nil {
+		t.Fatal(err)
+	}
+	dc.SetFontSize(20)
+	m := dc.MeasureText("fig x")
+	if w, _ := dc.MeasureString("fig x"); math.Abs(m.Width-w) >= 1 {
+		t.Errorf("width %v, MeasureString %v", m.Width, w)
+	}
+	if m.Ascent <= 0 || m.Descent <= 0 || m.Ascent+m.Descent+m.LineGap != dc.FontHeight() {
+		t.Errorf("ascent %v, descent %v and line gap %v do not add up to the font height %v", m.Ascent, m.Descent, m.LineGap, dc.FontHeight())
+	}
+	// the descender of g goes below the baseline, the ascender of f above
+	// x-height, and the space has no ink
+	if m.InkMin.Y > -m.Ascent/2 || m.InkMax.Y <= 0 || m.InkMax.X > m.Width {
+		t.Errorf("ink bounds %v %v", m.InkMin, m.InkMax)
+	}
+	if len(m.Glyphs) != 5 {
+		t.Fatalf("got %d glyphs, expected 5", len(m.Glyphs))
+	}
+	for i, g := range m.Glyphs {
+		if g.Index != i {
+			t.Errorf("glyph %d has index %d", i, g.Index)
+		}
+		if i > 0 && g.X != m.Glyphs[i-1].X+m.Glyphs[i-1].Advance {
+			t.Errorf("glyph %d at %v, expected after glyph %d", i, g.X, i-1)
+		}
+	}
+
+	// ay=1 puts the top of the font at y
+	dc = NewContext(100, 100)
+	dc.SetRGB(0, 0, 0)
+	dc.DrawStringAnchored("H", 10, 10, 0, 1)
+	top := 100
+	for y := 0; y < 100 && top == 100; y++ {
+		for x := 0; x < 100; x++ {
+			if dc.im.RGBAAt(x, y).A != 0 {
+				top = y
+				break
+			}
+		}
+	}
+	if top < 10 || top > 13 {
+		t.Errorf("top of H at %d, expected just below 10", top)
+	}
+}
+
+func TestGlyphOutlines(t *testing.T) {
+	// bitmap faces have no outlines, but still advance
+	path := NewContext(100, 30)
+	w, _ := path.MeasureString("Hello")
+	if pw := path.CreateStringPath("Hello", 10, 20); pw != w {
+		t.Errorf("path width %v != measured width %v", pw, w)
+	}
+	if _, _, _, _, ok := path.PathBounds(); ok {
+		t.Error("bitmap face added a path")
+	}
+
+	// faces of fonts read with sfnt have outlines
+	f, err := parseSFNT(goregular.TTF, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dc := NewContext(200, 50)
+	dc.SetRGB(1, 1, 1)
+	dc.Clear()
+	dc.SetRGB(0, 0, 0)
+	dc.SetFontFace(newSFNTFace(f, 24))
+	dc.CreateStringPath("Outline", 10, 35)
+	dc.Fill()
+	saveImage(dc, "TestGlyphOutlines")
+	checkHash(t, dc, "a2a725e53847e59687b2b66ca8f17858")
+}
+
+func TestFontRegistry(t *testing.T) {
+	infos, err := FontInfos(goregular.TTF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := FontInfo{Family: "Go", Subfamily: "Regular", Weight: 400}
+	if len(infos) != 1 || infos[0] != want {
+		t.Errorf("got %+v, expected %+v", infos, want)
+	}
+
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"Go-Regular.ttf":    goregular.TTF,
+		"Go-Bold.ttf":       gobold.TTF,
+		"Go-Italic.ttf":     goitalic.TTF,
+		"Go-BoldItalic.ttf": gobolditalic.TTF,
+		"Go-Mono.ttf":       gomono.TTF,
+		"README.txt":        []byte("not a font"),
+		"broken/Broken.ttf": []byte("not a font either"),
+		"nested/GoMono.TTF": gomono.TTF,
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := NewFontRegistry()
+	if err := r.AddDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(r.Fonts()); n != 6 {
+		t.Errorf("registered %d fonts, expected 6", n)
+	}
+	tests := []struct {
+		weight int
+		italic bool
+		want   string
+	}{
+		{400, false, "Go-Regular.ttf"},
+		{600, false, "Go-Bold.ttf"},
+		{300, false, "Go-Regular.ttf"},
+		{900, true, "Go-BoldItalic.ttf"},
+		{400, true, "Go-Italic.ttf"},
+	}
+	for _, test := range tests {
+		info, ok := r.Match("go", test.weight, test.italic)
+		if !ok || filepath.Base(info.Path) != test.want {
+			t.Errorf("weight %d, italic %v: got %q, expected %s", test.weight, test.italic, info.Path, test.want)
+		}
+	}
+
+	dc := NewContext(100, 100)
+	dc.SetFontRegistry(r)
+	if err := dc.SetFontFamily("Go Mono", 400, false); err != nil {
+		t.Fatal(err)
+	}
+	if w1, _ := dc.MeasureString("i"); w1 == 0 {
+		t.Error("no font loaded")
+	} else if w2, _ := dc.MeasureString("m"); w1 != w2 {
+		t.Error("SetFontFamily did not load the monospaced font")
+	}
+	if err := dc.SetFontFamily("Missing", 400, false); err == nil {
+		t.Error("expected an error for a missing family")
+	}
+	if err := dc.LoadFontDataIndex(goregular.TTF, 1); err == nil {
+		t.Error("expected an error for a missing font index")
+	}
+}
+
+// variableFont returns ttf with a wght axis from 100 to 900 added. At weight
+// 900 the glyph of r is moved right by dx font units and its advance grows
+// by dx.
+func variableFont(t *testing.T, ttf []byte, r rune, dx int8) []byte {
+	f, err := truetype.Parse(ttf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf truetype.GlyphBuf
+	if err := buf.Load(f, fixed.Int26_6(f.FUnitsPerEm()), f.Index(r), font.HintingNone); err != nil {
+		t.Fatal(err)
+	}
+	var fvar bytes.Buffer
+	put(&fvar, uint16(1), uint16(0), uint16(16), uint16(2), uint16(1), uint16(20), uint16(0), uint16(8))
+	put(&fvar, []byte("wght"), uint32(100<<16), uint32(400<<16), uint32(900<<16), uint16(0), uint16(256))
+
+	// deltas of the points of the glyph and of its four phantom points, the
+	// second of which gives the advance, in runs of at most 64 bytes
+	n := len(buf.Points) + 4
+	var deltas bytes.Buffer
+	deltas.WriteByte(0) // all points
+	for i := 0; i < n; i += 64 {
+		k := n - i
+		if k > 64 {
+			k = 64
+		}
+		deltas.WriteByte(byte(k - 1))
+		for j := i; j < i+k; j++ {
+			if j == n-4 || j >= n-2 {
+				deltas.WriteByte(0)
+			} else {
+				deltas.WriteByte(byte(dx))
+			}
+		}
+	}
+	for i := 0; i < n; i += 64 {
+		k := n - i
+		if k > 64 {
+			k = 64
+		}
+		deltas.WriteByte(0x80 | byte(k-1)) // y deltas are zero
+	}
+	var glyph bytes.Buffer
+	// one tuple with private points, peaking at the maximum weight
+	put(&glyph, uint16(1), uint16(10), uint16(deltas.Len()), uint16(0xa000), uint16(0x4000))
+	glyph.Write(deltas.Bytes())
+	if glyph.Len()%2 != 0 {
+		glyph.WriteByte(0)
+	}
+
+	numGlyphs := int(binary.BigEndian.Uint16(fontTables(ttf)["maxp"][4:]))
+	dataOffset := uint32(20 + 2*(numGlyphs+1))
+	var gvar bytes.Buffer
+	put(&gvar, uint16(1), uint16(0), uint16(1), uint16(0), dataOffset, uint16(numGlyphs), uint16(0), dataOffset)
+	for i := 0; i <= numGlyphs; i++ {
+		if i > int(f.Index(r)) {
+			put(&gvar, uint16(glyph.Len()/2))
+		} else {
+			put(&gvar, uint16(0))
+		}
+	}
+	gvar.Write(glyph.Bytes())
+	return withTables(ttf, map[string][]byte{"fvar": fvar.Bytes(), "gvar": gvar.Bytes()})
+}
+
+// ligatureFont returns ttf with a GSUB table whose liga feature replaces the
+// glyphs of a and b with the glyph of r.
+func ligatureFont(t *testing.T, ttf []byte, a, b, r rune) []byte {
+	f, err := truetype.Parse(ttf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gsub bytes.Buffer
+	// header, followed by the script, feature and lookup lists
+	put(&gsub, uint16(1), uint16(0), uint16(10), uint16(30), uint16(44))
+	// the DFLT script, whose default language system has feature 0
+	put(&gsub, uint16(1), []byte("DFLT"), uint16(8), uint16(4), uint16(0))
+	put(&gsub, uint16(0), uint16(0xffff), uint16(1), uint16(0))
+	// the liga feature, which has lookup 0
+	put(&gsub, uint16(1), []byte("liga"), uint16(8), uint16(0), uint16(1), uint16(0))
+	// a ligature substitution lookup with one subtable, its coverage of a,
+	// the ligature set of a and its only ligature
+	put(&gsub, uint16(1), uint16(4), uint16(4), uint16(0), uint16(1), uint16(8))
+	put(&gsub, uint16(1), uint16(8), uint16(1), uint16(14))
+	put(&gsub, uint16(1), uint16(1), uint16(f.Index(a)))
+	put(&gsub, uint16(1), uint16(4))
+	put(&gsub, uint16(f.Index(r)), uint16(2), uint16(f.Index(b)))
+	return withTables(ttf, map[string][]byte{"GSUB": gsub.Bytes()})
+}
+
+// put writes values to b in big-endian byte order.
+func put(b *bytes.Buffer, values ...interface{}) {
+	for _, v := range values {
+		binary.Write(b, binary.BigEndian, v)
+	}
+}
+
+// fontTables returns the tables of ttf by tag.
+func fontTables(ttf []byte) map[string][]byte {
+	be := binary.BigEndian
+	tables := map[string][]byte{}
+	for i := 0; i < int(be.Uint16(ttf[4:])); i++ {
+		rec := ttf[12+16*i:]
+		offset, length := be.Uint32(rec[8:]), be.Uint32(rec[12:])
+		tables[string(rec[:4])] = ttf[offset : offset+length]
+	}
+	return tables
+}
+
+// withTables returns ttf with tables added or replaced.
+func withTables(ttf []byte, tables map[string][]byte) []byte {
+	be := binary.BigEndian
+	for tag, data := range fontTables(ttf) {
+		if _, ok := tables[tag]; !ok {
+			tables[tag] = data
+		}
+	}
+	var tags []string
+	for tag := range tables {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	var out bytes.Buffer
+	put(&out, be.Uint32(ttf), uint16(len(tables)), uint16(0), uint16(0), uint16(0))
+	offset := 12 + 16*len(tables)
+	for _, tag := range tags {
+		put(&out, []byte(tag), uint32(0), uint32(offset), uint32(len(tables[tag])))
+		offset += (len(tables[tag]) + 3) &^ 3
+	}
+	for _, tag := range tags {
+		out.Write(tables[tag])
+		out.Write(make([]byte, (4-len(tables[tag])%4)%4))
+	}
+	return out.Bytes()
+}
+
+func TestFontVariations(t *testing.T) {
+	dc := NewContext(200, 100)
+	dc.SetRGB(1, 1, 1)
+	dc.Clear()
+	dc.SetRGB(0, 0, 0)
+	if err := dc.LoadFontData(variableFont(t, goregular.TTF, 'l', 100)); err != nil {
+		t.Fatal(err)
+	}
+	dc.SetFontSize(2048.0 / 40) // 40 font units per pixel
+	w0 := dc.MeasureText("l").Width
+	for _, test := range []struct {
+		weight, grow float64
+	}{{400, 0}, {650, 1.25}, {900, 2.5}, {1000, 2.5}, {100, 0}} {
+		if err := dc.SetFontVariations(map[string]float64{"wght": test.weight}); err != nil {
+			t.Fatal(err)
+		}
+		if w := dc.MeasureText("l").Width; math.Abs(w-w0-test.grow) > 1.0/16 {
+			t.Errorf("weight %v: got width %v, expected %v", test.weight, w, w0+test.grow)
+		}
+	}
+	dc.SetFontVariations(nil)
+	dc.DrawString("lll", 10, 40)
+	dc.SetFontVariations(map[string]float64{"wght": 900})
+	dc.DrawString("lll", 10, 90)
+	dc.CreateStringPath("lll", 100, 90)
+	dc.Fill()
+	saveImage(dc, "TestFontVariations")
+	checkHash(t, dc, "517c7592979f63835a6a13209c37bf0c")
+
+	if err := dc.SetFontVariations(map[string]float64{"weight": 700}); err == nil {
+		t.Error("expected an error for an invalid axis tag")
+	}
+}
+
+// colorFont returns ttf with a COLR glyph for 'A', made of an 'O' in red and
+// an 'l' in the color of the text, and an sbix bitmap for 'B', which is a
+// square of four colors one em wide.
+func colorFont(t *testing.T, ttf []byte) []byte {
+	f, err := truetype.Parse(ttf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var colr bytes.Buffer
+	put(&colr, uint16(0), uint16(1), uint32(14), uint32(20), uint16(2))
+	put(&colr, uint16(f.Index('A')), uint16(0), uint16(2))
+	put(&colr, uint16(f.Index('O')), uint16(0), uint16(f.Index('l')), uint16(0xffff))
+	var cpal bytes.Buffer
+	put(&cpal, uint16(0), uint16(1), uint16(1), uint16(1), uint32(14), uint16(0))
+	cpal.Write([]byte{0, 0, 0xff, 0xff})
+
+	im := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	im.Set(0, 0, color.NRGBA{0xff, 0, 0, 0xff})
+	im.Set(1, 0, color.NRGBA{0, 0xff, 0, 0xff})
+	im.Set(0, 1, color.NRGBA{0, 0, 0xff, 0xff})
+	im.Set(1, 1, color.NRGBA{0xff, 0xff, 0, 0xff})
+	var data bytes.Buffer
+	put(&data, int16(0), int16(0), []byte("png "))
+	if err := png.Encode(&data, im); err != nil {
+		t.Fatal(err)
+	}
+	numGlyphs := int(binary.BigEndian.Uint16(fontTables(ttf)["maxp"][4:]))
+	var sbix bytes.Buffer
+	put(&sbix, uint16(1), uint16(1), uint32(1), uint32(12))
+	put(&sbix, uint16(2), uint16(72)) // a strike of 2 pixels per em
+	offset := 4 + 4*(numGlyphs+1)
+	for i := 0; i <= numGlyphs; i++ {
+		if i > int(f.Index('B')) {
+			put(&sbix, uint32(offset+data.Len()))
+		} else {
+			put(&sbix, uint32(offset))
+		}
+	}
+	sbix.Write(data.Bytes())
+	return withTables(ttf, map[string][]byte{"COLR": colr.Bytes(), "CPAL": cpal.Bytes(), "sbix": sbix.Bytes()})
+}
+
+func TestColorGlyphs(t *testing.T) {
+	dc := NewContext(200, 60)
+	dc.SetRGB(1, 1, 1)
+	dc.Clear()
+	dc.SetRGB(0, 0, 1)
+	if err := dc.LoadFontData(colorFont(t, goregular.TTF)); err != nil {
+		t.Fatal(err)
+	}
+	dc.SetFontSize(40)
+	dc.DrawString("ABC", 10, 45)
+	saveImage(dc, "TestColorGlyphs")
+	checkHash(t, dc, "7cff0392c54f66786bb874b9f356572f")
+
+	// colour glyphs keep the advances of the font
+	plain := NewContext(100, 100)
+	if err := plain.LoadFontData(goregular.TTF); err != nil {
+		t.Fatal(err)
+	}
+	plain.SetFontSize(40)
+	w1, _ := dc.MeasureString("ABC")
+	w2, _ := plain.MeasureString("ABC")
+	if w1 != w2 {
+		t.Errorf("got width %v, expected %v", w1, w2)
+	}
+}
+
+// verticalFont returns ttf with the glyphs of 'A', 'B' and 'C' mapped to
+// 日, 本 and 語 as well, and vertical metrics that give every glyph an
+// advance of one em.
+func verticalFont(t *testing.T, ttf []byte) []byte {
+	f, err := truetype.Parse(ttf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a format 12 cmap with one group per character
+	runes := map[rune]rune{'日': 'A', '本': 'B', '語': 'C'}
+	for r := rune(' '); r <= '~'; r++ {
+		runes[r] = r
+	}
+	var keys []int
+	for r := range runes {
+		keys = append(keys, int(r))
+	}
+	sort.Ints(keys)
+	var cmap bytes.Buffer
+	put(&cmap, uint16(0), uint16(1), uint16(3), uint16(10), uint32(12))
+	put(&cmap, uint16(12), uint16(0), uint32(16+12*len(keys)), uint32(0), uint32(len(keys)))
+	for _, r := range keys {
+		put(&cmap, uint32(r), uint32(r), uint32(f.Index(runes[rune(r)])))
+	}
+
+	upem := int16(f.FUnitsPerEm())
+	numGlyphs := int(binary.BigEndian.Uint16(fontTables(ttf)["maxp"][4:]))
+	var vhea bytes.Buffer
+	put(&vhea, uint32(0x00011000), upem/2, -upem/2, int16(0), upem, int16(0), int16(0), upem)
+	put(&vhea, int16(0), int16(1), int16(0), [5]int16{}, uint16(1))
+	var vmtx bytes.Buffer
+	put(&vmtx, upem, int16(0))
+	vmtx.Write(make([]byte, 2*(numGlyphs-1)))
+	return withTables(ttf, map[string][]byte{"cmap": cmap.Bytes(), "vhea": vhea.Bytes(), "vmtx": vmtx.Bytes()})
+}
+
+func TestVerticalText(t *testing.T) {
+	dc := NewContext(200, 200)
+	dc.SetRGB(1, 1, 1)
+	dc.Clear()
+	dc.SetRGB(0, 0, 0)
+	if err := dc.LoadFontData(verticalFont(t, goregular.TTF)); err != nil {
+		t.Fatal(err)
+	}
+	dc.SetFontSize(2048.0 / 64) // 32 pixels per em
+	horizontal := dc.MeasureText("abc").Width
+	dc.SetWritingMode(WritingModeVerticalRL)
+	for _, test := range []struct {
+		s      string
+		length float64
+	}{{"日本語", 96}, {"abc", horizontal}, {"日本abc", 64 + horizontal}} {
+		w, h := dc.MeasureString(test.s)
+		if w != dc.FontHeight() || math.Abs(h-test.length) > 1.0/64 {
+			t.Errorf("%q: got %v, %v, expected %v, %v", test.s, w, h, dc.FontHeight(), test.length)
+		}
+	}
+	dc.DrawStringAnchored("日本abc", 190, 10, 1, 0)
+	dc.DrawStringWrapped("日本語 abc 日本語 abc", 150, 10, 1, 0, 180, 1, AlignLeft)
+	dc.SetWritingMode(WritingModeVerticalLR)
+	dc.DrawStringWrapped("abc 日本", 10, 190, 0, 1, 100, 1, AlignRight)
+	saveImage(dc, "TestVerticalText")
+	checkHash(t, dc, "dbff0eba1b5b0868b7608cd321854777")
+}
+
+func TestTextDecorations(t *testing.T) {
+	dc := NewContext(300, 200)
+	dc.SetRGB(1, 1, 1)
+	dc.Clear()
+	dc.SetRGB(0, 0, 0)
+	if err := dc.LoadFontData(goregular.TTF); err != nil {
+		t.Fatal(err)
+	}
+	dc.SetFontSize(24)
+	dc.SetTextDecoration(TextDecorationUnderline | TextDecorationStrikethrough)
+	dc.DrawString("Jumpy gig", 10, 40)
+	dc.SetTextDecorationSkipInk(true)
+	dc.SetTextDecorationColor(color.RGBA{255, 0, 0, 255})
+	dc.DrawString("Jumpy gig", 150, 40)
+	dc.SetTextDecoration(TextDecorationOverline | TextDecorationUnderline)
+	dc.DrawStringWrapped("quietly typing long lines", 10, 70, 0, 0, 180, 1.5, AlignJustify)
+	dc.RotateAbout(-math.Pi/8, 220, 160)
+	dc.SetTextDecorationColor(nil)
+	dc.DrawStringAnchored("Tilted", 220, 160, 0.5, 0.5)
+	saveImage(dc, "TestTextDecorations")
+	checkHash(t, dc, "e16bbbdb24a446a5a2e5d71fc6f897dc")
+}
+
+func TestTextSpacing(t *testing.T) {
+	dc := NewContext(300, 120)
+	dc.SetRGB(1, 1, 1)
+	dc.Clear()
+	dc.SetRGB(0, 0, 0)
+	if err := dc.LoadFontData(goregular.TTF); err != nil {
+		t.Fatal(err)
+	}
+	dc.SetFontSize(16)
+	abc := dc.MeasureText("ab c").Width
+	dc.SetLetterSpacing(2)
+	dc.SetWordSpacing(5)
+	if w := dc.MeasureText("ab c").Width; math.Abs(w-abc-2*3-5) > 1.0/64 {
+		t.Errorf("got width %v, expected %v", w, abc+2*3+5)
+	}
+	dc.DrawString("SPACED OUT", 10, 25)
+	dc.SetLetterSpacing(0)
+	dc.SetWordSpacing(0)
+
+	dc.SetTabInterval(50)
+	dc.SetTabStops(120, 60)
+	glyphs := dc.MeasureText("a\tb\tc\td").Glyphs
+	for i, x := range []float64{60, 120, 150} {
+		if g := glyphs[2*i+2]; g.X != x {
+			t.Errorf("tab %d: got text at %v, expected %v", i, g.X, x)
+		}
+	}
+	dc.DrawString("item\tqty\tprice", 10, 60)
+	dc.DrawString("apples\t3\t1.20", 10, 80)
+	dc.DrawLine(10+120, 40, 10+120, 85)
+	dc.Stroke()
+	dc.SetTabStops()
+	dc.DrawString("a\tb\tc\td", 10, 105)
+	saveImage(dc, "TestTextSpacing")
+	checkHash(t, dc, "b04555b0ab6009c40d50731698040377")
+}
+
+func TestTextPatterns(t *testing.T) {
+	dc := NewContext(300, 150)
+	dc.SetRGB(1, 1, 1)
+	dc.Clear()
+	if err := dc.LoadFontData(gobold.TTF); err != nil {
+		t.Fatal(err)
+	}
+	dc.SetFontSize(40)
+	g := NewLinearGradient(10, 0, 290, 0)
+	g.AddColorStop(0, color.RGBA{255, 0, 0, 255})
+	g.AddColorStop(1, color.RGBA{0, 0, 255, 255})
+	dc.SetFillStyle(g)
+	dc.DrawString("Gradient", 10, 45)
+
+	dc.DrawRectangle(0, 60, 150, 90)
+	dc.Clip()
+	dc.DrawString("Clipped", 10, 95)
+	dc.ResetClip()
+
+	dc.MoveTo(10, 140)
+	dc.LineTo(290, 140)
+	dc.SetRGB(0, 0.5, 0)
+	dc.SetLineWidth(2)
+	dc.SetDash(4, 2)
+	dc.DrawStringStrokeAnchored("Stroked", 290, 130, 1, 0)
+	dc.SetDash()
+	dc.Stroke() // the path drawn before the text
+	saveImage(dc, "TestTextPatterns")
+	checkHash(t, dc, "a195c25cedc8547c920fb8fed5e9885d")
+}
+
+func TestTextCache(t *testing.T) {
+	dc := NewContext(200, 100)
+	if err := dc.LoadFontData(goregular.TTF); err != nil {
+		t.Fatal(err)
+	}
+	dc.SetFontSize(20)
+	w, _ := dc.MeasureString("cached text")
+	dc.SetLetterSpacing(2)
+	if w2, _ := dc.MeasureString("cached text"); w2 <= w {
+		t.Errorf("letter spacing ignored by measured width: %v <= %v", w2, w)
+	}
+	dc.SetLetterSpacing(0)
+	if w2, _ := dc.MeasureString("cached text"); w2 != w {
+		t.Errorf("expected width %v, got %v", w, w2)
+	}
+
+	// text drawn again, translated by whole pixels, comes from the cache
+	dc.SetRGB(0, 0, 0)
+	dc.DrawString("cached text", 10.25, 30.5)
+	a := image.NewRGBA(image.Rect(0, 0, 200, 40))
+	draw.Draw(a, a.Bounds(), dc.Image(), image.Pt(0, 0), draw.Src)
+	dc.Translate(0, 50)
+	dc.DrawString("cached text", 10.25, 30.5)
+	b := image.NewRGBA(image.Rect(0, 0, 200, 40))
+	draw.Draw(b, b.Bounds(), dc.Image(), image.Pt(0, 50), draw.Src)
+	if !bytes.Equal(a.Pix, b.Pix) {
+		t.Error("translated text differs")
+	}
+
+	// subpixel offsets are quantized, so a glyph drawn at many offsets has
+	// at most four masks
+	for i := 0; i < 64; i++ {
+		dc.DrawString("x", 10+float64(i)/64, 20+float64(i)/64)
+	}
+	masks := 0
+	glyphMasks.mu.Lock()
+	for key := range glyphMasks.entries {
+		if key.face == dc.fontFace && key.r == 'x' {
+			masks++
+		}
+	}
+	glyphMasks.mu.Unlock()
+	if masks > 4 {
+		t.Errorf("%d masks of x are cached, expected at most 4", masks)
+	}
+}
+
+const testBDF = `STARTFONT 2.1
+FONT -gg-test-medium-r-normal--8-80-75-75-c-60-iso10646-1
+SIZE 8 75 75
+FONTBOUNDINGBOX 5 8 0 -2
+STARTPROPERTIES 5
+FONT_ASCENT 6
+FONT_DESCENT 2
+CHARSET_REGISTRY "ISO10646"
+CHARSET_ENCODING "1"
+DEFAULT_CHAR 0
+ENDPROPERTIES
+CHARS 3
+STARTCHAR box
+ENCODING 0
+DWIDTH 6 0
+BBX 4 6 0 0
+BITMAP
+F0
+90
+90
+90
+90
+F0
+ENDCHAR
+STARTCHAR A
+ENCODING 65
+DWIDTH 6 0
+BBX 5 6 0 0
+BITMAP
+20
+50
+88
+F8
+88
+88
+ENDCHAR
+STARTCHAR g
+ENCODING 103
+DWIDTH 5 0
+BBX 4 6 0 -2
+BITMAP
+70
+90
+90
+70
+10
+60
+ENDCHAR
+ENDFONT
+`
+
+// pcfFont encodes a bitmap font with single byte codes as a PCF font, most
+// significant byte first, with the bits of the bitmaps least significant
+// first and their rows padded to four bytes.
+func pcfFont(f *bitmapFont) []byte {
+	const format = 1<<2 | 2
+	be := binary.BigEndian
+	table := func(write func(b *bytes.Buffer)) []byte {
+		var b bytes.Buffer
+		binary.Write(&b, binary.LittleEndian, uint32(format))
+		write(&b)
+		return b.Bytes()
+	}
+	var codes []int
+	for code := range f.glyphs {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+
+	properties := table(func(b *bytes.Buffer) {
+		var names []string
+		for name := range f.properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		var values bytes.Buffer
+		binary.Write(b, be, int32(len(names)))
+		for _, name := range names {
+			binary.Write(b, be, int32(values.Len()))
+			values.WriteString(name + "\x00")
+			b.WriteByte(1)
+			binary.Write(b, be, int32(values.Len()))
+			values.WriteString(f.properties[name] + "\x00")
+		}
+		b.Write(make([]byte, (4-len(names)%4)%4))
+		binary.Write(b, be, int32(values.Len()))
+		b.Write(values.Bytes())
+	})
+	metrics := table(func(b *bytes.Buffer) {
+		binary.Write(b, be, int32(len(codes)))
+		for _, code := range codes {
+			g := f.glyphs[code]
+			r := g.mask.Rect
+			binary.Write(b, be, []int16{int16(r.Min.X), int16(r.Max.X), int16(g.advance), int16(-r.Min.Y), int16(r.Max.Y), 0})
+		}
+	})
+	bitmaps := table(func(b *bytes.Buffer) {
+		var bits bytes.Buffer
+		var offsets []int32
+		for _, code := range codes {
+			offsets = append(offsets, int32(bits.Len()))
+			m := f.glyphs[code].mask
+			for y := m.Rect.Min.Y; y < m.Rect.Max.Y; y++ {
+				row := make([]byte, ((m.Rect.Dx()+7)/8+3)/4*4)
+				for x := 0; x < m.Rect.Dx(); x++ {
+					if m.AlphaAt(m.Rect.Min.X+x, y).A != 0 {
+						row[x/8] |= 1 << uint(x%8)
+					}
+				}
+				bits.Write(row)
+			}
+		}
+		binary.Write(b, be, int32(len(codes)))
+		binary.Write(b, be, offsets)
+		binary.Write(b, be, []int32{0, 0, int32(bits.Len()), 0})
+		b.Write(bits.Bytes())
+	})
+	encodings := table(func(b *bytes.Buffer) {
+		indices := make([]uint16, 256)
+		for i := range indices {
+			indices[i] = 0xffff
+		}
+		for i, code := range codes {
+			indices[code] = uint16(i)
+		}
+		binary.Write(b, be, []int16{0, 255, 0, 0, int16(f.defaultChar)})
+		binary.Write(b, be, indices)
+	})
+
+	tables := []struct {
+		kind int32
+		data []byte
+	}{
+		{pcfProperties, properties},
+		{pcfMetrics, metrics},
+		{pcfBitmaps, bitmaps},
+		{pcfBDFEncodings, encodings},
+	}
+	var b bytes.Buffer
+	b.WriteString("\x01fcp")
+	binary.Write(&b, binary.LittleEndian, int32(len(tables)))
+	offset := 8 + 16*len(tables)
+	for _, t := range tables {
+		binary.Write(&b, binary.LittleEndian, []int32{t.kind, format, int32(len(t.data)), int32(offset)})
+		offset += len(t.data)
+	}
+	for _, t := range tables {
+		b.Write(t.data)
+	}
+	return b.Bytes()
+}
+
+func TestBitmapFonts(t *testing.T) {
+	bdf, err := ParseBDFFace([]byte(testBDF))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := parseBDF([]byte(testBDF))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write(pcfFont(f))
+	w.Close()
+	pcf, err := ParsePCFFace(gz.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := pcf.Metrics(); m.Ascent != fixed.I(6) || m.Height != fixed.I(8) {
+		t.Errorf("unexpected metrics %+v", m)
+	}
+	if a, ok := pcf.GlyphAdvance('g'); !ok || a != fixed.I(5) {
+		t.Errorf("expected advance 5 for g, got %v", a)
+	}
+
+	render := func(face font.Face) *Context {
+		dc := NewContext(120, 60)
+		dc.SetRGB(1, 1, 1)
+		dc.Clear()
+		dc.SetRGB(0, 0, 0)
+		dc.SetFontFace(face)
+		// z is missing and drawn with the default character
+		dc.DrawString("Agz", 2.3, 8.6)
+		dc.Push()
+		dc.Scale(4, 4)
+		dc.DrawString("Ag", 1, 12)
+		dc.Pop()
+		dc.Push()
+		dc.Translate(100, 4)
+		dc.Rotate(math.Pi / 2)
+		dc.Scale(2, 2)
+		dc.DrawString("Agz", 0, 0)
+		dc.Pop()
+		return dc
+	}
+	dc := render(bdf)
+	im := dc.Image().(*image.RGBA)
+	for i, v := range im.Pix {
+		if v != 0 && v != 255 {
+			t.Fatalf("pixel %d is not black or white: %d", i/4, v)
+		}
+	}
+	if !bytes.Equal(im.Pix, render(pcf).Image().(*image.RGBA).Pix) {
+		t.Error("BDF and PCF fonts differ")
+	}
+	saveImage(dc, "TestBitmapFonts")
+	checkHash(t, dc, "8d1e669272e1490c6c04c87957f51e72")
+
+	// corrupt fonts are errors
+	for _, r := range [][2]string{
+		{"BBX 4 6 0 0", "BBX -4 6 0 0"},
+		{"BBX 4 6 0 0", "BBX 4 100000000 0 0"},
+		{"FONTBOUNDINGBOX 5 8 0 -2", "FONTBOUNDINGBOX 5 8 0 -99999999999"},
+	} {
+		if _, err := ParseBDFFace([]byte(strings.Replace(testBDF, r[0], r[1], 1))); err == nil {
+			t.Errorf("%s: expected an error", r[1])
+		}
+	}
+	data := pcfFont(f)
+	for n := 0; n < len(data); n++ {
+		if _, err := ParsePCFFace(data[:n]); err == nil {
+			t.Errorf("PCF font cut to %d bytes: expected an error", n)
+		}
+	}
+}
+
+func TestFontSubset(t *testing.T) {
+	f, err := truetype.Parse(goregular.TTF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subset, err := SubsetTrueTypeFont(f, []rune("Hello"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subset.GlyphIDs) != 5 || subset.GlyphIDs[0] != 0 {
+		t.Errorf("expected glyphs 0, H, e, l and o, got %v", subset.GlyphIDs)
+	}
+	if _, err := parseSFNT(subset.Data, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseOTFont(subset.Data, 0); err != nil {
+		t.Fatal(err)
+	}
+	sf, err := truetype.Parse(subset.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sf.Index('x') != 0 || sf.Index('H') != truetype.Index(subset.GlyphIDs[int(f.Index('H'))]) {
+		t.Error("unexpected character map")
+	}
+
+	// text drawn with the glyphs recorded while drawing looks the same
+	draw := func(ttf []byte, record bool) *Context {
+		dc := NewContext(200, 60)
+		if err := dc.LoadFontData(ttf); err != nil {
+			t.Fatal(err)
+		}
+		dc.SetFontSize(24)
+		dc.RecordGlyphs(record)
+		dc.SetRGB(1, 1, 1)
+		dc.Clear()
+		dc.SetRGB(0, 0, 0)
+		dc.DrawString("Hello, world", 10, 30)
+		dc.Push()
+		dc.DrawString("Hi", 10, 55)
+		dc.Pop()
+		return dc
+	}
+	dc := draw(goregular.TTF, true)
+	sets := dc.RecordedGlyphs()
+	if len(sets) != 1 || string(sets[0].Runes) != " ,Hdeilorw" {
+		t.Fatalf("unexpected recorded glyphs %+v", sets)
+	}
+	subset, err = sets[0].Subset()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dc.Image().(*image.RGBA).Pix, draw(subset.Data, false).Image().(*image.RGBA).Pix) {
+		t.Error("text drawn with the subset differs")
+	}
+
+	// subsets keep the style and embedding permissions of the font
+	os2 := append([]byte(nil), fontTables(gobold.TTF)["OS/2"]...)
+	binary.BigEndian.PutUint16(os2[8:], 8) // editable embedding
+	bold := withTables(gobold.TTF, map[string][]byte{"OS/2": os2})
+	boldSFNT, err := parseSFNT(bold, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	boldTrueType, err := truetype.Parse(bold)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shaped := NewContext(1, 1)
+	if err := shaped.LoadFontData(bold); err != nil {
+		t.Fatal(err)
+	}
+	shaped.SetFontSize(12)
+	subsets := map[string]func() (*FontSubset, error){
+		"sfnt":     func() (*FontSubset, error) { return SubsetSFNTFont(boldSFNT, []rune("Hi"), nil) },
+		"face":     func() (*FontSubset, error) { return SubsetFontFace(shaped.fontFace, []rune("Hi"), nil) },
+		"truetype": func() (*FontSubset, error) { return SubsetTrueTypeFont(boldTrueType, []rune("Hi"), nil) },
+	}
+	for name, subsetFunc := range subsets {
+		subset, err := subsetFunc()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sf, err := truetype.Parse(subset.Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		os2 := fontTables(subset.Data)["OS/2"]
+		weight, fsType, fsSelection := binary.BigEndian.Uint16(os2[4:]), binary.BigEndian.Uint16(os2[8:]), binary.BigEndian.Uint16(os2[62:])
+		// truetype does not read the OS/2 table, so the weight is guessed
+		// from the subfamily and embedding is not restricted
+		wantWeight, wantFSType := uint16(600), uint16(8)
+		if name == "truetype" {
+			wantWeight, wantFSType = 700, 0
+		}
+		if weight != wantWeight || fsType != wantFSType || fsSelection&0x61 != 0x20 {
+			t.Errorf("%s: subset has weight %d, fsType %d and fsSelection %#x", name, weight, fsType, fsSelection)
+		}
+		if family, subfamily := sf.Name(truetype.NameIDFontFamily), sf.Name(truetype.NameIDFontSubfamily); family != "Go" || subfamily != "Bold" {
+			t.Errorf("%s: subset is named %q %q", name, family, subfamily)
+		}
+	}
+}
+
+func TestSVGPath(t *testing.T) {
+	dc := NewContext(200, 100)
+	dc.SetRGB(1, 1, 1)
+	dc.Clear()
+	dc.SetRGB(0, 0, 0)
+	dc.MoveTo(10, 10)
+	dc.LineTo(50, 10)
+	dc.LineTo(50, 40.5)
+	dc.LineTo(10.25, 40.5)
+	dc.ClosePath()
+	if d := dc.SVGPath(); d != "M10 10H50V40.5H10.25z" {
+		t.Errorf("unexpected path data %q", d)
+	}
+	dc.ClearPath()
+
+	// every command, absolute and relative, with numbers run together
+	paths := []string{
+		"M60,10 h30 v20 H60 z m0 30 l10-5L80 40.5 85.5.5e2 c5 0 10 5 10 10s5 10 10 10",
+		"M110 10 Q120-5 130 10 T150 10 t20 0 q5 10 0 20 Z",
+		"M110 60 a20 10 30 1 0 40 0 A10 10 0 0 1 170 60 a5 20 0 01 20 10 z",
+		"M10 60 L10 90 a15 15 0 0 0 30 0 V60 Z",
+	}
+	for _, d := range paths {
+		if err := dc.DrawSVGPath(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dc.SetLineWidth(2)
+	dc.StrokePreserve()
+
+	// the path written and read back is the same path
+	d := dc.SVGPath()
+	dc.ClearPath()
+	if err := dc.DrawSVGPath(d); err != nil {
+		t.Fatal(err)
+	}
+	if d2 := dc.SVGPath(); d2 != d {
+		t.Errorf("path data changed after a round trip:\n%s\n%s", d, d2)
+	}
+	dc.SetRGBA(0, 0, 1, 0.5)
+	dc.Fill()
+
+	if err := dc.DrawSVGPath("M10 10 L20 20 X"); err == nil {
+		t.Error("expected an error")
+	}
+	saveImage(dc, "TestSVGPath")
+	checkHash(t, dc, "da5cec92f323820e9390175c2f527743")
+}
+
+func TestSVGPathTransformed(t *testing.T) {
+	dc := NewContext(100, 100)
+	dc.Translate(50, 0)
+	dc.Scale(2, 3)
+	dc.Rotate(Radians(30))
+	const d = "M10 10C20 0 30 20 40 10q5 10 0 20z"
+	if err := dc.DrawSVGPath(d); err != nil {
+		t.Fatal(err)
+	}
+	if got := dc.SVGPath(); got != d {
+		t.Errorf("unexpected path data %q, expected %q", got, d)
+	}
+	x0, y0, x1, y1, _ := dc.PathBounds()
+
+	// reading the data back under the same matrix adds the same path
+	dc.ClearPath()
+	if err := dc.DrawSVGPath(d); err != nil {
+		t.Fatal(err)
+	}
+	bx0, by0, bx1, by1, _ := dc.PathBounds()
+	if bx0 != x0 || by0 != y0 || bx1 != x1 || by1 != y1 {
+		t.Errorf("bounds changed after a round trip: %v %v %v %v, expected %v %v %v %v", bx0, by0, bx1, by1, x0, y0, x1, y1)
+	}
+}
+
+func TestStrokeToPath(t *testing.T) {
+	dc := NewContext(100, 100)
+	dc.Scale(4, 1)
+	dc.SetLineWidth(2)
+	dc.SetLineCapButt()
+	dc.DrawLine(10, 10, 10, 20)
+	dc.StrokeToPath()
+	// the outline is as wide as the line in user space
+	x0, y0, x1, y1, ok := dc.PathBounds()
+	for _, v := range [][2]float64{{x0, 9}, {y0, 10}, {x1, 11}, {y1, 20}} {
+		if !ok || math.Abs(v[0]-v[1]) > 0.05 {
+			t.Errorf("outline bounds %v %v %v %v, expected 9 10 11 20", x0, y0, x1, y1)
+			break
+		}
+	}
+	dc.Fill()
+	// and so 8 pixels wide on the image
+	for _, p := range []struct {
+		x int
+		a uint8
+	}{{35, 0}, {36, 255}, {43, 255}, {44, 0}} {
+		if a := dc.im.RGBAAt(p.x, 15).A; a != p.a {
+			t.Errorf("alpha at %d,15 is %d, expected %d", p.x, a, p.a)
+		}
+	}
+}
+
+func TestPathBounds(t *testing.T) {
+	dc := NewContext(100, 100)
+	if _, _, _, _, ok := dc.PathBounds(); ok {
+		t.Error("empty path has bounds")
+	}
+	dc.Translate(10, 20)
+	dc.Scale(2, 2)
+	dc.DrawCircle(10, 10, 5)
+	dc.MoveTo(0, 30)
+	dc.LineTo(12, 30)
+	x0, y0, x1, y1, ok := dc.PathBounds()
+	round := func(x float64) float64 {
+		return math.Round(x*1000) / 1000
+	}
+	if !ok || round(x0) != 0 || round(y0) != 5 || round(x1) != 15 || round(y1) != 30 {
+		t.Errorf("got bounds %g, %g, %g, %g, %v, expected 0, 5, 15, 30, true", x0, y0, x1, y1, ok)
+	}
+}
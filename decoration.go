@@ -0,0 +1,192 @@
+package gg
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"sort"
+
+	otfont "github.com/go-text/typesetting/font"
+	"golang.org/x/image/font"
+)
+
+// TextDecoration is a set of lines drawn along text. Decorations can be
+// combined with |.
+type TextDecoration int
+
+const (
+	// TextDecorationUnderline draws a line under the baseline.
+	TextDecorationUnderline TextDecoration = 1 << iota
+	// TextDecorationStrikethrough draws a line through the text.
+	TextDecorationStrikethrough
+	// TextDecorationOverline draws a line along the top of the font.
+	TextDecorationOverline
+
+	// TextDecorationNone draws no lines.
+	TextDecorationNone TextDecoration = 0
+)
+
+// SetTextDecoration sets the lines drawn along text by DrawString,
+// DrawStringAnchored, DrawStringWrapped and DrawStringTruncated in the
+// horizontal writing mode. The position and thickness of the lines come
+// from the post and OS/2 tables of the current font face when it has them,
+// and the lines are transformed by the current matrix like the text.
+func (dc *Context) SetTextDecoration(decoration TextDecoration) {
+	dc.textDecoration = decoration
+}
+
+// SetTextDecorationColor sets the color of the lines set with
+// SetTextDecoration. Use nil to paint them like the text, with the fill
+// pattern.
+func (dc *Context) SetTextDecorationColor(c color.Color) {
+	dc.textDecorColor = c
+}
+
+// SetTextDecorationSkipInk sets whether underlines and overlines are broken
+// where they would cross the glyphs, such as around descenders.
+func (dc *Context) SetTextDecorationSkipInk(skip bool) {
+	dc.skipInk = skip
+}
+
+// underlineMetrics returns the distance from the baseline down to the top
+// of the underline of a face and its thickness.
+func underlineMetrics(face font.Face) (position, thickness float64) {
+	switch f := face.(type) {
+	case *shapedFace:
+		scale := f.size / float64(f.ot.Upem())
+		position = -float64(f.ot.LineMetric(otfont.UnderlinePosition)) * scale
+		thickness = float64(f.ot.LineMetric(otfont.UnderlineThickness)) * scale
+	case *sfntFace:
+		if post := f.font.PostTable(); post != nil {
+			scale := unfix(f.ppem) / float64(f.font.UnitsPerEm())
+			position = -float64(post.UnderlinePosition) * scale
+			thickness = float64(post.UnderlineThickness) * scale
+		}
+	case *bitmapFace:
+		position = float64(f.underlinePos)
+		thickness = float64(f.underlineThick)
+	}
+	if thickness > 0 {
+		return position, thickness
+	}
+	m := face.Metrics()
+	return float64(m.Descent) / 64 / 2, float64(m.Height) / 64 / 16
+}
+
+// strikethroughMetrics returns the distance from the baseline down to the
+// top of the strikethrough of a face, which is negative, and its thickness.
+func strikethroughMetrics(face font.Face) (position, thickness float64) {
+	if f, ok := face.(*shapedFace); ok {
+		scale := f.size / float64(f.ot.Upem())
+		position = -float64(f.ot.LineMetric(otfont.StrikethroughPosition)) * scale
+		thickness = float64(f.ot.LineMetric(otfont.StrikethroughThickness)) * scale
+		if thickness > 0 {
+			return position, thickness
+		}
+	}
+	// center the line on half the x-height
+	_, thickness = underlineMetrics(face)
+	m := face.Metrics()
+	xHeight := unfix(m.XHeight)
+	if xHeight <= 0 {
+		xHeight = unfix(m.Ascent) * 2 / 3
+	}
+	return -xHeight/2 - thickness/2, thickness
+}
+
+// decorationMetrics returns the distance from the baseline down to the top
+// of a decoration line of a face and its thickness.
+func decorationMetrics(face font.Face, decoration TextDecoration) (position, thickness float64) {
+	switch decoration {
+	case TextDecorationStrikethrough:
+		return strikethroughMetrics(face)
+	case TextDecorationOverline:
+		_, thickness = underlineMetrics(face)
+		return -unfix(face.Metrics().Ascent), thickness
+	}
+	return underlineMetrics(face)
+}
+
+// drawDecorations draws the decorations of a line of glyphs, with its
+// baseline starting at x, y, that are among those given.
+func (dc *Context) drawDecorations(im *image.RGBA, mask *image.Alpha, glyphs []glyph, x, y float64, decorations TextDecoration) {
+	decorations &= dc.textDecoration
+	if decorations == 0 || len(glyphs) == 0 {
+		return
+	}
+	x0, x1 := math.Inf(1), math.Inf(-1)
+	for _, g := range glyphs {
+		x0 = math.Min(x0, unfix(g.dot.X))
+		x1 = math.Max(x1, unfix(g.dot.X+g.advance))
+	}
+	pattern := dc.fillPattern
+	if dc.textDecorColor != nil {
+		pattern = NewSolidPattern(dc.textDecorColor)
+	}
+	for _, d := range []TextDecoration{TextDecorationUnderline, TextDecorationOverline, TextDecorationStrikethrough} {
+		if decorations&d == 0 {
+			continue
+		}
+		position, thickness := decorationMetrics(dc.fontFace, d)
+		segments := [][2]float64{{x0, x1}}
+		if dc.skipInk && d != TextDecorationStrikethrough {
+			segments = skipInk(segments[0], inkGaps(glyphs, position, position+thickness, thickness))
+		}
+		for _, s := range segments {
+			dc.fillRect(im, mask, x+s[0], y+position, s[1]-s[0], thickness, pattern)
+		}
+	}
+}
+
+// inkGaps returns the horizontal ranges, relative to the start of the line,
+// where the ink of glyphs crosses the band between the distances top and
+// bottom below the baseline. The band and the ranges are widened by pad.
+func inkGaps(glyphs []glyph, top, bottom, pad float64) [][2]float64 {
+	var gaps [][2]float64
+	y0 := int(math.Floor(top - pad))
+	y1 := int(math.Ceil(bottom + pad))
+	for _, g := range glyphs {
+		dr, mask, maskp, ok := g.mask(g.dot)
+		if !ok {
+			continue
+		}
+		// only the rows of the mask in the band are scanned
+		r := image.Rect(dr.Min.X, y0, dr.Max.X, y1).Intersect(dr)
+		start := -1
+		for x := r.Min.X; x < r.Max.X; x++ {
+			inked := false
+			for y := r.Min.Y; y < r.Max.Y && !inked; y++ {
+				p := maskp.Add(image.Pt(x, y).Sub(dr.Min))
+				_, _, _, a := mask.At(p.X, p.Y).RGBA()
+				inked = a != 0
+			}
+			if inked && start < 0 {
+				start = x
+			} else if !inked && start >= 0 {
+				gaps = append(gaps, [2]float64{float64(start) - pad, float64(x) + pad})
+				start = -1
+			}
+		}
+		if start >= 0 {
+			gaps = append(gaps, [2]float64{float64(start) - pad, float64(r.Max.X) + pad})
+		}
+	}
+	return gaps
+}
+
+// skipInk returns the parts of the segment that are outside the gaps.
+func skipInk(segment [2]float64, gaps [][2]float64) [][2]float64 {
+	sort.Slice(gaps, func(i, j int) bool { return gaps[i][0] < gaps[j][0] })
+	var segments [][2]float64
+	x := segment[0]
+	for _, gap := range gaps {
+		if gap[0] > x {
+			segments = append(segments, [2]float64{x, math.Min(gap[0], segment[1])})
+		}
+		x = math.Max(x, gap[1])
+		if x >= segment[1] {
+			return segments
+		}
+	}
+	return append(segments, [2]float64{x, segment[1]})
+}
@@ -14,14 +14,6 @@ const (
 func main() {
 	dc := gg.NewContext(W, H)
 
-	// draw text
-	dc.SetRGB(0, 0, 0)
-	dc.LoadFontFace("/Library/Fonts/Impact.ttf", 128)
-	dc.DrawStringAnchored("Gradient Text", W/2, H/2, 0.5, 0.5)
-
-	// get the context as an alpha mask
-	mask := dc.AsMask()
-
 	// clear the context
 	dc.SetRGB(1, 1, 1)
 	dc.Clear()
@@ -32,10 +24,14 @@ func main() {
 	g.AddColorStop(1, color.RGBA{0, 0, 255, 255})
 	dc.SetFillStyle(g)
 
-	// using the mask, fill the context with the gradient
-	dc.SetMask(mask)
-	dc.DrawRectangle(0, 0, W, H)
-	dc.Fill()
+	// draw text, which is filled with the gradient
+	dc.LoadFontFace("/Library/Fonts/Impact.ttf", 128)
+	dc.DrawStringAnchored("Gradient Text", W/2, H/2, 0.5, 0.5)
+
+	// outline it
+	dc.SetRGB(0, 0, 0)
+	dc.SetLineWidth(3)
+	dc.DrawStringStrokeAnchored("Gradient Text", W/2, H/2, 0.5, 0.5)
 
 	dc.SavePNG("out.png")
 }
@@ -0,0 +1,111 @@
+package gg
+
+import (
+	"unicode"
+
+	"github.com/goki/freetype/truetype"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// SetFontFallbacks sets the faces used for runes that the current font face
+// cannot render. For each rune the first face that has a glyph for it is
+// used, trying the current face first and then the fallbacks in order. Runes
+// that no face can render are replaced by U+FFFD REPLACEMENT CHARACTER. The
+// fallbacks are used by DrawString, MeasureString, WordWrap and
+// CreateStringPath. Call with zero arguments to remove all fallbacks.
+//
+// Faces loaded by gg know exactly which glyphs their font contains. Other
+// faces are asked through GlyphAdvance, which some faces, like those from
+// truetype.NewFace, answer for every rune.
+func (dc *Context) SetFontFallbacks(faces ...font.Face) {
+	dc.fontFallbacks = faces
+}
+
+// faces returns the current font face followed by the fallbacks.
+func (dc *Context) faces() []font.Face {
+	return append([]font.Face{dc.fontFace}, dc.fontFallbacks...)
+}
+
+// truetypeFace is a face created from a truetype.Font by SetFontSize. It
+// keeps the font so that missing glyphs can be detected and glyph outlines
+// loaded.
+type truetypeFace struct {
+	font.Face
+	font  *truetype.Font
+	scale fixed.Int26_6
+}
+
+// hasGlyph reports whether face has a glyph for r.
+func hasGlyph(face font.Face, r rune) bool {
+	switch f := face.(type) {
+	case *shapedFace:
+		_, ok := f.ot.NominalGlyph(r)
+		return ok
+	case *truetypeFace:
+		return f.font.Index(r) != 0
+	case *sfntFace:
+		return f.index(r) != 0
+	}
+	_, ok := face.GlyphAdvance(r)
+	return ok
+}
+
+// ignorable reports whether r is laid out together with the rune before it
+// and is never replaced, even if no face has a glyph for it: marks,
+// variation selectors and invisible format and control characters.
+func ignorable(r rune) bool {
+	return unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf, unicode.Cc, unicode.Variation_Selector)
+}
+
+// replaceMissing replaces the runes of text that none of the faces can
+// render with U+FFFD.
+func replaceMissing(faces []font.Face, text []rune) {
+	for i, r := range text {
+		if !ignorable(r) && findFace(faces, r) < 0 {
+			text[i] = '\ufffd'
+		}
+	}
+}
+
+// findFace returns the index of the first face that has a glyph for r, or
+// -1 if there is none.
+func findFace(faces []font.Face, r rune) int {
+	for i, face := range faces {
+		if hasGlyph(face, r) {
+			return i
+		}
+	}
+	return -1
+}
+
+// faceRun is a range of runes, in logical order, that are laid out with a
+// single face, given by its index in the list of faces.
+type faceRun struct {
+	start, end int
+	face       int
+}
+
+// splitByFace splits a bidi run into runs that use a single face, returned
+// in visual order.
+func splitByFace(faces []font.Face, text []rune, run bidiRun) []faceRun {
+	var runs []faceRun
+	for i := run.start; i < run.end; i++ {
+		face := 0
+		if len(runs) > 0 && ignorable(text[i]) {
+			face = runs[len(runs)-1].face
+		} else if j := findFace(faces, text[i]); j >= 0 {
+			face = j
+		}
+		if len(runs) == 0 || runs[len(runs)-1].face != face {
+			runs = append(runs, faceRun{start: i, face: face})
+		}
+		runs[len(runs)-1].end = i + 1
+	}
+	if run.rtl() {
+		for i, j := 0, len(runs)-1; i < j; i, j = i+1, j-1 {
+			runs[i], runs[j] = runs[j], runs[i]
+		}
+	}
+	return runs
+}
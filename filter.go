@@ -0,0 +1,348 @@
+package gg
+
+import (
+	"image"
+	"math"
+)
+
+// Filter is an image processing operation. Apply modifies the pixels of im
+// that lie within r in place.
+type Filter interface {
+	Apply(im *image.RGBA, r image.Rectangle)
+}
+
+// ApplyFilter runs the filters, in order, on the whole image. The clipping
+// mask is ignored.
+func (dc *Context) ApplyFilter(filters ...Filter) {
+	dc.ApplyFilterRect(dc.im.Bounds(), filters...)
+}
+
+// ApplyFilterRect runs the filters, in order, on the pixels of the image
+// within r. Pixels outside of r are neither read nor modified. The clipping
+// mask is ignored.
+func (dc *Context) ApplyFilterRect(r image.Rectangle, filters ...Filter) {
+	r = r.Intersect(dc.im.Bounds())
+	if r.Empty() {
+		return
+	}
+	for _, f := range filters {
+		f.Apply(dc.im, r)
+	}
+}
+
+// SetFilter sets the filters that are applied to subsequent fills, strokes,
+// text and images, like the filter property of an HTML canvas. Each
+// operation is drawn onto a transparent layer, the filters are run on that
+// layer and the result is composited onto the image. The layer covers what
+// the operation draws and the pixels the filters of this package spread it
+// to; with other filters it covers the whole image. Call with zero
+// arguments to disable filtering.
+func (dc *Context) SetFilter(filters ...Filter) {
+	dc.filters = filters
+}
+
+// marginFilter is implemented by the filters whose result at a pixel only
+// depends on the pixels up to a margin away from it. ok is false when
+// transparent pixels can be changed wherever they are.
+type marginFilter interface {
+	margin() (margin int, ok bool)
+}
+
+// filtersMargin returns how far the filters, run in order, spread the
+// pixels they are given. ok is false when one of them is not known to
+// leave the transparent pixels far from any other unchanged.
+func filtersMargin(filters []Filter) (margin int, ok bool) {
+	for _, f := range filters {
+		mf, known := f.(marginFilter)
+		if !known {
+			return 0, false
+		}
+		m, ok := mf.margin()
+		if !ok {
+			return 0, false
+		}
+		margin += m
+	}
+	return margin, true
+}
+
+// Blur Filters
+
+type gaussianBlurFilter struct {
+	sigma float64
+}
+
+// NewGaussianBlurFilter returns a filter that blurs with a Gaussian of the
+// given standard deviation, in pixels. It is approximated with three box
+// blurs so its cost does not depend on sigma. Pixels beyond the edges of the
+// filtered rectangle are treated as transparent.
+func NewGaussianBlurFilter(sigma float64) Filter {
+	return &gaussianBlurFilter{sigma}
+}
+
+func (f *gaussianBlurFilter) margin() (int, bool) {
+	return gaussianReach(f.sigma), true
+}
+
+func (f *gaussianBlurFilter) Apply(im *image.RGBA, r image.Rectangle) {
+	r = r.Intersect(im.Bounds())
+	if r.Empty() {
+		return
+	}
+	pix := im.Pix[im.PixOffset(r.Min.X, r.Min.Y):]
+	gaussianBlur(pix, r.Dx(), r.Dy(), im.Stride, 4, f.sigma)
+}
+
+type boxBlurFilter struct {
+	radius int
+}
+
+// NewBoxBlurFilter returns a filter that replaces each pixel with the mean of
+// the (2*radius+1) x (2*radius+1) pixels around it. Pixels beyond the edges
+// of the filtered rectangle are treated as transparent.
+func NewBoxBlurFilter(radius int) Filter {
+	return &boxBlurFilter{radius}
+}
+
+func (f *boxBlurFilter) margin() (int, bool) {
+	if f.radius < 0 {
+		return 0, true
+	}
+	return f.radius, true
+}
+
+func (f *boxBlurFilter) Apply(im *image.RGBA, r image.Rectangle) {
+	r = r.Intersect(im.Bounds())
+	if r.Empty() || f.radius <= 0 {
+		return
+	}
+	pix := im.Pix[im.PixOffset(r.Min.X, r.Min.Y):]
+	tmp := make([]uint8, len(pix))
+	boxBlur(pix, tmp, r.Dx(), r.Dy(), im.Stride, 4, f.radius)
+}
+
+// Convolution Filter
+
+type convolutionFilter struct {
+	w, h   int
+	kernel []float64
+}
+
+// NewConvolutionFilter returns a filter that convolves the image with a w x h
+// kernel, given in row-major order. w and h should be odd so that the kernel
+// is centered on each pixel. The kernel is not normalized. Like the blur
+// filters, pixels beyond the edges of the filtered rectangle are treated as
+// transparent.
+func NewConvolutionFilter(w, h int, kernel []float64) Filter {
+	return &convolutionFilter{w, h, kernel}
+}
+
+func (f *convolutionFilter) margin() (int, bool) {
+	if f.w > f.h {
+		return f.w / 2, true
+	}
+	return f.h / 2, true
+}
+
+func (f *convolutionFilter) Apply(im *image.RGBA, r image.Rectangle) {
+	r = r.Intersect(im.Bounds())
+	if r.Empty() || len(f.kernel) < f.w*f.h {
+		return
+	}
+	src := image.NewRGBA(r)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		i := im.PixOffset(r.Min.X, y)
+		copy(src.Pix[src.PixOffset(r.Min.X, y):], im.Pix[i:i+4*r.Dx()])
+	}
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			var sum [4]float64
+			for ky := 0; ky < f.h; ky++ {
+				sy := y + ky - f.h/2
+				if sy < r.Min.Y || sy >= r.Max.Y {
+					continue
+				}
+				for kx := 0; kx < f.w; kx++ {
+					k := f.kernel[ky*f.w+kx]
+					sx := x + kx - f.w/2
+					if k == 0 || sx < r.Min.X || sx >= r.Max.X {
+						continue
+					}
+					i := src.PixOffset(sx, sy)
+					for c := range sum {
+						sum[c] += k * float64(src.Pix[i+c])
+					}
+				}
+			}
+			// keep the result a valid premultiplied color
+			i := im.PixOffset(x, y)
+			a := clampUint8(sum[3])
+			for c := 0; c < 3; c++ {
+				v := clampUint8(sum[c])
+				if v > a {
+					v = a
+				}
+				im.Pix[i+c] = v
+			}
+			im.Pix[i+3] = a
+		}
+	}
+}
+
+func clampUint8(x float64) uint8 {
+	if x <= 0 {
+		return 0
+	}
+	if x >= 255 {
+		return 255
+	}
+	return uint8(x + 0.5)
+}
+
+// Color Matrix Filters
+
+type colorMatrixFilter struct {
+	m [20]float64
+}
+
+// NewColorMatrixFilter returns a filter that transforms the color of each
+// pixel with a 4x5 matrix in row-major order, like the SVG feColorMatrix
+// element. The matrix is applied to non-premultiplied [R G B A 1] column
+// vectors with components in the range 0-1.
+func NewColorMatrixFilter(m [20]float64) Filter {
+	return &colorMatrixFilter{m}
+}
+
+// margin reports whether transparent pixels stay transparent, which they do
+// unless the matrix adds to the alpha of a transparent pixel.
+func (f *colorMatrixFilter) margin() (int, bool) {
+	return 0, f.m[19] <= 0
+}
+
+func (f *colorMatrixFilter) Apply(im *image.RGBA, r image.Rectangle) {
+	r = r.Intersect(im.Bounds())
+	m := &f.m
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		i := im.PixOffset(r.Min.X, y)
+		for x := r.Min.X; x < r.Max.X; x, i = x+1, i+4 {
+			p := im.Pix[i : i+4 : i+4]
+			var c [4]float64
+			if p[3] != 0 {
+				a := float64(p[3])
+				c = [4]float64{float64(p[0]) / a, float64(p[1]) / a, float64(p[2]) / a, a / 255}
+			}
+			var out [4]float64
+			for j := range out {
+				v := m[j*5]*c[0] + m[j*5+1]*c[1] + m[j*5+2]*c[2] + m[j*5+3]*c[3] + m[j*5+4]
+				out[j] = math.Max(0, math.Min(1, v))
+			}
+			a := out[3] * 255
+			p[0] = clampUint8(out[0] * a)
+			p[1] = clampUint8(out[1] * a)
+			p[2] = clampUint8(out[2] * a)
+			p[3] = clampUint8(a)
+		}
+	}
+}
+
+// NewGrayscaleFilter returns a filter that converts the image to grayscale.
+// amount ranges from 0 (unchanged) to 1 (completely gray).
+func NewGrayscaleFilter(amount float64) Filter {
+	a := 1 - math.Max(0, math.Min(1, amount))
+	return NewColorMatrixFilter([20]float64{
+		0.2126 + 0.7874*a, 0.7152 - 0.7152*a, 0.0722 - 0.0722*a, 0, 0,
+		0.2126 - 0.2126*a, 0.7152 + 0.2848*a, 0.0722 - 0.0722*a, 0, 0,
+		0.2126 - 0.2126*a, 0.7152 - 0.7152*a, 0.0722 + 0.9278*a, 0, 0,
+		0, 0, 0, 1, 0,
+	})
+}
+
+// NewSepiaFilter returns a filter that converts the image to sepia. amount
+// ranges from 0 (unchanged) to 1 (completely sepia).
+func NewSepiaFilter(amount float64) Filter {
+	a := 1 - math.Max(0, math.Min(1, amount))
+	return NewColorMatrixFilter([20]float64{
+		0.393 + 0.607*a, 0.769 - 0.769*a, 0.189 - 0.189*a, 0, 0,
+		0.349 - 0.349*a, 0.686 + 0.314*a, 0.168 - 0.168*a, 0, 0,
+		0.272 - 0.272*a, 0.534 - 0.534*a, 0.131 + 0.869*a, 0, 0,
+		0, 0, 0, 1, 0,
+	})
+}
+
+// NewSaturateFilter returns a filter that saturates the image. 0 is
+// completely unsaturated, 1 leaves the image unchanged and values above 1
+// over-saturate it.
+func NewSaturateFilter(amount float64) Filter {
+	s := amount
+	return NewColorMatrixFilter([20]float64{
+		0.213 + 0.787*s, 0.715 - 0.715*s, 0.072 - 0.072*s, 0, 0,
+		0.213 - 0.213*s, 0.715 + 0.285*s, 0.072 - 0.072*s, 0, 0,
+		0.213 - 0.213*s, 0.715 - 0.715*s, 0.072 + 0.928*s, 0, 0,
+		0, 0, 0, 1, 0,
+	})
+}
+
+// NewBrightnessFilter returns a filter that multiplies the color components
+// by amount. 0 makes the image black and 1 leaves it unchanged.
+func NewBrightnessFilter(amount float64) Filter {
+	b := amount
+	return NewColorMatrixFilter([20]float64{
+		b, 0, 0, 0, 0,
+		0, b, 0, 0, 0,
+		0, 0, b, 0, 0,
+		0, 0, 0, 1, 0,
+	})
+}
+
+// NewContrastFilter returns a filter that adjusts the contrast of the image.
+// 0 makes the image completely gray and 1 leaves it unchanged.
+func NewContrastFilter(amount float64) Filter {
+	c := amount
+	o := 0.5 - 0.5*c
+	return NewColorMatrixFilter([20]float64{
+		c, 0, 0, 0, o,
+		0, c, 0, 0, o,
+		0, 0, c, 0, o,
+		0, 0, 0, 1, 0,
+	})
+}
+
+// NewHueRotateFilter returns a filter that rotates the hue of the image by
+// the given angle, in radians.
+func NewHueRotateFilter(angle float64) Filter {
+	c := math.Cos(angle)
+	s := math.Sin(angle)
+	return NewColorMatrixFilter([20]float64{
+		0.213 + c*0.787 - s*0.213, 0.715 - c*0.715 - s*0.715, 0.072 - c*0.072 + s*0.928, 0, 0,
+		0.213 - c*0.213 + s*0.143, 0.715 + c*0.285 + s*0.140, 0.072 - c*0.072 - s*0.283, 0, 0,
+		0.213 - c*0.213 - s*0.787, 0.715 - c*0.715 + s*0.715, 0.072 + c*0.928 + s*0.072, 0, 0,
+		0, 0, 0, 1, 0,
+	})
+}
+
+// NewOpacityFilter returns a filter that multiplies the alpha of the image by
+// amount, which ranges from 0 (transparent) to 1 (unchanged).
+func NewOpacityFilter(amount float64) Filter {
+	return &opacityFilter{math.Max(0, math.Min(1, amount))}
+}
+
+type opacityFilter struct {
+	amount float64
+}
+
+func (f *opacityFilter) margin() (int, bool) {
+	return 0, true
+}
+
+func (f *opacityFilter) Apply(im *image.RGBA, r image.Rectangle) {
+	r = r.Intersect(im.Bounds())
+	k := uint32(f.amount*255 + 0.5)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		i := im.PixOffset(r.Min.X, y)
+		p := im.Pix[i : i+r.Dx()*4]
+		for j := range p {
+			// premultiplied, so every channel scales with alpha
+			p[j] = uint8((uint32(p[j])*k + 127) / 255)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package gg
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	otfont "github.com/go-text/typesetting/font"
+	"github.com/go-text/typesetting/font/opentype"
+	"golang.org/x/image/font/sfnt"
+)
+
+// FontInfo describes a font of a font file or collection.
+type FontInfo struct {
+	// Path is the file the font was read from, if any.
+	Path string
+
+	// Index is the index of the font in its collection, or 0 for a single
+	// font.
+	Index int
+
+	// Family and Subfamily are the names of the font, such as "Noto Sans"
+	// and "Bold Italic".
+	Family    string
+	Subfamily string
+
+	// Weight is the weight of the font, from 100 to 900. Regular fonts have
+	// a weight of 400 and bold fonts a weight of 700.
+	Weight int
+
+	// Italic is true for italic and oblique fonts.
+	Italic bool
+}
+
+// FontInfos describes the fonts of TrueType or OpenType font data, which may
+// be a font collection.
+func FontInfos(data []byte) ([]FontInfo, error) {
+	loaders, err := opentype.NewLoaders(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	c, err := sfnt.ParseCollection(data)
+	if err != nil {
+		return nil, err
+	}
+	var buf sfnt.Buffer
+	var infos []FontInfo
+	for i, ld := range loaders {
+		desc, _ := otfont.Describe(ld, nil)
+		info := FontInfo{
+			Index:  i,
+			Family: desc.Family,
+			Weight: int(desc.Aspect.Weight),
+			Italic: desc.Aspect.Style == otfont.StyleItalic,
+		}
+		if f, err := c.Font(i); err == nil {
+			info.Subfamily, err = f.Name(&buf, sfnt.NameIDTypographicSubfamily)
+			if err != nil || info.Subfamily == "" {
+				info.Subfamily, _ = f.Name(&buf, sfnt.NameIDSubfamily)
+			}
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// LoadFontNamed loads the font of the given family and subfamily from a font
+// file, which is usually a font collection. Names are compared without regard
+// to case. An empty subfamily selects the first font of the family.
+func (dc *Context) LoadFontNamed(path, family, subfamily string) error {
+	fontBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	infos, err := FontInfos(fontBytes)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if strings.EqualFold(info.Family, family) &&
+			(subfamily == "" || strings.EqualFold(info.Subfamily, subfamily)) {
+			return dc.LoadFontDataIndex(fontBytes, info.Index)
+		}
+	}
+	return fmt.Errorf("gg: no font %q %q in %s", family, subfamily, path)
+}
+
+// FontRegistry is a set of font files that fonts can be selected from by
+// family, weight and style with Context.SetFontFamily. It is safe for
+// concurrent use.
+type FontRegistry struct {
+	mu    sync.Mutex
+	fonts []FontInfo
+	data  map[string][]byte
+}
+
+// DefaultFontRegistry is the registry used by Context.SetFontFamily until
+// another one is set with Context.SetFontRegistry.
+var DefaultFontRegistry = NewFontRegistry()
+
+// NewFontRegistry returns an empty font registry.
+func NewFontRegistry() *FontRegistry {
+	return &FontRegistry{data: map[string][]byte{}}
+}
+
+// AddFile registers the fonts of a TrueType or OpenType font file or
+// collection.
+func (r *FontRegistry) AddFile(path string) error {
+	fontBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	infos, err := FontInfos(fontBytes)
+	if err != nil {
+		return fmt.Errorf("gg: %s: %v", path, err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, info := range infos {
+		info.Path = path
+		r.fonts = append(r.fonts, info)
+	}
+	return nil
+}
+
+// AddDir registers the fonts of all .ttf, .otf, .ttc and .otc files in a
+// directory and its subdirectories. Files that cannot be parsed are skipped.
+func (r *FontRegistry) AddDir(dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".ttf", ".otf", ".ttc", ".otc":
+			r.AddFile(path)
+		}
+		return nil
+	})
+}
+
+// Fonts returns the registered fonts.
+func (r *FontRegistry) Fonts() []FontInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]FontInfo(nil), r.fonts...)
+}
+
+// Match returns the registered font of the family that best matches the
+// weight and style, following the font matching rules of CSS: a font of the
+// requested style is preferred, and among those the font closest in weight,
+// looking first at lighter fonts for weights below 400 and at bolder fonts
+// for weights above 500. ok is false if no font of the family is registered.
+func (r *FontRegistry) Match(family string, weight int, italic bool) (info FontInfo, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	best := 0
+	for _, f := range r.fonts {
+		if !strings.EqualFold(f.Family, family) {
+			continue
+		}
+		score := weightScore(weight, f.Weight)
+		if f.Italic == italic {
+			score += 10000
+		}
+		if !ok || score > best {
+			info, best, ok = f, score, true
+		}
+	}
+	return info, ok
+}
+
+// weightScore rates how well a font weight matches a requested weight, the
+// higher the better, in the order of the CSS font matching algorithm.
+func weightScore(want, have int) int {
+	d := have - want
+	if d < 0 {
+		d = -d
+	}
+	switch {
+	case have == want:
+		return 5000
+	case want >= 400 && want <= 500 && have > want && have <= 500:
+		// 400 and 500 look at the weights up to 500 first
+		return 4000 - d
+	case want <= 500 && have < want, want > 500 && have > want:
+		return 3000 - d
+	}
+	return 2000 - d
+}
+
+// fontData returns the data of a registered font file.
+func (r *FontRegistry) fontData(path string) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if data, ok := r.data[path]; ok {
+		return data, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	r.data[path] = data
+	return data, nil
+}
+
+// SetFontRegistry sets the registry that SetFontFamily selects fonts from.
+// Use nil for DefaultFontRegistry.
+func (dc *Context) SetFontRegistry(r *FontRegistry) {
+	dc.fontRegistry = r
+}
+
+// SetFontFamily loads the font of the family that best matches the weight
+// and style from the font registry, and keeps the current font size.
+func (dc *Context) SetFontFamily(family string, weight int, italic bool) error {
+	r := dc.fontRegistry
+	if r == nil {
+		r = DefaultFontRegistry
+	}
+	info, ok := r.Match(family, weight, italic)
+	if !ok {
+		return fmt.Errorf("gg: no font of family %q", family)
+	}
+	data, err := r.fontData(info.Path)
+	if err != nil {
+		return err
+	}
+	if err := dc.LoadFontDataIndex(data, info.Index); err != nil {
+		return err
+	}
+	dc.SetFontSize(dc.fontSize)
+	return nil
+}
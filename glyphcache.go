@@ -0,0 +1,203 @@
+package gg
+
+import (
+	"container/list"
+	"image"
+	"reflect"
+	"sync"
+
+	otfont "github.com/go-text/typesetting/font"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// glyphCacheSize is the number of glyph masks kept by the glyph cache.
+const glyphCacheSize = 4096
+
+// measureCacheSize is the number of line lengths a context keeps before it
+// starts measuring again from scratch.
+const measureCacheSize = 1024
+
+// glyphKey identifies a glyph mask. Faces have a single size, so the face
+// stands for the font and the size. Masks are rendered in text space and do
+// not depend on the transform; only the threshold of aliased text, which is
+// zero for antialiased text, changes them. The subpixel offset is quantized
+// by glyphMask, so each glyph has at most four masks.
+type glyphKey struct {
+	face      font.Face
+	id        otfont.GID
+	r         rune
+	offset    fixed.Point26_6 // subpixel offset of the origin of the glyph
+	threshold uint32
+}
+
+// cachedGlyph is the coverage mask of a glyph, with dr relative to the
+// whole pixel part of the origin of the glyph. mask is nil for glyphs
+// without ink.
+type cachedGlyph struct {
+	key  glyphKey
+	dr   image.Rectangle
+	mask *image.Alpha
+}
+
+// glyphCache keeps the most recently used glyph masks. It is shared by all
+// contexts.
+type glyphCache struct {
+	mu      sync.Mutex
+	entries map[glyphKey]*list.Element
+	lru     list.List
+}
+
+var glyphMasks = &glyphCache{entries: map[glyphKey]*list.Element{}}
+
+// get returns the cached mask for key, or renders it with render and adds
+// it to the cache.
+func (c *glyphCache) get(key glyphKey, render func() cachedGlyph) cachedGlyph {
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(e)
+		c.mu.Unlock()
+		return e.Value.(cachedGlyph)
+	}
+	c.mu.Unlock()
+
+	g := render()
+	g.key = key
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok {
+		c.entries[key] = c.lru.PushFront(g)
+		if c.lru.Len() > glyphCacheSize {
+			last := c.lru.Back()
+			c.lru.Remove(last)
+			delete(c.entries, last.Value.(cachedGlyph).key)
+		}
+	}
+	return g
+}
+
+// comparableFace reports whether face can be used as a map key. Faces are
+// usually pointers, but a face type may hold slices or maps.
+func comparableFace(face font.Face) bool {
+	return reflect.TypeOf(face).Comparable()
+}
+
+// glyphMask returns the coverage mask of g with its origin at dot, aliased
+// as set with SetAntialias, and where to draw it. The origin is rounded to a
+// quarter of a pixel horizontally and to a whole pixel vertically, like
+// truetype faces do, and the masks are kept in the glyph cache by subpixel
+// offset, so that glyphs drawn again at the same offset are not rendered
+// again.
+func (dc *Context) glyphMask(g glyph, dot fixed.Point26_6) (image.Rectangle, *image.Alpha, bool) {
+	if _, ok := g.face.(*bitmapFace); ok {
+		// bitmap glyphs are drawn at whole pixels
+		dot = fixed.Point26_6{X: fixed.I(dot.X.Round()), Y: fixed.I(dot.Y.Round())}
+	} else {
+		dot = fixed.Point26_6{X: (dot.X + 8) &^ 15, Y: fixed.I(dot.Y.Round())}
+	}
+	whole := fixed.Point26_6{X: dot.X &^ 63, Y: dot.Y &^ 63}
+	key := glyphKey{face: g.face, id: g.id, r: g.r, offset: dot.Sub(whole)}
+	if dc.antialias == AntialiasNone {
+		key.threshold = dc.threshold()
+	}
+	render := func() cachedGlyph {
+		dr, mask, maskp, ok := g.mask(key.offset)
+		if !ok {
+			return cachedGlyph{}
+		}
+		// copy the mask, which the face may reuse
+		sr := image.Rect(0, 0, dr.Dx(), dr.Dy())
+		var alpha *image.Alpha
+		if key.threshold != 0 {
+			alpha = dc.aliasedMask(mask, sr.Add(maskp))
+			alpha.Rect = sr
+		} else {
+			alpha = image.NewAlpha(sr)
+			draw.Draw(alpha, sr, mask, maskp, draw.Src)
+		}
+		return cachedGlyph{dr: dr, mask: alpha}
+	}
+	var c cachedGlyph
+	if comparableFace(g.face) {
+		c = glyphMasks.get(key, render)
+	} else {
+		c = render()
+	}
+	if c.mask == nil {
+		return image.Rectangle{}, nil, false
+	}
+	return c.dr.Add(image.Pt(int(whole.X>>6), int(whole.Y>>6))), c.mask, true
+}
+
+// measureCache keeps the lengths of lines of text measured by a context,
+// together with the settings they were measured with. The lengths are
+// forgotten when the settings change.
+type measureCache struct {
+	faces                     []font.Face
+	direction                 TextDirection
+	mode                      WritingMode
+	letter, word, tabInterval float64
+	tabStops                  []float64
+	lengths                   map[string]float64
+}
+
+// valid reports whether the lengths of c were measured with the current
+// settings of dc.
+func (c *measureCache) valid(dc *Context, faces []font.Face) bool {
+	if c.lengths == nil || len(c.faces) != len(faces) || len(c.tabStops) != len(dc.tabStops) ||
+		c.direction != dc.textDirection || c.mode != dc.writingMode ||
+		c.letter != dc.letterSpacing || c.word != dc.wordSpacing || c.tabInterval != dc.tabInterval {
+		return false
+	}
+	for i := range faces {
+		if c.faces[i] != faces[i] {
+			return false
+		}
+	}
+	for i := range dc.tabStops {
+		if c.tabStops[i] != dc.tabStops[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// reset forgets the lengths of c and records the current settings of dc.
+func (c *measureCache) reset(dc *Context, faces []font.Face) {
+	*c = measureCache{
+		faces:       faces,
+		direction:   dc.textDirection,
+		mode:        dc.writingMode,
+		letter:      dc.letterSpacing,
+		word:        dc.wordSpacing,
+		tabInterval: dc.tabInterval,
+		tabStops:    dc.tabStops,
+		lengths:     map[string]float64{},
+	}
+}
+
+// cachedLength returns the length of the line s measured by measure, which
+// is kept until the text settings of dc change. Lines laid out with faces
+// that cannot be compared are always measured.
+func (dc *Context) cachedLength(s string, measure func() float64) float64 {
+	faces := dc.faces()
+	for _, face := range faces {
+		if !comparableFace(face) {
+			return measure()
+		}
+	}
+	if dc.measures == nil {
+		dc.measures = &measureCache{}
+	}
+	c := dc.measures
+	if !c.valid(dc, faces) || len(c.lengths) >= measureCacheSize {
+		c.reset(dc, faces)
+	}
+	if length, ok := c.lengths[s]; ok {
+		return length
+	}
+	length := measure()
+	c.lengths[s] = length
+	return length
+}
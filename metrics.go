@@ -0,0 +1,90 @@
+package gg
+
+import "golang.org/x/image/math/fixed"
+
+// TextMetrics describes the size of a line of text, like the result of
+// measureText in the HTML canvas API. Distances are in user space. Vertical
+// positions are relative to the baseline, growing downwards.
+type TextMetrics struct {
+	// Width is the advance width of the text.
+	Width float64
+
+	// Ascent, Descent and LineGap are the distances from the baseline up to
+	// the top of the font, from the baseline down to the bottom of the font
+	// and between lines, as given by the font. They are the same for all
+	// text drawn with the font.
+	Ascent  float64
+	Descent float64
+	LineGap float64
+
+	// InkMin and InkMax are the corners of the bounding box of the ink of
+	// the text, relative to the start of the baseline. Both are zero for
+	// text without ink, such as spaces.
+	InkMin Point
+	InkMax Point
+
+	// Glyphs are the glyphs of the text in visual order, from left to right.
+	Glyphs []GlyphMetrics
+}
+
+// GlyphMetrics describes the placement of a glyph in a line of text.
+type GlyphMetrics struct {
+	// Index is the byte offset in the text of the first character the
+	// glyph was made from. Several glyphs share an index when a character
+	// is drawn as more than one glyph, and characters are skipped when
+	// several are drawn as one glyph, as in ligatures.
+	Index int
+
+	// X and Y are the position of the origin of the glyph relative to the
+	// start of the baseline.
+	X, Y float64
+
+	// Advance is the distance from the origin of the glyph to the origin of
+	// the next glyph.
+	Advance float64
+}
+
+// MeasureText returns the metrics of the specified text laid out on a single
+// line with the current font face, its fallbacks, the text direction and the
+// text spacing.
+func (dc *Context) MeasureText(s string) TextMetrics {
+	var offsets []int // byte offset of each rune
+	for i := range s {
+		offsets = append(offsets, i)
+	}
+	glyphs, advance := dc.layoutString(dc.faces(), s)
+
+	m := dc.fontFace.Metrics()
+	tm := TextMetrics{
+		Width:   unfix(advance),
+		Ascent:  unfix(m.Ascent),
+		Descent: unfix(m.Descent),
+	}
+	if gap := m.Height - m.Ascent - m.Descent; gap > 0 {
+		tm.LineGap = unfix(gap)
+	}
+
+	var ink fixed.Rectangle26_6
+	hasInk := false
+	for _, g := range glyphs {
+		tm.Glyphs = append(tm.Glyphs, GlyphMetrics{
+			Index:   offsets[g.cluster],
+			X:       unfix(g.dot.X),
+			Y:       unfix(g.dot.Y),
+			Advance: unfix(g.advance),
+		})
+		b, ok := g.bounds()
+		if !ok {
+			continue
+		}
+		b = b.Add(g.dot)
+		if hasInk {
+			ink = ink.Union(b)
+		} else {
+			ink, hasInk = b, true
+		}
+	}
+	tm.InkMin = Point{unfix(ink.Min.X), unfix(ink.Min.Y)}
+	tm.InkMax = Point{unfix(ink.Max.X), unfix(ink.Max.Y)}
+	return tm
+}
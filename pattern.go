@@ -99,9 +99,10 @@ func NewSurfacePattern(im image.Image, op RepeatOp) Pattern {
 }
 
 type patternPainter struct {
-	im   *image.RGBA
-	mask *image.Alpha
-	p    Pattern
+	im     *image.RGBA
+	mask   *image.Alpha
+	p      Pattern
+	origin image.Point
 }
 
 // Paint satisfies the Painter interface.
@@ -124,8 +125,10 @@ func (r *patternPainter) Paint(ss []raster.Span, done bool) {
 			continue
 		}
 		const m = 1<<16 - 1
-		y := s.Y - r.im.Rect.Min.Y
-		x0 := s.X0 - r.im.Rect.Min.X
+		// patterns and masks are relative to the image of the context,
+		// which im may be a layer of
+		y := s.Y - r.origin.Y
+		x0 := s.X0 - r.origin.X
 		// RGBAPainter.Paint() in $GOPATH/src/github.com/golang/freetype/raster/paint.go
 		i0 := (s.Y-r.im.Rect.Min.Y)*r.im.Stride + (s.X0-r.im.Rect.Min.X)*4
 		i1 := i0 + (s.X1-s.X0)*4
@@ -152,8 +155,8 @@ func (r *patternPainter) Paint(ss []raster.Span, done bool) {
 	}
 }
 
-func newPatternPainter(im *image.RGBA, mask *image.Alpha, p Pattern, m Matrix) *patternPainter {
-	return &patternPainter{im, mask, convertPattern(p, m)}
+func newPatternPainter(im *image.RGBA, mask *image.Alpha, p Pattern, m Matrix, origin image.Point) *patternPainter {
+	return &patternPainter{im, mask, convertPattern(p, m), origin}
 }
 
 type tranPattern struct {
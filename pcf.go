@@ -0,0 +1,361 @@
+package gg
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"strconv"
+
+	"golang.org/x/image/font"
+)
+
+// The PCF table types.
+const (
+	pcfProperties      = 1 << 0
+	pcfAccelerators    = 1 << 1
+	pcfMetrics         = 1 << 2
+	pcfBitmaps         = 1 << 3
+	pcfBDFEncodings    = 1 << 5
+	pcfBDFAccelerators = 1 << 8
+)
+
+// The bits of the format of PCF tables.
+const (
+	pcfGlyphPadMask      = 3 << 0
+	pcfByteMask          = 1 << 2 // most significant byte first
+	pcfBitMask           = 1 << 3 // most significant bit first
+	pcfScanUnitMask      = 3 << 4
+	pcfFormatMask        = 0xffffff00
+	pcfCompressedMetrics = 0x100
+)
+
+const (
+	// pcfNoGlyph is the glyph index of codes without a glyph.
+	pcfNoGlyph = 0xffff
+	// pcfPropertySize is the size of a property in the properties table.
+	pcfPropertySize = 9
+	// pcfCompressedBias is added to the values of compressed metrics.
+	pcfCompressedBias = 0x80
+)
+
+// LoadPCFFace loads a bitmap font face from a PCF (Portable Compiled
+// Format) file, which may be compressed with gzip, as the .pcf.gz files of
+// X11 are. Use SetFontFace to draw text with it.
+func LoadPCFFace(path string) (font.Face, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePCFFace(data)
+}
+
+// ParsePCFFace is like LoadPCFFace, but reads the font from data. The
+// glyphs are mapped to Unicode from the charset of the font, and the
+// default character of the font is drawn for missing characters.
+func ParsePCFFace(data []byte) (font.Face, error) {
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		if data, err = ioutil.ReadAll(r); err != nil {
+			return nil, err
+		}
+	}
+	f, err := parsePCF(data)
+	if err != nil {
+		return nil, err
+	}
+	return f.face(), nil
+}
+
+// pcfTable reads the values of a table of a PCF font in the byte order
+// given by its format. Reading past the end of the table sets err.
+type pcfTable struct {
+	data   []byte
+	format uint32
+	order  binary.ByteOrder
+	pos    int
+	err    bool
+}
+
+// bytes returns the next n bytes of t. When t has fewer bytes left, the
+// bytes that are left are returned and t.err is set.
+func (t *pcfTable) bytes(n int) []byte {
+	if n < 0 {
+		t.err = true
+		return nil
+	}
+	if n > len(t.data)-t.pos {
+		t.err = true
+		n = len(t.data) - t.pos
+	}
+	b := t.data[t.pos : t.pos+n]
+	t.pos += n
+	return b
+}
+
+// The integer readers return zero, and set t.err, past the end of t.
+
+func (t *pcfTable) uint8() int {
+	if b := t.bytes(1); len(b) == 1 {
+		return int(b[0])
+	}
+	return 0
+}
+
+func (t *pcfTable) int16() int {
+	if b := t.bytes(2); len(b) == 2 {
+		return int(int16(t.order.Uint16(b)))
+	}
+	return 0
+}
+
+func (t *pcfTable) int32() int {
+	if b := t.bytes(4); len(b) == 4 {
+		return int(int32(t.order.Uint32(b)))
+	}
+	return 0
+}
+
+// parsePCF parses the PCF font in data.
+func parsePCF(data []byte) (*bitmapFont, error) {
+	if !bytes.HasPrefix(data, []byte("\x01fcp")) || len(data) < 8 {
+		return nil, fmt.Errorf("gg: not a PCF font")
+	}
+	bad := func(what string) error {
+		return fmt.Errorf("gg: invalid PCF font %s", what)
+	}
+	// the table of contents is always least significant byte first
+	toc := &pcfTable{data: data, order: binary.LittleEndian, pos: 4}
+	count := toc.int32()
+	tables := map[int]*pcfTable{}
+	for i := 0; i < count && !toc.err; i++ {
+		kind, format, size, offset := toc.int32(), toc.int32(), toc.int32(), toc.int32()
+		if offset < 0 || size < 4 || offset+size > len(data) || offset+size < offset {
+			return nil, bad("table of contents")
+		}
+		t := &pcfTable{data: data[offset : offset+size], order: binary.LittleEndian}
+		t.format = uint32(t.int32())
+		if t.format != uint32(format) {
+			return nil, bad("table format")
+		}
+		if t.format&pcfByteMask != 0 {
+			t.order = binary.BigEndian
+		}
+		tables[kind] = t
+	}
+	if toc.err {
+		return nil, bad("table of contents")
+	}
+
+	f := &bitmapFont{
+		properties:  map[string]string{},
+		glyphs:      map[int]*bitmapGlyph{},
+		defaultChar: -1,
+	}
+	if t, ok := tables[pcfProperties]; ok {
+		if err := f.readPCFProperties(t); err != nil {
+			return nil, err
+		}
+	}
+	accelerators, ok := tables[pcfBDFAccelerators]
+	if !ok {
+		accelerators, ok = tables[pcfAccelerators]
+	}
+	if ok {
+		// skip the flags
+		accelerators.bytes(8)
+		f.ascent, f.descent = accelerators.int32(), accelerators.int32()
+	}
+
+	metrics, ok := tables[pcfMetrics]
+	if !ok {
+		return nil, bad("metrics")
+	}
+	glyphs, err := readPCFMetrics(metrics)
+	if err != nil {
+		return nil, err
+	}
+	bitmaps, ok := tables[pcfBitmaps]
+	if !ok {
+		return nil, bad("bitmaps")
+	}
+	if err := readPCFBitmaps(bitmaps, glyphs); err != nil {
+		return nil, err
+	}
+	encodings, ok := tables[pcfBDFEncodings]
+	if !ok {
+		return nil, bad("encodings")
+	}
+	if err := f.readPCFEncodings(encodings, glyphs); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// readPCFProperties reads the properties of f from the properties table.
+// Integer properties are kept as decimal strings, like in BDF fonts.
+func (f *bitmapFont) readPCFProperties(t *pcfTable) error {
+	count := t.int32()
+	if count < 0 || count > len(t.data)/pcfPropertySize {
+		return fmt.Errorf("gg: invalid PCF font properties")
+	}
+	type property struct {
+		name     int
+		isString bool
+		value    int
+	}
+	properties := make([]property, count)
+	for i := range properties {
+		properties[i] = property{t.int32(), t.uint8() != 0, t.int32()}
+	}
+	// the strings start at a multiple of four bytes
+	if count&3 != 0 {
+		t.bytes(4 - count&3)
+	}
+	names := t.bytes(t.int32())
+	if t.err {
+		return fmt.Errorf("gg: invalid PCF font properties")
+	}
+	str := func(offset int) string {
+		if offset < 0 || offset >= len(names) {
+			return ""
+		}
+		s := names[offset:]
+		if i := bytes.IndexByte(s, 0); i >= 0 {
+			s = s[:i]
+		}
+		return string(s)
+	}
+	for _, p := range properties {
+		if p.isString {
+			f.properties[str(p.name)] = str(p.value)
+		} else {
+			f.properties[str(p.name)] = strconv.Itoa(p.value)
+		}
+	}
+	f.defaultChar = f.property("DEFAULT_CHAR", -1)
+	return nil
+}
+
+// readPCFMetrics returns the glyphs of the metrics table, without ink.
+func readPCFMetrics(t *pcfTable) ([]*bitmapGlyph, error) {
+	compressed := t.format&pcfFormatMask == pcfCompressedMetrics
+	var count, size int
+	if compressed {
+		count, size = t.int16(), 5
+	} else {
+		count, size = t.int32(), 12
+	}
+	if count < 0 || count > len(t.data)/size {
+		return nil, fmt.Errorf("gg: invalid PCF font metrics")
+	}
+	glyphs := make([]*bitmapGlyph, count)
+	for i := range glyphs {
+		var left, right, advance, ascent, descent int
+		if compressed {
+			left = t.uint8() - pcfCompressedBias
+			right = t.uint8() - pcfCompressedBias
+			advance = t.uint8() - pcfCompressedBias
+			ascent = t.uint8() - pcfCompressedBias
+			descent = t.uint8() - pcfCompressedBias
+		} else {
+			left, right, advance, ascent, descent = t.int16(), t.int16(), t.int16(), t.int16(), t.int16()
+			// attributes
+			t.int16()
+		}
+		bounds := image.Rect(left, -ascent, right, descent)
+		if right < left || descent < -ascent || !validBitmapBounds(bounds) {
+			return nil, fmt.Errorf("gg: invalid PCF font metrics")
+		}
+		glyphs[i] = newBitmapGlyph(advance, bounds)
+	}
+	if t.err {
+		return nil, fmt.Errorf("gg: invalid PCF font metrics")
+	}
+	return glyphs, nil
+}
+
+// readPCFBitmaps sets the pixels of glyphs from the bitmaps table.
+func readPCFBitmaps(t *pcfTable, glyphs []*bitmapGlyph) error {
+	bad := fmt.Errorf("gg: invalid PCF font bitmaps")
+	if t.int32() != len(glyphs) {
+		return bad
+	}
+	offsets := make([]int, len(glyphs))
+	for i := range offsets {
+		offsets[i] = t.int32()
+	}
+	var sizes [4]int
+	for i := range sizes {
+		sizes[i] = t.int32()
+	}
+	pad := 1 << (t.format & pcfGlyphPadMask)
+	bits := append([]byte(nil), t.bytes(sizes[t.format&pcfGlyphPadMask])...)
+	if t.err {
+		return bad
+	}
+	// bring the bits to the most significant bit and byte first
+	if t.format&pcfBitMask == 0 {
+		for i, b := range bits {
+			bits[i] = reverseBits(b)
+		}
+	}
+	if (t.format&pcfByteMask != 0) != (t.format&pcfBitMask != 0) {
+		unit := 1 << ((t.format & pcfScanUnitMask) >> 4)
+		for i := 0; i+unit <= len(bits); i += unit {
+			for j := 0; j < unit/2; j++ {
+				bits[i+j], bits[i+unit-1-j] = bits[i+unit-1-j], bits[i+j]
+			}
+		}
+	}
+	for i, g := range glyphs {
+		size := g.mask.Rect.Size()
+		stride := ((size.X+7)/8 + pad - 1) / pad * pad
+		if offsets[i] < 0 || offsets[i]+stride*size.Y > len(bits) {
+			return bad
+		}
+		for y := 0; y < size.Y; y++ {
+			row := bits[offsets[i]+y*stride:]
+			for x := 0; x < size.X; x++ {
+				if row[x/8]&(0x80>>uint(x%8)) != 0 {
+					g.set(x, y)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// reverseBits returns b with the order of its bits reversed.
+func reverseBits(b byte) byte {
+	b = b>>4 | b<<4
+	b = b&0xcc>>2 | b&0x33<<2
+	return b&0xaa>>1 | b&0x55<<1
+}
+
+// readPCFEncodings adds glyphs to f by their codes, from the encodings
+// table. Codes of two byte encodings are the first byte times 256 plus the
+// second byte.
+func (f *bitmapFont) readPCFEncodings(t *pcfTable, glyphs []*bitmapGlyph) error {
+	min2, max2 := t.int16(), t.int16()
+	min1, max1 := t.int16(), t.int16()
+	defaultChar := t.int16()
+	for b1 := min1; b1 <= max1 && !t.err; b1++ {
+		for b2 := min2; b2 <= max2 && !t.err; b2++ {
+			index := int(uint16(t.int16()))
+			if index != pcfNoGlyph && index < len(glyphs) {
+				f.glyphs[b1<<8|b2] = glyphs[index]
+			}
+		}
+	}
+	if t.err {
+		return fmt.Errorf("gg: invalid PCF font encodings")
+	}
+	f.defaultChar = defaultChar
+	return nil
+}
@@ -0,0 +1,1144 @@
+package gg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/goki/freetype/truetype"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/f64"
+	"golang.org/x/image/math/fixed"
+)
+
+// Command is a single recorded drawing operation. Op names the Context
+// method, Args holds its numeric arguments (colors are stored as NRGBA
+// components in the range 0-255), Text holds string arguments, Image holds
+// PNG encoded image data and Data holds font data. Commands are plain data
+// and can be encoded with encoding/json.
+type Command struct {
+	Op    string    `json:"op"`
+	Args  []float64 `json:"args,omitempty"`
+	Text  string    `json:"text,omitempty"`
+	Image []byte    `json:"image,omitempty"`
+	Data  []byte    `json:"data,omitempty"`
+
+	im      image.Image
+	font    *truetype.Font
+	face    font.Face
+	faces   []font.Face
+	spans   []Span
+	pattern Pattern
+	filters []Filter
+	hyphen  Hyphenator
+}
+
+// Recorder captures drawing operations as a display list of Commands. Its
+// methods mirror the methods of Context that draw or change its state;
+// methods that only measure or return values, and SetFontRegistry,
+// SetTranformer and LoadFontNamed, are not recorded. A recording can be
+// played back any number of times, at any transform, into any Context using
+// Replay.
+type Recorder struct {
+	commands []Command
+}
+
+// NewRecorder creates an empty Recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// Commands returns the recorded commands.
+func (r *Recorder) Commands() []Command {
+	return r.commands
+}
+
+// Reset discards all recorded commands.
+func (r *Recorder) Reset() {
+	r.commands = nil
+}
+
+func (r *Recorder) add(op string, args ...float64) {
+	r.commands = append(r.commands, Command{Op: op, Args: args})
+}
+
+func (r *Recorder) addText(op, text string, args ...float64) {
+	r.commands = append(r.commands, Command{Op: op, Args: args, Text: text})
+}
+
+func colorArgs(c color.Color) []float64 {
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	return []float64{float64(n.R), float64(n.G), float64(n.B), float64(n.A)}
+}
+
+func argsColor(args []float64) color.Color {
+	return color.NRGBA{uint8(args[0]), uint8(args[1]), uint8(args[2]), uint8(args[3])}
+}
+
+// Path Manipulation
+
+func (r *Recorder) MoveTo(x, y float64) {
+	r.add("MoveTo", x, y)
+}
+
+func (r *Recorder) LineTo(x, y float64) {
+	r.add("LineTo", x, y)
+}
+
+func (r *Recorder) QuadraticTo(x1, y1, x2, y2 float64) {
+	r.add("QuadraticTo", x1, y1, x2, y2)
+}
+
+func (r *Recorder) CubicTo(x1, y1, x2, y2, x3, y3 float64) {
+	r.add("CubicTo", x1, y1, x2, y2, x3, y3)
+}
+
+func (r *Recorder) ClosePath() {
+	r.add("ClosePath")
+}
+
+func (r *Recorder) ClearPath() {
+	r.add("ClearPath")
+}
+
+func (r *Recorder) NewSubPath() {
+	r.add("NewSubPath")
+}
+
+// DrawSVGPath checks the SVG path data when it is recorded, so that data
+// with an error is returned at once instead of failing the replay, and is
+// not recorded.
+func (r *Recorder) DrawSVGPath(d string) error {
+	if err := NewContext(0, 0).DrawSVGPath(d); err != nil {
+		return err
+	}
+	r.addText("DrawSVGPath", d)
+	return nil
+}
+
+// Convenient Drawing Functions
+
+func (r *Recorder) DrawPoint(x, y, radius float64) {
+	r.add("DrawPoint", x, y, radius)
+}
+
+func (r *Recorder) DrawLine(x1, y1, x2, y2 float64) {
+	r.add("DrawLine", x1, y1, x2, y2)
+}
+
+func (r *Recorder) DrawRectangle(x, y, w, h float64) {
+	r.add("DrawRectangle", x, y, w, h)
+}
+
+func (r *Recorder) DrawRoundedRectangle(x, y, w, h, radius float64) {
+	r.add("DrawRoundedRectangle", x, y, w, h, radius)
+}
+
+func (r *Recorder) DrawEllipticalArc(x, y, rx, ry, angle1, angle2 float64) {
+	r.add("DrawEllipticalArc", x, y, rx, ry, angle1, angle2)
+}
+
+func (r *Recorder) DrawEllipse(x, y, rx, ry float64) {
+	r.add("DrawEllipse", x, y, rx, ry)
+}
+
+func (r *Recorder) DrawArc(x, y, radius, angle1, angle2 float64) {
+	r.add("DrawArc", x, y, radius, angle1, angle2)
+}
+
+func (r *Recorder) DrawCircle(x, y, radius float64) {
+	r.add("DrawCircle", x, y, radius)
+}
+
+func (r *Recorder) DrawRegularPolygon(n int, x, y, radius, rotation float64) {
+	r.add("DrawRegularPolygon", float64(n), x, y, radius, rotation)
+}
+
+// Painting
+
+func (r *Recorder) Clear() {
+	r.add("Clear")
+}
+
+func (r *Recorder) SetPixel(x, y int) {
+	r.add("SetPixel", float64(x), float64(y))
+}
+
+func (r *Recorder) Stroke() {
+	r.add("Stroke")
+}
+
+func (r *Recorder) StrokePreserve() {
+	r.add("StrokePreserve")
+}
+
+func (r *Recorder) StrokeToPath() {
+	r.add("StrokeToPath")
+}
+
+func (r *Recorder) Fill() {
+	r.add("Fill")
+}
+
+func (r *Recorder) FillPreserve() {
+	r.add("FillPreserve")
+}
+
+func (r *Recorder) FillStroke() {
+	r.add("FillStroke")
+}
+
+// Style
+
+func (r *Recorder) SetDash(dashes ...float64) {
+	r.add("SetDash", dashes...)
+}
+
+func (r *Recorder) SetLineWidth(lineWidth float64) {
+	r.add("SetLineWidth", lineWidth)
+}
+
+func (r *Recorder) SetLineCap(lineCap LineCap) {
+	r.add("SetLineCap", float64(lineCap))
+}
+
+func (r *Recorder) SetLineCapRound() {
+	r.SetLineCap(LineCapRound)
+}
+
+func (r *Recorder) SetLineCapButt() {
+	r.SetLineCap(LineCapButt)
+}
+
+func (r *Recorder) SetLineCapSquare() {
+	r.SetLineCap(LineCapSquare)
+}
+
+func (r *Recorder) SetLineJoin(lineJoin LineJoin) {
+	r.add("SetLineJoin", float64(lineJoin))
+}
+
+func (r *Recorder) SetLineJoinRound() {
+	r.SetLineJoin(LineJoinRound)
+}
+
+func (r *Recorder) SetLineJoinBevel() {
+	r.SetLineJoin(LineJoinBevel)
+}
+
+func (r *Recorder) SetFillRule(fillRule FillRule) {
+	r.add("SetFillRule", float64(fillRule))
+}
+
+func (r *Recorder) SetFillRuleWinding() {
+	r.SetFillRule(FillRuleWinding)
+}
+
+func (r *Recorder) SetFillRuleEvenOdd() {
+	r.SetFillRule(FillRuleEvenOdd)
+}
+
+func (r *Recorder) SetAntialias(antialias Antialias) {
+	r.add("SetAntialias", float64(antialias))
+}
+
+func (r *Recorder) SetAntialiasThreshold(threshold float64) {
+	r.add("SetAntialiasThreshold", threshold)
+}
+
+func (r *Recorder) SetColor(c color.Color) {
+	r.add("SetColor", colorArgs(c)...)
+}
+
+func (r *Recorder) SetFillColor(c color.Color) {
+	r.add("SetFillColor", colorArgs(c)...)
+}
+
+func (r *Recorder) SetStrokeColor(c color.Color) {
+	r.add("SetStrokeColor", colorArgs(c)...)
+}
+
+func (r *Recorder) SetHexColor(x string) {
+	red, green, blue, alpha := parseHexColor(x)
+	r.SetRGBA255(red, green, blue, alpha)
+}
+
+func (r *Recorder) SetRGBA255(red, green, blue, alpha int) {
+	r.SetColor(color.NRGBA{uint8(red), uint8(green), uint8(blue), uint8(alpha)})
+}
+
+func (r *Recorder) SetRGB255(red, green, blue int) {
+	r.SetRGBA255(red, green, blue, 255)
+}
+
+func (r *Recorder) SetRGBA(red, green, blue, alpha float64) {
+	r.SetColor(color.NRGBA{
+		uint8(red * 255),
+		uint8(green * 255),
+		uint8(blue * 255),
+		uint8(alpha * 255),
+	})
+}
+
+func (r *Recorder) SetRGB(red, green, blue float64) {
+	r.SetRGBA(red, green, blue, 1)
+}
+
+func (r *Recorder) SetFillStyle(pattern Pattern) {
+	r.commands = append(r.commands, patternCommand("SetFillStyle", pattern))
+}
+
+func (r *Recorder) SetStrokeStyle(pattern Pattern) {
+	r.commands = append(r.commands, patternCommand("SetStrokeStyle", pattern))
+}
+
+// patternCommand records the built-in pattern types as plain data. Other
+// Pattern implementations are kept in memory only.
+func patternCommand(op string, pattern Pattern) Command {
+	cmd := Command{Op: op, pattern: pattern}
+	addStops := func(stops stops) {
+		for _, s := range stops {
+			cmd.Args = append(cmd.Args, s.pos)
+			cmd.Args = append(cmd.Args, colorArgs(s.color)...)
+		}
+	}
+	switch p := pattern.(type) {
+	case *solidPattern:
+		cmd.Text = "solid"
+		cmd.Args = colorArgs(p.color)
+	case *linearGradient:
+		cmd.Text = "linear"
+		cmd.Args = []float64{p.x0, p.y0, p.x1, p.y1}
+		addStops(p.stops)
+	case *radialGradient:
+		cmd.Text = "radial"
+		cmd.Args = []float64{p.c0.x, p.c0.y, p.c0.r, p.c1.x, p.c1.y, p.c1.r}
+		addStops(p.stops)
+	case *surfacePattern:
+		cmd.Text = "surface"
+		cmd.Args = []float64{float64(p.op)}
+		cmd.im = p.im
+	}
+	return cmd
+}
+
+// patternArgs is the number of arguments of each kind of recorded pattern,
+// not counting the color stops of gradients.
+var patternArgs = map[string]int{"solid": 4, "linear": 4, "radial": 6, "surface": 1}
+
+func (cmd *Command) decodePattern() (Pattern, error) {
+	if cmd.pattern != nil {
+		return cmd.pattern, nil
+	}
+	addStops := func(g Gradient, args []float64) {
+		for i := 0; i+5 <= len(args); i += 5 {
+			g.AddColorStop(args[i], argsColor(args[i+1:]))
+		}
+	}
+	a := cmd.Args
+	if n := patternArgs[cmd.Text]; len(a) < n {
+		return nil, fmt.Errorf("gg: %s pattern has %d arguments, expected %d", cmd.Text, len(a), n)
+	}
+	switch cmd.Text {
+	case "solid":
+		return NewSolidPattern(argsColor(a)), nil
+	case "linear":
+		g := NewLinearGradient(a[0], a[1], a[2], a[3])
+		addStops(g, a[4:])
+		return g, nil
+	case "radial":
+		g := NewRadialGradient(a[0], a[1], a[2], a[3], a[4], a[5])
+		addStops(g, a[6:])
+		return g, nil
+	case "surface":
+		im, err := cmd.decodeImage()
+		if err != nil {
+			return nil, err
+		}
+		return NewSurfacePattern(im, RepeatOp(a[0])), nil
+	}
+	return nil, fmt.Errorf("gg: unknown pattern %q", cmd.Text)
+}
+
+func (cmd *Command) decodeImage() (image.Image, error) {
+	if cmd.im == nil {
+		im, err := png.Decode(bytes.NewReader(cmd.Image))
+		if err != nil {
+			return nil, err
+		}
+		cmd.im = im
+	}
+	return cmd.im, nil
+}
+
+func (r *Recorder) SetShadow(offsetX, offsetY, blur float64, c color.Color) {
+	r.add("SetShadow", append([]float64{offsetX, offsetY, blur}, colorArgs(c)...)...)
+}
+
+// SetFilter records filters. Filters cannot be serialized, so recordings
+// containing this command can only be replayed in memory.
+func (r *Recorder) SetFilter(filters ...Filter) {
+	r.commands = append(r.commands, Command{Op: "SetFilter", filters: filters})
+}
+
+// ApplyFilter records filters run on the whole image. Like SetFilter,
+// recordings containing this command can only be replayed in memory.
+func (r *Recorder) ApplyFilter(filters ...Filter) {
+	r.commands = append(r.commands, Command{Op: "ApplyFilter", filters: filters})
+}
+
+// ApplyFilterRect records filters run on a rectangle of the image. The
+// rectangle is in device space and is not affected by the transform
+// recordings are replayed at.
+func (r *Recorder) ApplyFilterRect(rect image.Rectangle, filters ...Filter) {
+	r.commands = append(r.commands, Command{
+		Op:      "ApplyFilterRect",
+		Args:    []float64{float64(rect.Min.X), float64(rect.Min.Y), float64(rect.Max.X), float64(rect.Max.Y)},
+		filters: filters,
+	})
+}
+
+// Transformation Matrix Operations
+
+func (r *Recorder) SetMatrix(m Matrix) {
+	r.add("SetMatrix", m.XX, m.YX, m.XY, m.YY, m.X0, m.Y0)
+}
+
+func (r *Recorder) Identity() {
+	r.add("Identity")
+}
+
+func (r *Recorder) Translate(x, y float64) {
+	r.add("Translate", x, y)
+}
+
+func (r *Recorder) Scale(x, y float64) {
+	r.add("Scale", x, y)
+}
+
+func (r *Recorder) ScaleAbout(sx, sy, x, y float64) {
+	r.add("ScaleAbout", sx, sy, x, y)
+}
+
+func (r *Recorder) Rotate(angle float64) {
+	r.add("Rotate", angle)
+}
+
+func (r *Recorder) RotateAbout(angle, x, y float64) {
+	r.add("RotateAbout", angle, x, y)
+}
+
+func (r *Recorder) Shear(x, y float64) {
+	r.add("Shear", x, y)
+}
+
+func (r *Recorder) ShearAbout(sx, sy, x, y float64) {
+	r.add("ShearAbout", sx, sy, x, y)
+}
+
+func (r *Recorder) InvertY() {
+	r.add("InvertY")
+}
+
+// Stack
+
+func (r *Recorder) Push() {
+	r.add("Push")
+}
+
+func (r *Recorder) Pop() {
+	r.add("Pop")
+}
+
+// Text
+
+// SetFontFace records a font face. Faces cannot be serialized, so recordings
+// containing this command can only be replayed in memory. Use LoadFontFace
+// for recordings that are encoded.
+func (r *Recorder) SetFontFace(fontFace font.Face) {
+	r.commands = append(r.commands, Command{Op: "SetFontFace", face: fontFace})
+}
+
+// SetFontFallbacks records fallback font faces. Like SetFontFace, recordings
+// containing fallbacks can only be replayed in memory.
+func (r *Recorder) SetFontFallbacks(faces ...font.Face) {
+	r.commands = append(r.commands, Command{Op: "SetFontFallbacks", faces: faces})
+}
+
+func (r *Recorder) LoadFontFace(path string, points float64) {
+	r.addText("LoadFontFace", path, points)
+}
+
+// SetFont records a parsed font. Like SetFontFace, recordings containing
+// this command can only be replayed in memory. Use LoadFont or LoadFontData
+// for recordings that are encoded.
+func (r *Recorder) SetFont(font *truetype.Font) {
+	r.commands = append(r.commands, Command{Op: "SetFont", font: font})
+}
+
+func (r *Recorder) LoadFont(path string) {
+	r.LoadFontIndex(path, 0)
+}
+
+func (r *Recorder) LoadFontIndex(path string, index int) {
+	r.addText("LoadFontIndex", path, float64(index))
+}
+
+// LoadFontData records the font data in the command, so that encoded
+// recordings do not depend on font files.
+func (r *Recorder) LoadFontData(ttf []byte) {
+	r.LoadFontDataIndex(ttf, 0)
+}
+
+func (r *Recorder) LoadFontDataIndex(ttf []byte, index int) {
+	r.commands = append(r.commands, Command{Op: "LoadFontDataIndex", Args: []float64{float64(index)}, Data: ttf})
+}
+
+func (r *Recorder) SetFontSize(points float64) {
+	r.add("SetFontSize", points)
+}
+
+// SetFontFamily records the selection of a font from the font registry of
+// the context the recording is replayed into.
+func (r *Recorder) SetFontFamily(family string, weight int, italic bool) {
+	slant := 0.0
+	if italic {
+		slant = 1
+	}
+	r.addText("SetFontFamily", family, float64(weight), slant)
+}
+
+// SetFontVariations records the values of the variation axes of variable
+// fonts. The axis tags are stored in Text separated by commas, in the order
+// of their values in Args.
+func (r *Recorder) SetFontVariations(variations map[string]float64) {
+	tags := make([]string, 0, len(variations))
+	for tag := range variations {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	args := make([]float64, len(tags))
+	for i, tag := range tags {
+		args[i] = variations[tag]
+	}
+	r.addText("SetFontVariations", strings.Join(tags, ","), args...)
+}
+
+func (r *Recorder) SetTextDirection(direction TextDirection) {
+	r.add("SetTextDirection", float64(direction))
+}
+
+func (r *Recorder) SetWrapMode(mode WrapMode) {
+	r.add("SetWrapMode", float64(mode))
+}
+
+func (r *Recorder) SetWritingMode(mode WritingMode) {
+	r.add("SetWritingMode", float64(mode))
+}
+
+func (r *Recorder) SetTextDecoration(decoration TextDecoration) {
+	r.add("SetTextDecoration", float64(decoration))
+}
+
+// SetTextDecorationColor records the color of text decorations. A nil
+// color, which selects the color of the text, is recorded without
+// arguments.
+func (r *Recorder) SetTextDecorationColor(c color.Color) {
+	if c == nil {
+		r.add("SetTextDecorationColor")
+		return
+	}
+	r.add("SetTextDecorationColor", colorArgs(c)...)
+}
+
+func (r *Recorder) SetTextDecorationSkipInk(skip bool) {
+	v := 0.0
+	if skip {
+		v = 1
+	}
+	r.add("SetTextDecorationSkipInk", v)
+}
+
+func (r *Recorder) SetLetterSpacing(spacing float64) {
+	r.add("SetLetterSpacing", spacing)
+}
+
+func (r *Recorder) SetWordSpacing(spacing float64) {
+	r.add("SetWordSpacing", spacing)
+}
+
+func (r *Recorder) SetTabInterval(interval float64) {
+	r.add("SetTabInterval", interval)
+}
+
+func (r *Recorder) SetTabStops(stops ...float64) {
+	r.add("SetTabStops", stops...)
+}
+
+// SetHyphenator records a hyphenation function. Functions cannot be
+// serialized, so recordings that set one can only be replayed in memory.
+func (r *Recorder) SetHyphenator(h Hyphenator) {
+	r.commands = append(r.commands, Command{Op: "SetHyphenator", hyphen: h})
+}
+
+func (r *Recorder) DrawString(s string, x, y float64) {
+	r.addText("DrawString", s, x, y)
+}
+
+func (r *Recorder) DrawStringAnchored(s string, x, y, ax, ay float64) {
+	r.addText("DrawStringAnchored", s, x, y, ax, ay)
+}
+
+func (r *Recorder) DrawStringStroke(s string, x, y float64) {
+	r.DrawStringStrokeAnchored(s, x, y, 0, 0)
+}
+
+func (r *Recorder) DrawStringStrokeAnchored(s string, x, y, ax, ay float64) {
+	r.addText("DrawStringStrokeAnchored", s, x, y, ax, ay)
+}
+
+func (r *Recorder) DrawStringWrapped(s string, x, y, ax, ay, width, lineSpacing float64, align Align) {
+	r.addText("DrawStringWrapped", s, x, y, ax, ay, width, lineSpacing, float64(align))
+}
+
+func (r *Recorder) CreateStringPath(s string, x, y float64) {
+	r.addText("CreateStringPath", s, x, y)
+}
+
+// DrawContour records a glyph contour. Its arguments are the offset
+// followed by the coordinates, in 26.6 fixed point units, and flags of each
+// point.
+func (r *Recorder) DrawContour(ps []truetype.Point, dx, dy float64) {
+	args := []float64{dx, dy}
+	for _, p := range ps {
+		args = append(args, float64(p.X), float64(p.Y), float64(p.Flags))
+	}
+	r.add("DrawContour", args...)
+}
+
+// DrawStringTruncated records truncated text. The limits of t follow the
+// arguments of DrawStringWrapped, and are followed by the runes of the
+// ellipsis.
+func (r *Recorder) DrawStringTruncated(s string, x, y, ax, ay, width, lineSpacing float64, align Align, t Truncation) {
+	args := []float64{x, y, ax, ay, width, lineSpacing, float64(align), float64(t.MaxLines), t.MaxHeight, float64(t.Position)}
+	for _, c := range t.Ellipsis {
+		args = append(args, float64(c))
+	}
+	r.addText("DrawStringTruncated", s, args...)
+}
+
+func (r *Recorder) DrawStringOnPath(s string, p TextOnPath) {
+	r.addText("DrawStringOnPath", s, textOnPathArgs(p)...)
+}
+
+func (r *Recorder) CreateStringPathOnPath(s string, p TextOnPath) {
+	r.addText("CreateStringPathOnPath", s, textOnPathArgs(p)...)
+}
+
+// textOnPathArgs flattens text on path options to command arguments: the
+// offset, alignment and side followed by the coordinates of the points.
+func textOnPathArgs(p TextOnPath) []float64 {
+	args := []float64{p.Offset, float64(p.Align), float64(p.Side)}
+	for _, pt := range p.Points {
+		args = append(args, pt.X, pt.Y)
+	}
+	return args
+}
+
+func textOnPathFromArgs(a []float64) TextOnPath {
+	p := TextOnPath{Offset: a[0], Align: Align(a[1]), Side: TextSide(a[2])}
+	for i := 3; i+1 < len(a); i += 2 {
+		p.Points = append(p.Points, Point{a[i], a[i+1]})
+	}
+	return p
+}
+
+// DrawSpans records rich text. Spans may hold font faces and patterns that
+// cannot be serialized, so recordings containing spans can only be replayed
+// in memory.
+func (r *Recorder) DrawSpans(spans []Span, x, y float64) {
+	r.DrawSpansAnchored(spans, x, y, 0, 0)
+}
+
+func (r *Recorder) DrawSpansAnchored(spans []Span, x, y, ax, ay float64) {
+	r.commands = append(r.commands, Command{Op: "DrawSpansAnchored", Args: []float64{x, y, ax, ay}, spans: spans})
+}
+
+func (r *Recorder) DrawSpansWrapped(spans []Span, x, y, ax, ay, width, lineSpacing float64, align Align) {
+	r.commands = append(r.commands, Command{
+		Op:    "DrawSpansWrapped",
+		Args:  []float64{x, y, ax, ay, width, lineSpacing, float64(align)},
+		spans: spans,
+	})
+}
+
+// Images
+
+func (r *Recorder) DrawImage(im image.Image, x, y int) {
+	r.DrawImageAnchored(im, x, y, 0, 0)
+}
+
+func (r *Recorder) DrawImageAnchored(im image.Image, x, y int, ax, ay float64) {
+	r.commands = append(r.commands, Command{
+		Op:   "DrawImageAnchored",
+		Args: []float64{float64(x), float64(y), ax, ay},
+		im:   im,
+	})
+}
+
+// Clipping
+
+func (r *Recorder) Clip() {
+	r.add("Clip")
+}
+
+func (r *Recorder) ClipPreserve() {
+	r.add("ClipPreserve")
+}
+
+func (r *Recorder) ResetClip() {
+	r.add("ResetClip")
+}
+
+func (r *Recorder) InvertMask() {
+	r.add("InvertMask")
+}
+
+func (r *Recorder) SetMask(mask *image.Alpha) {
+	r.commands = append(r.commands, Command{Op: "SetMask", im: mask})
+}
+
+// Replay plays the recorded commands back into dc. All transforms in the
+// recording are applied on top of m, which is itself applied on top of the
+// current matrix of dc. Patterns follow the matrix they are painted with,
+// and the pixels of SetPixel and the masks of SetMask, which are in the
+// device space of the recording, are mapped through m and the matrix of dc
+// too. The state of dc, including its clipping mask, is restored when
+// Replay returns; its current path is not.
+func (r *Recorder) Replay(dc *Context, m Matrix) error {
+	base := m.Multiply(dc.matrix)
+	mask := dc.mask
+	clipRect := dc.clipRect
+	dc.Push()
+	depth := len(dc.stack)
+	defer func() {
+		for len(dc.stack) >= depth {
+			dc.Pop()
+		}
+		dc.mask = mask
+		dc.clipRect = clipRect
+	}()
+	dc.matrix = base
+	for i := range r.commands {
+		cmd := &r.commands[i]
+		if err := replayCommand(dc, cmd, base, depth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// commandArgs is the number of arguments each command with a fixed number of
+// arguments needs. Commands with more arguments are replayed with the first
+// ones.
+var commandArgs = map[string]int{
+	"MoveTo":                   2,
+	"LineTo":                   2,
+	"QuadraticTo":              4,
+	"CubicTo":                  6,
+	"DrawPoint":                3,
+	"DrawLine":                 4,
+	"DrawRectangle":            4,
+	"DrawRoundedRectangle":     5,
+	"DrawEllipticalArc":        6,
+	"DrawEllipse":              4,
+	"DrawArc":                  5,
+	"DrawCircle":               3,
+	"DrawRegularPolygon":       5,
+	"SetPixel":                 2,
+	"SetLineWidth":             1,
+	"SetLineCap":               1,
+	"SetLineJoin":              1,
+	"SetFillRule":              1,
+	"SetAntialias":             1,
+	"SetAntialiasThreshold":    1,
+	"SetColor":                 4,
+	"SetFillColor":             4,
+	"SetStrokeColor":           4,
+	"SetShadow":                7,
+	"ApplyFilterRect":          4,
+	"SetMatrix":                6,
+	"Translate":                2,
+	"Scale":                    2,
+	"ScaleAbout":               4,
+	"Rotate":                   1,
+	"RotateAbout":              3,
+	"Shear":                    2,
+	"ShearAbout":               4,
+	"LoadFontFace":             1,
+	"LoadFontIndex":            1,
+	"LoadFontDataIndex":        1,
+	"SetFontSize":              1,
+	"SetFontFamily":            2,
+	"SetTextDirection":         1,
+	"SetWrapMode":              1,
+	"SetWritingMode":           1,
+	"SetTextDecoration":        1,
+	"SetTextDecorationSkipInk": 1,
+	"SetLetterSpacing":         1,
+	"SetWordSpacing":           1,
+	"SetTabInterval":           1,
+	"DrawString":               2,
+	"CreateStringPath":         2,
+	"DrawContour":              2,
+	"DrawStringAnchored":       4,
+	"DrawStringStrokeAnchored": 4,
+	"DrawStringWrapped":        7,
+	"DrawStringTruncated":      10,
+	"DrawStringOnPath":         3,
+	"CreateStringPathOnPath":   3,
+	"DrawSpansAnchored":        4,
+	"DrawSpansWrapped":         7,
+	"DrawImageAnchored":        4,
+}
+
+func replayCommand(dc *Context, cmd *Command, base Matrix, depth int) error {
+	a := cmd.Args
+	if n := commandArgs[cmd.Op]; len(a) < n {
+		return fmt.Errorf("gg: %s command has %d arguments, expected %d", cmd.Op, len(a), n)
+	}
+	switch cmd.Op {
+	case "MoveTo":
+		dc.MoveTo(a[0], a[1])
+	case "LineTo":
+		dc.LineTo(a[0], a[1])
+	case "QuadraticTo":
+		dc.QuadraticTo(a[0], a[1], a[2], a[3])
+	case "CubicTo":
+		dc.CubicTo(a[0], a[1], a[2], a[3], a[4], a[5])
+	case "ClosePath":
+		dc.ClosePath()
+	case "ClearPath":
+		dc.ClearPath()
+	case "DrawSVGPath":
+		if err := dc.DrawSVGPath(cmd.Text); err != nil {
+			return err
+		}
+	case "NewSubPath":
+		dc.NewSubPath()
+	case "DrawPoint":
+		dc.DrawPoint(a[0], a[1], a[2])
+	case "DrawLine":
+		dc.DrawLine(a[0], a[1], a[2], a[3])
+	case "DrawRectangle":
+		dc.DrawRectangle(a[0], a[1], a[2], a[3])
+	case "DrawRoundedRectangle":
+		dc.DrawRoundedRectangle(a[0], a[1], a[2], a[3], a[4])
+	case "DrawEllipticalArc":
+		dc.DrawEllipticalArc(a[0], a[1], a[2], a[3], a[4], a[5])
+	case "DrawEllipse":
+		dc.DrawEllipse(a[0], a[1], a[2], a[3])
+	case "DrawArc":
+		dc.DrawArc(a[0], a[1], a[2], a[3], a[4])
+	case "DrawCircle":
+		dc.DrawCircle(a[0], a[1], a[2])
+	case "DrawRegularPolygon":
+		dc.DrawRegularPolygon(int(a[0]), a[1], a[2], a[3], a[4])
+	case "Clear":
+		dc.Clear()
+	case "SetPixel":
+		replayPixel(dc, base, int(a[0]), int(a[1]))
+	case "Stroke":
+		dc.Stroke()
+	case "StrokePreserve":
+		dc.StrokePreserve()
+	case "StrokeToPath":
+		dc.StrokeToPath()
+	case "Fill":
+		dc.Fill()
+	case "FillPreserve":
+		dc.FillPreserve()
+	case "FillStroke":
+		dc.FillStroke()
+	case "SetDash":
+		dc.SetDash(a...)
+	case "SetLineWidth":
+		dc.SetLineWidth(a[0])
+	case "SetLineCap":
+		dc.SetLineCap(LineCap(a[0]))
+	case "SetLineJoin":
+		dc.SetLineJoin(LineJoin(a[0]))
+	case "SetFillRule":
+		dc.SetFillRule(FillRule(a[0]))
+	case "SetAntialias":
+		dc.SetAntialias(Antialias(a[0]))
+	case "SetAntialiasThreshold":
+		dc.SetAntialiasThreshold(a[0])
+	case "SetColor":
+		dc.SetColor(argsColor(a))
+	case "SetFillColor":
+		dc.SetFillColor(argsColor(a))
+	case "SetStrokeColor":
+		dc.SetStrokeColor(argsColor(a))
+	case "SetShadow":
+		dc.SetShadow(a[0], a[1], a[2], argsColor(a[3:]))
+	case "SetFilter":
+		dc.SetFilter(cmd.filters...)
+	case "ApplyFilter":
+		dc.ApplyFilter(cmd.filters...)
+	case "ApplyFilterRect":
+		dc.ApplyFilterRect(image.Rect(int(a[0]), int(a[1]), int(a[2]), int(a[3])), cmd.filters...)
+	case "SetFillStyle", "SetStrokeStyle":
+		p, err := cmd.decodePattern()
+		if err != nil {
+			return err
+		}
+		if cmd.Op == "SetFillStyle" {
+			dc.SetFillStyle(p)
+		} else {
+			dc.SetStrokeStyle(p)
+		}
+	case "SetMatrix":
+		dc.SetMatrix(Matrix{a[0], a[1], a[2], a[3], a[4], a[5]}.Multiply(base))
+	case "Identity":
+		dc.SetMatrix(base)
+	case "Translate":
+		dc.Translate(a[0], a[1])
+	case "Scale":
+		dc.Scale(a[0], a[1])
+	case "ScaleAbout":
+		dc.ScaleAbout(a[0], a[1], a[2], a[3])
+	case "Rotate":
+		dc.Rotate(a[0])
+	case "RotateAbout":
+		dc.RotateAbout(a[0], a[1], a[2])
+	case "Shear":
+		dc.Shear(a[0], a[1])
+	case "ShearAbout":
+		dc.ShearAbout(a[0], a[1], a[2], a[3])
+	case "InvertY":
+		dc.InvertY()
+	case "Push":
+		dc.Push()
+	case "Pop":
+		// never pop past the state saved by Replay
+		if len(dc.stack) > depth {
+			dc.Pop()
+		}
+	case "SetFontFace":
+		if cmd.face == nil {
+			return fmt.Errorf("gg: font face of %s command was not recorded", cmd.Op)
+		}
+		dc.SetFontFace(cmd.face)
+	case "SetFont":
+		if cmd.font == nil {
+			return fmt.Errorf("gg: font of %s command was not recorded", cmd.Op)
+		}
+		dc.SetFont(cmd.font)
+	case "LoadFontIndex":
+		if err := dc.LoadFontIndex(cmd.Text, int(a[0])); err != nil {
+			return err
+		}
+	case "LoadFontDataIndex":
+		if err := dc.LoadFontDataIndex(cmd.Data, int(a[0])); err != nil {
+			return err
+		}
+	case "SetFontSize":
+		dc.SetFontSize(a[0])
+	case "SetFontFallbacks":
+		dc.SetFontFallbacks(cmd.faces...)
+	case "LoadFontFace":
+		if err := dc.LoadFontFace(cmd.Text, a[0]); err != nil {
+			return err
+		}
+	case "SetFontFamily":
+		if err := dc.SetFontFamily(cmd.Text, int(a[0]), a[1] != 0); err != nil {
+			return err
+		}
+	case "SetFontVariations":
+		var variations map[string]float64
+		if cmd.Text != "" {
+			tags := strings.Split(cmd.Text, ",")
+			if len(tags) != len(a) {
+				return fmt.Errorf("gg: %s command has %d tags and %d values", cmd.Op, len(tags), len(a))
+			}
+			variations = map[string]float64{}
+			for i, tag := range tags {
+				variations[tag] = a[i]
+			}
+		}
+		if err := dc.SetFontVariations(variations); err != nil {
+			return err
+		}
+	case "SetTextDirection":
+		dc.SetTextDirection(TextDirection(a[0]))
+	case "SetWrapMode":
+		dc.SetWrapMode(WrapMode(a[0]))
+	case "SetWritingMode":
+		dc.SetWritingMode(WritingMode(a[0]))
+	case "SetTextDecoration":
+		dc.SetTextDecoration(TextDecoration(a[0]))
+	case "SetTextDecorationColor":
+		switch {
+		case len(a) == 0:
+			dc.SetTextDecorationColor(nil)
+		case len(a) < 4:
+			return fmt.Errorf("gg: %s command has %d arguments, expected 0 or 4", cmd.Op, len(a))
+		default:
+			dc.SetTextDecorationColor(argsColor(a))
+		}
+	case "SetTextDecorationSkipInk":
+		dc.SetTextDecorationSkipInk(a[0] != 0)
+	case "SetLetterSpacing":
+		dc.SetLetterSpacing(a[0])
+	case "SetWordSpacing":
+		dc.SetWordSpacing(a[0])
+	case "SetTabInterval":
+		dc.SetTabInterval(a[0])
+	case "SetTabStops":
+		dc.SetTabStops(a...)
+	case "SetHyphenator":
+		dc.SetHyphenator(cmd.hyphen)
+	case "DrawString":
+		dc.DrawString(cmd.Text, a[0], a[1])
+	case "DrawStringAnchored":
+		dc.DrawStringAnchored(cmd.Text, a[0], a[1], a[2], a[3])
+	case "DrawStringStrokeAnchored":
+		dc.DrawStringStrokeAnchored(cmd.Text, a[0], a[1], a[2], a[3])
+	case "DrawStringWrapped":
+		dc.DrawStringWrapped(cmd.Text, a[0], a[1], a[2], a[3], a[4], a[5], Align(a[6]))
+	case "CreateStringPath":
+		dc.CreateStringPath(cmd.Text, a[0], a[1])
+	case "DrawContour":
+		var ps []truetype.Point
+		for i := 2; i+2 < len(a); i += 3 {
+			ps = append(ps, truetype.Point{X: fixed.Int26_6(a[i]), Y: fixed.Int26_6(a[i+1]), Flags: uint32(a[i+2])})
+		}
+		dc.DrawContour(ps, a[0], a[1])
+	case "DrawStringTruncated":
+		t := Truncation{MaxLines: int(a[7]), MaxHeight: a[8], Position: TruncatePosition(a[9])}
+		var ellipsis []rune
+		for _, c := range a[10:] {
+			ellipsis = append(ellipsis, rune(c))
+		}
+		t.Ellipsis = string(ellipsis)
+		dc.DrawStringTruncated(cmd.Text, a[0], a[1], a[2], a[3], a[4], a[5], Align(a[6]), t)
+	case "DrawStringOnPath":
+		dc.DrawStringOnPath(cmd.Text, textOnPathFromArgs(a))
+	case "CreateStringPathOnPath":
+		dc.CreateStringPathOnPath(cmd.Text, textOnPathFromArgs(a))
+	case "DrawSpansAnchored":
+		dc.DrawSpansAnchored(cmd.spans, a[0], a[1], a[2], a[3])
+	case "DrawSpansWrapped":
+		dc.DrawSpansWrapped(cmd.spans, a[0], a[1], a[2], a[3], a[4], a[5], Align(a[6]))
+	case "DrawImageAnchored":
+		im, err := cmd.decodeImage()
+		if err != nil {
+			return err
+		}
+		dc.DrawImageAnchored(im, int(a[0]), int(a[1]), a[2], a[3])
+	case "Clip":
+		dc.Clip()
+	case "ClipPreserve":
+		dc.ClipPreserve()
+	case "ResetClip":
+		dc.ResetClip()
+	case "InvertMask":
+		dc.InvertMask()
+	case "SetMask":
+		im, err := cmd.decodeImage()
+		if err != nil {
+			return err
+		}
+		mask, ok := im.(*image.Alpha)
+		if !ok {
+			mask = image.NewAlpha(im.Bounds())
+			draw.Draw(mask, mask.Bounds(), im, im.Bounds().Min, draw.Src)
+		}
+		if base != Identity() {
+			mask = transformMask(mask, base, dc.im.Bounds())
+		}
+		if err := dc.SetMask(mask); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("gg: unknown command %q", cmd.Op)
+	}
+	return nil
+}
+
+// replayPixel replays SetPixel, whose pixel is in the device space of the
+// recording, by setting the pixels of dc whose centers base maps back into
+// it.
+func replayPixel(dc *Context, base Matrix, x, y int) {
+	if base == Identity() {
+		dc.SetPixel(x, y)
+		return
+	}
+	inverse := base.Inverse()
+	x0, y0, x1, y1 := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+	for _, p := range [][2]float64{{0, 0}, {1, 0}, {1, 1}, {0, 1}} {
+		px, py := base.TransformPoint(float64(x)+p[0], float64(y)+p[1])
+		x0, y0 = math.Min(x0, px), math.Min(y0, py)
+		x1, y1 = math.Max(x1, px), math.Max(y1, py)
+	}
+	r := image.Rect(int(math.Floor(x0)), int(math.Floor(y0)), int(math.Ceil(x1)), int(math.Ceil(y1)))
+	r = r.Intersect(dc.im.Bounds())
+	for py := r.Min.Y; py < r.Max.Y; py++ {
+		for px := r.Min.X; px < r.Max.X; px++ {
+			sx, sy := inverse.TransformPoint(float64(px)+0.5, float64(py)+0.5)
+			if int(math.Floor(sx)) == x && int(math.Floor(sy)) == y {
+				dc.SetPixel(px, py)
+			}
+		}
+	}
+}
+
+// transformMask maps a mask in the device space of a recording through
+// base onto a mask with the given bounds.
+func transformMask(mask *image.Alpha, base Matrix, bounds image.Rectangle) *image.Alpha {
+	dst := image.NewAlpha(bounds)
+	s2d := f64.Aff3{base.XX, base.XY, base.X0, base.YX, base.YY, base.Y0}
+	draw.BiLinear.Transform(dst, s2d, mask, mask.Bounds(), draw.Src, nil)
+	return dst
+}
+
+// Encode writes the recorded commands to w as JSON. Images are stored as
+// PNG data. An error is returned if the recording holds fonts, font faces,
+// rich text, hyphenators, filters or patterns that cannot be serialized.
+func (r *Recorder) Encode(w io.Writer) error {
+	for i := range r.commands {
+		cmd := &r.commands[i]
+		if cmd.font != nil || cmd.face != nil || cmd.faces != nil || cmd.spans != nil || cmd.filters != nil || cmd.hyphen != nil {
+			return fmt.Errorf("gg: command %d (%s) cannot be serialized", i, cmd.Op)
+		}
+		if cmd.pattern != nil && cmd.Text == "" {
+			return fmt.Errorf("gg: command %d (%s) cannot be serialized", i, cmd.Op)
+		}
+		if cmd.im != nil && cmd.Image == nil {
+			var buf bytes.Buffer
+			if err := png.Encode(&buf, cmd.im); err != nil {
+				return err
+			}
+			cmd.Image = buf.Bytes()
+		}
+	}
+	return json.NewEncoder(w).Encode(r.commands)
+}
+
+// DecodeRecorder reads commands written by Recorder.Encode.
+func DecodeRecorder(rd io.Reader) (*Recorder, error) {
+	r := NewRecorder()
+	if err := json.NewDecoder(rd).Decode(&r.commands); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
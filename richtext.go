@@ -0,0 +1,357 @@
+package gg
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"strings"
+
+	"github.com/golang/freetype/raster"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// Span is a run of text with its own style. A list of spans makes up a line
+// or paragraph of rich text, which is drawn with DrawSpans,
+// DrawSpansAnchored or DrawSpansWrapped.
+type Span struct {
+	Text string
+
+	// Face is the font face of the text. If it is nil the current font face
+	// and fallbacks are used, unless Size is set.
+	Face font.Face
+
+	// Size, when Face is nil and Size is not zero, selects the font set with
+	// SetFont, LoadFont or LoadFontData at that size in points.
+	Size float64
+
+	// Color is the color of the text. If both Color and Pattern are nil the
+	// fill pattern is used.
+	Color color.Color
+
+	// Pattern paints the text instead of Color when it is not nil.
+	Pattern Pattern
+
+	// Underline draws a line under the text.
+	Underline bool
+
+	// BaselineShift raises the text above the baseline, or lowers it below
+	// the baseline when negative, for superscripts and subscripts.
+	BaselineShift float64
+}
+
+// spanLine is a line of rich text laid out from left to right.
+type spanLine struct {
+	spans   []Span
+	faces   [][]font.Face
+	glyphs  []glyph
+	advance fixed.Int26_6
+	height  float64
+	ascent  float64
+}
+
+// spanFaces resolves the faces of a span. Faces created for Span.Size are
+// kept in cache so that they are shared by repeated layouts.
+func (dc *Context) spanFaces(span Span, cache map[float64]font.Face) []font.Face {
+	if span.Face != nil {
+		return append([]font.Face{span.Face}, dc.fontFallbacks...)
+	}
+	if span.Size != 0 && dc.HasScalableFont() {
+		face, ok := cache[span.Size]
+		if !ok {
+			face = dc.newFace(span.Size)
+			cache[span.Size] = face
+		}
+		return append([]font.Face{face}, dc.fontFallbacks...)
+	}
+	return dc.faces()
+}
+
+// layoutSpans lays out spans on a single line.
+func (dc *Context) layoutSpans(spans []Span, cache map[float64]font.Face) *spanLine {
+	line := &spanLine{spans: spans}
+	var text []rune
+	var ends []int
+	for _, span := range spans {
+		faces := dc.spanFaces(span, cache)
+		line.faces = append(line.faces, faces)
+		m := faces[0].Metrics()
+		line.height = math.Max(line.height, float64(m.Height)/64)
+		line.ascent = math.Max(line.ascent, float64(m.Ascent)/64)
+		text = append(text, []rune(span.Text)...)
+		ends = append(ends, len(text))
+	}
+	if len(spans) == 0 {
+		line.height = dc.fontHeight
+		line.ascent = dc.fontAscent()
+	}
+	line.glyphs, line.advance = layoutText(text, ends, line.faces, dc.textDirection)
+	for i := range line.glyphs {
+		g := &line.glyphs[i]
+		g.dot.Y -= fix(spans[g.span].BaselineShift)
+	}
+	return line
+}
+
+// MeasureSpans returns the rendered width and height of a line of rich text.
+// The height is the largest font height of the spans.
+func (dc *Context) MeasureSpans(spans []Span) (w, h float64) {
+	line := dc.layoutSpans(spans, map[float64]font.Face{})
+	return float64(line.advance >> 6), line.height
+}
+
+// DrawSpans draws a line of rich text with its baseline starting at the
+// specified point.
+func (dc *Context) DrawSpans(spans []Span, x, y float64) {
+	dc.DrawSpansAnchored(spans, x, y, 0, 0)
+}
+
+// DrawSpansAnchored draws a line of rich text at the specified anchor point.
+// The anchor point is x - w * ax, y - h * ay, where w is the width of the
+// text and h the largest ascent of the fonts of the spans, like in
+// DrawStringAnchored.
+func (dc *Context) DrawSpansAnchored(spans []Span, x, y, ax, ay float64) {
+	line := dc.layoutSpans(spans, map[float64]font.Face{})
+	x -= ax * float64(line.advance>>6)
+	y += ay * line.ascent
+	dc.drawSpanLines([]*spanLine{line}, []float64{x}, []float64{y})
+}
+
+// WordWrapSpans wraps rich text to the given max width. Each line is returned
+// as a list of spans that keep the styles of the spans they were cut from.
+func (dc *Context) WordWrapSpans(spans []Span, width float64) [][]Span {
+	var result [][]Span
+	s := spansText(spans)
+	for _, l := range dc.wrapSpans(spans, s, width, map[float64]font.Face{}) {
+		result = append(result, lineSpans(spans, l))
+	}
+	return result
+}
+
+// MeasureSpansWrapped returns the size of rich text wrapped to the given
+// width with the given line spacing, as drawn by DrawSpansWrapped.
+func (dc *Context) MeasureSpansWrapped(spans []Span, width, lineSpacing float64) (w, h float64) {
+	cache := map[float64]font.Face{}
+	lines := dc.WordWrapSpans(spans, width)
+	for i, line := range lines {
+		l := dc.layoutSpans(line, cache)
+		if lw := float64(l.advance >> 6); lw > w {
+			w = lw
+		}
+		h += l.height * lineSpacing
+		if i == len(lines)-1 {
+			h -= (lineSpacing - 1) * l.height
+		}
+	}
+	return w, h
+}
+
+// DrawSpansWrapped word-wraps rich text to the given max width and then
+// draws it at the specified anchor point using the given line spacing and
+// text alignment, like DrawStringWrapped. Each line is as high as its
+// largest font. AlignJustify stretches every line but the last of each
+// paragraph to the full width.
+func (dc *Context) DrawSpansWrapped(spans []Span, x, y, ax, ay, width, lineSpacing float64, align Align) {
+	cache := map[float64]font.Face{}
+	s := spansText(spans)
+	direction := dc.textDirection
+	defer dc.SetTextDirection(direction)
+
+	var lines []*spanLine
+	var xs, ys []float64
+	h := 0.0
+	for i, l := range dc.wrapSpans(spans, s, width, cache) {
+		// the direction of the paragraph the line belongs to
+		start := strings.LastIndex(s[:l.start], "\n") + 1
+		end := strings.Index(s[start:], "\n")
+		if end < 0 {
+			end = len(s)
+		} else {
+			end += start
+		}
+		rtl := paragraphRTL([]rune(s[start:end]), direction)
+		dc.SetTextDirection(TextDirectionLTR)
+		if rtl {
+			dc.SetTextDirection(TextDirectionRTL)
+		}
+		line := dc.layoutSpans(lineSpans(spans, l), cache)
+		if align == AlignJustify && !l.last {
+			justify(line.glyphs, fix(width)-line.advance)
+			line.advance = fix(width)
+		}
+		if i > 0 {
+			h += (lineSpacing - 1) * lines[i-1].height
+		}
+		lx, lax := lineAnchor(align, rtl, x, width)
+		lines = append(lines, line)
+		xs = append(xs, lx-lax*float64(line.advance>>6))
+		ys = append(ys, h+line.ascent)
+		h += line.height
+	}
+
+	x -= ax * width
+	y -= ay * h
+	for i := range xs {
+		xs[i] -= ax * width
+		ys[i] += y
+	}
+	dc.drawSpanLines(lines, xs, ys)
+}
+
+// wrapSpans returns the lines of s, the text of spans, wrapped to width.
+func (dc *Context) wrapSpans(spans []Span, s string, width float64, cache map[float64]font.Face) []wrapLine {
+	return dc.wordWrap(s, width, func(i, j int, hyphen bool) float64 {
+		line := dc.layoutSpans(lineSpans(spans, wrapLine{start: i, end: j, hyphen: hyphen}), cache)
+		return float64(line.advance >> 6)
+	})
+}
+
+// lineSpans returns the spans of a wrapped line. The hyphen of a line that
+// ends inside a word takes the style of the last span.
+func lineSpans(spans []Span, l wrapLine) []Span {
+	result := sliceSpans(spans, l.start, l.end)
+	if l.hyphen && len(result) > 0 {
+		result[len(result)-1].Text += "-"
+	}
+	return result
+}
+
+// spansText returns the text of all spans.
+func spansText(spans []Span) string {
+	var b strings.Builder
+	for _, span := range spans {
+		b.WriteString(span.Text)
+	}
+	return b.String()
+}
+
+// sliceSpans returns the spans cut to the byte range [i, j) of their text.
+func sliceSpans(spans []Span, i, j int) []Span {
+	var result []Span
+	offset := 0
+	for _, span := range spans {
+		start, end := i-offset, j-offset
+		offset += len(span.Text)
+		if start < 0 {
+			start = 0
+		}
+		if end > len(span.Text) {
+			end = len(span.Text)
+		}
+		if start >= end {
+			continue
+		}
+		span.Text = span.Text[start:end]
+		result = append(result, span)
+	}
+	return result
+}
+
+// drawSpanLines draws lines of rich text with their baselines starting at
+// xs, ys.
+func (dc *Context) drawSpanLines(lines []*spanLine, xs, ys []float64) {
+	var bounds image.Rectangle
+	for i, line := range lines {
+		bounds = bounds.Union(glyphsBounds(line.glyphs, xs[i], ys[i], dc.matrix))
+	}
+	dc.composite(bounds, func(dst *image.RGBA, mask *image.Alpha) {
+		im := dst
+		if mask != nil {
+			im = image.NewRGBA(image.Rect(0, 0, dc.width, dc.height))
+		}
+		for i, line := range lines {
+			dc.drawSpanLine(im, line, xs[i], ys[i])
+		}
+		if mask != nil {
+			draw.DrawMask(dst, dst.Bounds(), im, image.ZP, mask, image.ZP, draw.Over)
+		}
+	})
+}
+
+// drawSpanLine draws a line of rich text with its baseline starting at x, y.
+func (dc *Context) drawSpanLine(im *image.RGBA, line *spanLine, x, y float64) {
+	for i, span := range line.spans {
+		var glyphs []glyph
+		for _, g := range line.glyphs {
+			if g.span == i {
+				glyphs = append(glyphs, g)
+			}
+		}
+		pattern := span.Pattern
+		if pattern == nil {
+			pattern = dc.fillPattern
+			if span.Color != nil {
+				pattern = NewSolidPattern(span.Color)
+			}
+		}
+		if solid, ok := pattern.(*solidPattern); ok {
+			dc.drawGlyphs(im, glyphs, x, y, image.NewUniform(solid.color))
+		} else {
+			alpha := image.NewAlpha(im.Bounds())
+			dc.drawGlyphs(alpha, glyphs, x, y, image.Opaque)
+			paintAlpha(dc.aliased(dc.painter(im, nil, pattern)), alpha)
+		}
+		if span.Underline {
+			dc.underlineSpan(im, line, i, x, y, pattern)
+		}
+	}
+}
+
+// underlineSpan draws the underline of span i of a line. The underline is
+// broken where other spans are placed between parts of the span, as happens
+// in bidirectional text.
+func (dc *Context) underlineSpan(im *image.RGBA, line *spanLine, i int, x, y float64, pattern Pattern) {
+	position, thickness := underlineMetrics(line.faces[i][0])
+	y += position - line.spans[i].BaselineShift
+	x0, x1 := 0.0, 0.0
+	open := false
+	for k, g := range line.glyphs {
+		if g.span == i {
+			gx := unfix(g.dot.X)
+			if !open {
+				x0, open = gx, true
+			}
+			x1 = gx + unfix(g.advance)
+		}
+		if open && (g.span != i || k == len(line.glyphs)-1) {
+			dc.fillRect(im, nil, x+x0, y, x1-x0, thickness, pattern)
+			open = false
+		}
+	}
+}
+
+// fillRect fills a rectangle given in user space through the optional mask
+// without touching the current path.
+func (dc *Context) fillRect(im *image.RGBA, mask *image.Alpha, x, y, w, h float64, pattern Pattern) {
+	var path raster.Path
+	start := fixp(dc.TransformPoint(x, y))
+	path.Start(start)
+	path.Add1(fixp(dc.TransformPoint(x+w, y)))
+	path.Add1(fixp(dc.TransformPoint(x+w, y+h)))
+	path.Add1(fixp(dc.TransformPoint(x, y+h)))
+	path.Add1(start)
+	r := dc.rasterizer
+	r.UseNonZeroWinding = true
+	r.Clear()
+	r.AddPath(path)
+	r.Rasterize(dc.aliased(dc.painter(im, mask, pattern)))
+}
+
+// paintAlpha paints the coverage of alpha with painter.
+func paintAlpha(painter raster.Painter, alpha *image.Alpha) {
+	b := alpha.Bounds()
+	var spans []raster.Span
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		spans = spans[:0]
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if a := alpha.AlphaAt(x, y).A; a != 0 {
+				spans = append(spans, raster.Span{Y: y, X0: x, X1: x + 1, Alpha: uint32(a) * 0x101})
+			}
+		}
+		if len(spans) > 0 {
+			painter.Paint(spans, false)
+		}
+	}
+	painter.Paint(nil, true)
+}
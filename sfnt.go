@@ -0,0 +1,82 @@
+package gg
+
+import (
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// sfntFace is a face created from a font parsed with golang.org/x/image/font/sfnt,
+// which reads TrueType and OpenType fonts with CFF outlines, and font
+// collections. Like truetypeFace it keeps the font so that missing glyphs can
+// be detected and glyph outlines loaded.
+type sfntFace struct {
+	font.Face
+	font *sfnt.Font
+	ppem fixed.Int26_6
+	buf  sfnt.Buffer
+}
+
+// parseSFNT parses font index of TrueType or OpenType font data, which may be
+// a font collection. A single font has index 0.
+func parseSFNT(data []byte, index int) (*sfnt.Font, error) {
+	c, err := sfnt.ParseCollection(data)
+	if err != nil {
+		return nil, err
+	}
+	return c.Font(index)
+}
+
+// newSFNTFace returns a face for f at the given size in points.
+func newSFNTFace(f *sfnt.Font, points float64) *sfntFace {
+	// opentype.NewFace never fails
+	face, _ := opentype.NewFace(f, &opentype.FaceOptions{Size: points, DPI: 72})
+	return &sfntFace{Face: face, font: f, ppem: fix(points)}
+}
+
+// index returns the glyph index of r, or 0 if the font has no glyph for it.
+func (f *sfntFace) index(r rune) sfnt.GlyphIndex {
+	x, err := f.font.GlyphIndex(&f.buf, r)
+	if err != nil {
+		return 0
+	}
+	return x
+}
+
+// sfntGlyphPath adds the outline of the glyph of r in f with its origin at
+// x, y to the current path. Quadratic TrueType and cubic CFF contours are
+// both supported.
+func (dc *Context) sfntGlyphPath(f *sfntFace, r rune, x, y float64) error {
+	segments, err := f.font.LoadGlyph(&f.buf, f.index(r), f.ppem, nil)
+	if err != nil {
+		return err
+	}
+	pt := func(p fixed.Point26_6) (float64, float64) {
+		return x + unfix(p.X), y + unfix(p.Y)
+	}
+	for i, s := range segments {
+		switch s.Op {
+		case sfnt.SegmentOpMoveTo:
+			if i > 0 {
+				dc.ClosePath()
+			}
+			dc.MoveTo(pt(s.Args[0]))
+		case sfnt.SegmentOpLineTo:
+			dc.LineTo(pt(s.Args[0]))
+		case sfnt.SegmentOpQuadTo:
+			x1, y1 := pt(s.Args[0])
+			x2, y2 := pt(s.Args[1])
+			dc.QuadraticTo(x1, y1, x2, y2)
+		case sfnt.SegmentOpCubeTo:
+			x1, y1 := pt(s.Args[0])
+			x2, y2 := pt(s.Args[1])
+			x3, y3 := pt(s.Args[2])
+			dc.CubicTo(x1, y1, x2, y2, x3, y3)
+		}
+	}
+	if len(segments) > 0 {
+		dc.ClosePath()
+	}
+	return nil
+}
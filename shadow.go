@@ -0,0 +1,72 @@
+package gg
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// SetShadow sets the drop shadow that is drawn beneath subsequent fills,
+// strokes, text and images, like the shadowOffsetX, shadowOffsetY,
+// shadowBlur and shadowColor properties of an HTML canvas. The offset is
+// specified in pixels and is not affected by the current matrix. blur is
+// the amount of Gaussian blur; the standard deviation used is blur / 2.
+// Shadows are clipped by the current mask and saved by Push / Pop. Use a
+// fully transparent color to disable the shadow.
+func (dc *Context) SetShadow(offsetX, offsetY, blur float64, c color.Color) {
+	dc.shadowOffsetX = offsetX
+	dc.shadowOffsetY = offsetY
+	dc.shadowBlur = blur
+	dc.shadowColor = c
+}
+
+func (dc *Context) hasShadow() bool {
+	if dc.shadowColor == nil {
+		return false
+	}
+	if _, _, _, a := dc.shadowColor.RGBA(); a == 0 {
+		return false
+	}
+	return dc.shadowOffsetX != 0 || dc.shadowOffsetY != 0 || dc.shadowBlur > 0
+}
+
+// drawShadow draws the shadow cast by the contents of layer onto the image.
+// Only the part of the image that the offset and blurred layer reaches is
+// touched.
+func (dc *Context) drawShadow(layer *image.RGBA) {
+	dx := int(math.Round(dc.shadowOffsetX))
+	dy := int(math.Round(dc.shadowOffsetY))
+	lr := layer.Bounds()
+	r := lr.Add(image.Pt(dx, dy)).Inset(-gaussianReach(dc.shadowBlur / 2)).Intersect(dc.im.Bounds())
+	if r.Empty() {
+		return
+	}
+	alpha := image.NewAlpha(r)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		sy := y - dy
+		if sy < lr.Min.Y || sy >= lr.Max.Y {
+			continue
+		}
+		for x := r.Min.X; x < r.Max.X; x++ {
+			sx := x - dx
+			if sx < lr.Min.X || sx >= lr.Max.X {
+				continue
+			}
+			alpha.Pix[alpha.PixOffset(x, y)] = layer.Pix[layer.PixOffset(sx, sy)+3]
+		}
+	}
+	gaussianBlur(alpha.Pix, r.Dx(), r.Dy(), alpha.Stride, 1, dc.shadowBlur/2)
+	if dc.mask != nil {
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				i := alpha.PixOffset(x, y)
+				a := uint32(dc.mask.Pix[dc.mask.PixOffset(x, y)])
+				alpha.Pix[i] = uint8(uint32(alpha.Pix[i]) * a / 255)
+			}
+		}
+	}
+	src := image.NewUniform(dc.shadowColor)
+	draw.DrawMask(dc.im, r, src, image.ZP, alpha, r.Min, draw.Over)
+}
@@ -0,0 +1,397 @@
+package gg
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/go-text/typesetting/di"
+	otfont "github.com/go-text/typesetting/font"
+	"github.com/go-text/typesetting/font/opentype"
+	"github.com/go-text/typesetting/font/opentype/tables"
+	"github.com/go-text/typesetting/shaping"
+	"github.com/golang/freetype/raster"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// shapedFace is a font.Face backed by an OpenType font. Text drawn or
+// measured with a shapedFace is shaped using the GSUB and GPOS tables of the
+// font, so ligatures, contextual forms, kerning and mark positioning are
+// applied. The embedded font.Face is used for operations on single runes.
+// Like the faces returned by truetype.NewFace, a shapedFace is not safe for
+// concurrent use.
+type shapedFace struct {
+	font.Face
+	font       *openTypeFont
+	ot         *otfont.Face
+	size       float64
+	shaper     shaping.HarfbuzzShaper
+	segmenter  shaping.Segmenter
+	rasterizer *raster.Rasterizer
+	bitmaps    map[otfont.GID]image.Image
+}
+
+func newShapedFace(face font.Face, f *openTypeFont, size float64) *shapedFace {
+	ot := otfont.NewFace(f.Font)
+	// select the bitmap strikes closest to the size
+	ppem := uint16(math.Ceil(size))
+	ot.SetPpem(ppem, ppem)
+	return &shapedFace{Face: face, font: f, ot: ot, size: size}
+}
+
+// openTypeFont is an OpenType font parsed for shaping, together with the
+// layered colour glyphs of its COLR and CPAL tables, which go-text does not
+// read, and its style.
+type openTypeFont struct {
+	*otfont.Font
+	colorGlyphs map[otfont.GID][]colorLayer
+	style       fontStyle
+}
+
+// parseOTFont parses font index of font data, which may be a font
+// collection, so that it can be used for shaping.
+func parseOTFont(data []byte, index int) (*openTypeFont, error) {
+	loaders, err := opentype.NewLoaders(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if index < 0 || index >= len(loaders) {
+		return nil, fmt.Errorf("gg: no font %d in font data", index)
+	}
+	f, err := otfont.NewFont(loaders[index])
+	if err != nil {
+		return nil, err
+	}
+	ld := loaders[index]
+	nameData, _ := ld.RawTable(opentype.MustNewTag("name"))
+	names, _, _ := tables.ParseName(nameData)
+	os2, _ := ld.RawTable(opentype.MustNewTag("OS/2"))
+	style := newFontStyle(func(id int) string { return names.Name(tables.NameID(id)) }, os2)
+	return &openTypeFont{Font: f, colorGlyphs: parseColorGlyphs(ld), style: style}, nil
+}
+
+// ResolveFace satisfies the shaping.Fontmap interface.
+func (f *shapedFace) ResolveFace(r rune) *otfont.Face {
+	return f.ot
+}
+
+// glyph is a glyph positioned relative to the origin of a line of text.
+// span is the index of the styled range of the text it belongs to.
+type glyph struct {
+	face    font.Face
+	id      otfont.GID
+	r       rune
+	dot     fixed.Point26_6
+	advance fixed.Int26_6
+	cluster int
+	span    int
+}
+
+// shapeString lays out s on a single line using the current font face, its
+// fallbacks, the text direction and the text spacing. It returns the
+// positioned glyphs and the advance of the whole line.
+func (dc *Context) shapeString(s string) ([]glyph, fixed.Int26_6) {
+	return dc.layoutString(dc.faces(), s)
+}
+
+// layoutLine lays out text on a single line with a single list of faces.
+func layoutLine(faces []font.Face, text []rune, direction TextDirection) ([]glyph, fixed.Int26_6) {
+	return layoutText(text, []int{len(text)}, [][]font.Face{faces}, direction)
+}
+
+// layoutText lays out text on a single line. The text is made of styled
+// ranges that end at the rune offsets in ends and use the corresponding
+// lists of faces. It is split into directional runs with the bidi algorithm,
+// then at the ends of the ranges and into runs of the first face that can
+// render them. Each run is laid out in its own direction and the runs are
+// placed from left to right in visual order. Runes that no face can render
+// are replaced in text.
+func layoutText(text []rune, ends []int, faces [][]font.Face, direction TextDirection) ([]glyph, fixed.Int26_6) {
+	start := 0
+	for i, end := range ends {
+		replaceMissing(faces[i], text[start:end])
+		start = end
+	}
+	var glyphs []glyph
+	var advance fixed.Int26_6
+	for _, run := range bidiLayout(text, direction) {
+		var parts []bidiRun
+		var spans []int
+		for i, start := 0, run.start; start < run.end; i++ {
+			if ends[i] <= start {
+				continue
+			}
+			end := ends[i]
+			if end > run.end {
+				end = run.end
+			}
+			parts = append(parts, bidiRun{start, end, run.level})
+			spans = append(spans, i)
+			start = end
+		}
+		if run.rtl() {
+			for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+				parts[i], parts[j] = parts[j], parts[i]
+				spans[i], spans[j] = spans[j], spans[i]
+			}
+		}
+		for k, part := range parts {
+			span := spans[k]
+			for _, fr := range splitByFace(faces[span], text, part) {
+				sub := bidiRun{fr.start, fr.end, run.level}
+				var g []glyph
+				var a fixed.Int26_6
+				if f, ok := faces[span][fr.face].(*shapedFace); ok {
+					g, a = f.shape(text, sub)
+				} else {
+					g, a = runeGlyphs(faces[span][fr.face], text, sub)
+				}
+				for i := range g {
+					g[i].dot.X += advance
+					g[i].span = span
+				}
+				glyphs = append(glyphs, g...)
+				advance += a
+			}
+		}
+	}
+	return glyphs, advance
+}
+
+// visualRune returns the logical index of the k-th rune of run in display
+// order, and the rune to display there, which is mirrored in right-to-left
+// runs.
+func visualRune(text []rune, run bidiRun, k int) (int, rune) {
+	if !run.rtl() {
+		i := run.start + k
+		return i, text[i]
+	}
+	i := run.end - 1 - k
+	if m, ok := mirrorRunes[text[i]]; ok {
+		return i, m
+	}
+	return i, text[i]
+}
+
+// runeGlyphs lays out a run one rune at a time, using only the legacy
+// kerning of the face.
+func runeGlyphs(face font.Face, text []rune, run bidiRun) ([]glyph, fixed.Int26_6) {
+	// based on Drawer.DrawString() in golang.org/x/image/font/font.go
+	var glyphs []glyph
+	var advance fixed.Int26_6
+	prevC := rune(-1)
+	for k := 0; k < run.end-run.start; k++ {
+		i, c := visualRune(text, run, k)
+		if prevC >= 0 {
+			advance += face.Kern(prevC, c)
+		}
+		a, ok := face.GlyphAdvance(c)
+		if !ok {
+			// invisible characters the face has no glyph for
+			continue
+		}
+		glyphs = append(glyphs, glyph{face: face, r: c, dot: fixed.Point26_6{X: advance}, advance: a, cluster: i})
+		advance += a
+		prevC = c
+	}
+	return glyphs, advance
+}
+
+// shape splits a bidi run into runs of a single script and shapes each of
+// them in the direction of the bidi run.
+func (f *shapedFace) shape(text []rune, run bidiRun) ([]glyph, fixed.Int26_6) {
+	direction := di.DirectionLTR
+	if run.rtl() {
+		direction = di.DirectionRTL
+	}
+	input := shaping.Input{
+		Text:      text,
+		RunStart:  run.start,
+		RunEnd:    run.end,
+		Direction: direction,
+		Face:      f.ot,
+		Size:      fixed.Int26_6(f.size * 64),
+	}
+	inputs := f.segmenter.Split(input, f)
+	if run.rtl() {
+		for i, j := 0, len(inputs)-1; i < j; i, j = i+1, j-1 {
+			inputs[i], inputs[j] = inputs[j], inputs[i]
+		}
+	}
+	var glyphs []glyph
+	var advance fixed.Int26_6
+	for _, in := range inputs {
+		// the levels are already resolved, so keep the direction of the run
+		in.Direction = direction
+		out := f.shaper.Shape(in)
+		for _, g := range out.Glyphs {
+			glyphs = append(glyphs, glyph{
+				face:    f,
+				id:      g.GlyphID,
+				r:       text[g.ClusterIndex],
+				dot:     fixed.Point26_6{X: advance + g.XOffset, Y: -g.YOffset},
+				advance: g.XAdvance,
+				cluster: g.ClusterIndex,
+			})
+			advance += g.XAdvance
+		}
+	}
+	return glyphs, advance
+}
+
+// mask returns the coverage mask of the glyph with its origin at dot, like
+// font.Face.Glyph.
+func (g *glyph) mask(dot fixed.Point26_6) (dr image.Rectangle, mask image.Image, maskp image.Point, ok bool) {
+	if f, isShaped := g.face.(*shapedFace); isShaped {
+		dr, alpha, ok := f.glyphMask(dot, g.id)
+		return dr, alpha, image.ZP, ok
+	}
+	dr, mask, maskp, _, ok = g.face.Glyph(dot, g.r)
+	return
+}
+
+// bounds returns the ink bounds of the glyph relative to its origin, like
+// font.Face.GlyphBounds. ok is false for glyphs without ink.
+func (g *glyph) bounds() (bounds fixed.Rectangle26_6, ok bool) {
+	if f, isShaped := g.face.(*shapedFace); isShaped {
+		e, ok := f.ot.GlyphExtents(g.id)
+		if !ok {
+			return bounds, false
+		}
+		scale := f.size / float64(f.ot.Upem())
+		bounds.Min = fixp(float64(e.XBearing)*scale, -float64(e.YBearing)*scale)
+		bounds.Max = fixp(float64(e.XBearing+e.Width)*scale, -float64(e.YBearing+e.Height)*scale)
+	} else {
+		bounds, _, ok = g.face.GlyphBounds(g.r)
+		if !ok {
+			return bounds, false
+		}
+	}
+	return bounds, bounds.Min.X < bounds.Max.X && bounds.Min.Y < bounds.Max.Y
+}
+
+// outline returns the outline of glyph id, in font units with Y growing up.
+func (f *shapedFace) outline(id otfont.GID) (otfont.GlyphOutline, bool) {
+	switch data := f.ot.GlyphData(id).(type) {
+	case otfont.GlyphOutline:
+		return data, len(data.Segments) > 0
+	case otfont.GlyphBitmap:
+		if data.Outline != nil {
+			return *data.Outline, len(data.Outline.Segments) > 0
+		}
+	case otfont.GlyphSVG:
+		return data.Outline, len(data.Outline.Segments) > 0
+	}
+	return otfont.GlyphOutline{}, false
+}
+
+// glyphMask rasterizes the outline of glyph id with its origin at dot, or
+// scales its bitmap for glyphs without outlines.
+func (f *shapedFace) glyphMask(dot fixed.Point26_6, id otfont.GID) (image.Rectangle, *image.Alpha, bool) {
+	outline, ok := f.outline(id)
+	if !ok {
+		return f.bitmapMask(dot, id)
+	}
+	scale := f.size / float64(f.ot.Upem())
+	ox, oy := unfix(dot.X), unfix(dot.Y)
+	x0, y0 := math.Inf(1), math.Inf(1)
+	x1, y1 := math.Inf(-1), math.Inf(-1)
+	for i := range outline.Segments {
+		for _, p := range outline.Segments[i].ArgsSlice() {
+			x := ox + float64(p.X)*scale
+			y := oy - float64(p.Y)*scale
+			x0, y0 = math.Min(x0, x), math.Min(y0, y)
+			x1, y1 = math.Max(x1, x), math.Max(y1, y)
+		}
+	}
+	dr := image.Rect(int(math.Floor(x0)), int(math.Floor(y0)), int(math.Ceil(x1)), int(math.Ceil(y1)))
+	w, h := dr.Dx(), dr.Dy()
+	if w <= 0 || h <= 0 {
+		return image.Rectangle{}, nil, false
+	}
+	if f.rasterizer == nil {
+		f.rasterizer = raster.NewRasterizer(w, h)
+	} else {
+		f.rasterizer.SetBounds(w, h)
+	}
+	r := f.rasterizer
+	r.Clear()
+	bx, by := ox-float64(dr.Min.X), oy-float64(dr.Min.Y)
+	addOutline(outline, func(p otfont.SegmentPoint) fixed.Point26_6 {
+		return fixp(bx+float64(p.X)*scale, by-float64(p.Y)*scale)
+	}, r)
+	mask := image.NewAlpha(image.Rect(0, 0, w, h))
+	r.Rasterize(raster.NewAlphaSrcPainter(mask))
+	return dr, mask, true
+}
+
+// pathAdder is implemented by both raster.Path and raster.Rasterizer.
+type pathAdder interface {
+	Start(a fixed.Point26_6)
+	Add1(b fixed.Point26_6)
+	Add2(b, c fixed.Point26_6)
+	Add3(b, c, d fixed.Point26_6)
+}
+
+// addOutline adds the closed contours of a glyph outline to p, mapping each
+// point with pt.
+func addOutline(outline otfont.GlyphOutline, pt func(otfont.SegmentPoint) fixed.Point26_6, p pathAdder) {
+	var start, current fixed.Point26_6
+	open := false
+	for _, s := range outline.Segments {
+		switch s.Op {
+		case opentype.SegmentOpMoveTo:
+			if open && current != start {
+				p.Add1(start)
+			}
+			start = pt(s.Args[0])
+			current = start
+			open = true
+			p.Start(start)
+		case opentype.SegmentOpLineTo:
+			current = pt(s.Args[0])
+			p.Add1(current)
+		case opentype.SegmentOpQuadTo:
+			current = pt(s.Args[1])
+			p.Add2(pt(s.Args[0]), current)
+		case opentype.SegmentOpCubeTo:
+			current = pt(s.Args[2])
+			p.Add3(pt(s.Args[0]), pt(s.Args[1]), current)
+		}
+	}
+	if open && current != start {
+		p.Add1(start)
+	}
+}
+
+// drawOutline adds a glyph outline to the current path with its origin at
+// x, y in user space.
+func (dc *Context) drawOutline(outline otfont.GlyphOutline, scale, x, y float64) {
+	pt := func(p otfont.SegmentPoint) (float64, float64) {
+		return x + float64(p.X)*scale, y - float64(p.Y)*scale
+	}
+	for i, s := range outline.Segments {
+		switch s.Op {
+		case opentype.SegmentOpMoveTo:
+			if i > 0 {
+				dc.ClosePath()
+			}
+			dc.NewSubPath()
+			dc.MoveTo(pt(s.Args[0]))
+		case opentype.SegmentOpLineTo:
+			dc.LineTo(pt(s.Args[0]))
+		case opentype.SegmentOpQuadTo:
+			x1, y1 := pt(s.Args[0])
+			x2, y2 := pt(s.Args[1])
+			dc.QuadraticTo(x1, y1, x2, y2)
+		case opentype.SegmentOpCubeTo:
+			x1, y1 := pt(s.Args[0])
+			x2, y2 := pt(s.Args[1])
+			x3, y3 := pt(s.Args[2])
+			dc.CubicTo(x1, y1, x2, y2, x3, y3)
+		}
+	}
+	dc.ClosePath()
+}
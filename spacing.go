@@ -0,0 +1,99 @@
+package gg
+
+import (
+	"math"
+	"sort"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// defaultTabSpaces is the distance between tab stops, in spaces of the
+// current font face, when no interval is set with SetTabInterval.
+const defaultTabSpaces = 8
+
+// SetLetterSpacing sets the extra space added between the characters of
+// horizontal text drawn, measured, wrapped or turned into a path, in user
+// space units. Negative values tighten the text.
+func (dc *Context) SetLetterSpacing(spacing float64) {
+	dc.letterSpacing = spacing
+}
+
+// SetWordSpacing sets the extra space added to every space of horizontal
+// text, in user space units, on top of the letter spacing.
+func (dc *Context) SetWordSpacing(spacing float64) {
+	dc.wordSpacing = spacing
+}
+
+// SetTabInterval sets the distance between the tab stops that follow the
+// ones set with SetTabStops. A tab character moves the text after it to the
+// next tab stop. Use 0, the default, for a tab stop every eight spaces of
+// the current font face.
+func (dc *Context) SetTabInterval(interval float64) {
+	dc.tabInterval = interval
+}
+
+// SetTabStops sets the positions of the tab stops, measured from the left
+// end of each line. Tab characters past the last one move to the next
+// multiple of the tab interval. Use no stops to only use the interval.
+func (dc *Context) SetTabStops(stops ...float64) {
+	dc.tabStops = append([]float64(nil), stops...)
+	sort.Float64s(dc.tabStops)
+}
+
+// nextTabStop returns the position of the first tab stop after x.
+func (dc *Context) nextTabStop(x float64) float64 {
+	for _, stop := range dc.tabStops {
+		if stop > x {
+			return stop
+		}
+	}
+	interval := dc.tabInterval
+	if interval <= 0 {
+		a, _ := dc.fontFace.GlyphAdvance(' ')
+		interval = unfix(a) * defaultTabSpaces
+	}
+	if interval <= 0 {
+		return x
+	}
+	return (math.Floor(x/interval) + 1) * interval
+}
+
+// layoutString lays out s on a single line with faces and the text
+// direction like layoutLine, then adds the letter and word spacing and
+// stretches tabs to the tab stops. Tabs are laid out as spaces.
+func (dc *Context) layoutString(faces []font.Face, s string) ([]glyph, fixed.Int26_6) {
+	text := []rune(s)
+	tabs := map[int]bool{}
+	for i, r := range text {
+		if r == '\t' {
+			text[i] = ' '
+			tabs[i] = true
+		}
+	}
+	glyphs, advance := layoutLine(faces, text, dc.textDirection)
+	if dc.letterSpacing == 0 && dc.wordSpacing == 0 && len(tabs) == 0 {
+		return glyphs, advance
+	}
+	letter, word := fix(dc.letterSpacing), fix(dc.wordSpacing)
+	var shift fixed.Int26_6
+	for i := range glyphs {
+		g := &glyphs[i]
+		// the text after a tab starts right at the tab stop
+		if i > 0 && g.cluster != glyphs[i-1].cluster && !tabs[glyphs[i-1].cluster] {
+			shift += letter
+		}
+		g.dot.X += shift
+		var extra fixed.Int26_6
+		switch {
+		case tabs[g.cluster]:
+			x := unfix(g.dot.X)
+			extra = fix(dc.nextTabStop(x)-x) - g.advance
+		case g.r == ' ' || g.r == '\u00a0':
+			extra = word
+		}
+		g.advance += extra
+		shift += extra
+	}
+	return glyphs, advance + shift
+}
@@ -0,0 +1,908 @@
+package gg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	otfont "github.com/go-text/typesetting/font"
+	"github.com/go-text/typesetting/font/opentype"
+	"github.com/goki/freetype/truetype"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// FontSubset is a TrueType font with some of the glyphs of another font,
+// such as the glyphs used by a drawing, for embedding in vector formats.
+type FontSubset struct {
+	// Data is the TrueType font. It has the outlines, advances and
+	// character map of the glyphs of the subset, and no hinting
+	// instructions, kerning or OpenType layout tables.
+	Data []byte
+
+	// GlyphIDs maps the glyph indices of the original font to the glyph
+	// indices of the subset. Glyph 0, the glyph for missing characters, is
+	// always kept.
+	GlyphIDs map[int]int
+}
+
+// SubsetTrueTypeFont returns a subset of f with the glyphs of runes and
+// the glyphs with the given indices. The subset has the names of f. Since
+// truetype does not read the OS/2 table, its weight and style are guessed
+// from the subfamily name and its embedding is not restricted; use
+// SubsetSFNTFont or SubsetFontFace to keep them.
+func SubsetTrueTypeFont(f *truetype.Font, runes []rune, glyphs []int) (*FontSubset, error) {
+	return subsetFont(&truetypeSource{font: f}, runes, glyphs)
+}
+
+// SubsetSFNTFont is like SubsetTrueTypeFont for fonts parsed with
+// golang.org/x/image/font/sfnt. The cubic outlines of CFF fonts are
+// approximated with quadratic curves.
+func SubsetSFNTFont(f *sfnt.Font, runes []rune, glyphs []int) (*FontSubset, error) {
+	return subsetFont(&sfntSource{font: f}, runes, glyphs)
+}
+
+// SubsetFontFace is like SubsetTrueTypeFont for the font of a face
+// returned by LoadFontFace, or set with LoadFont and SetFontSize. Glyph
+// indices are those of the glyphs laid out by OpenType shaping, and the
+// outlines of variable fonts are those of the font variations of the face.
+func SubsetFontFace(face font.Face, runes []rune, glyphs []int) (*FontSubset, error) {
+	src, ok := newSubsetSource(face)
+	if !ok {
+		return nil, fmt.Errorf("gg: cannot subset font face %T", face)
+	}
+	return subsetFont(src, runes, glyphs)
+}
+
+// GlyphSet is a set of glyphs of a font face recorded while drawing text.
+type GlyphSet struct {
+	Face font.Face
+
+	// Runes are the characters drawn with the face.
+	Runes []rune
+
+	// Glyphs are the indices of the glyphs drawn with the face, for faces
+	// that lay out text with OpenType shaping.
+	Glyphs []int
+}
+
+// Subset returns a subset of the font of the face of s with its glyphs.
+func (s GlyphSet) Subset() (*FontSubset, error) {
+	return SubsetFontFace(s.Face, s.Runes, s.Glyphs)
+}
+
+// glyphUsage records the glyphs drawn on a context, by face.
+type glyphUsage struct {
+	faces  []font.Face
+	runes  map[font.Face]map[rune]bool
+	glyphs map[font.Face]map[int]bool
+}
+
+// RecordGlyphs starts recording the glyphs drawn by DrawString and the
+// other text drawing functions, forgetting those recorded before, or
+// stops recording. Only the glyphs of faces that SubsetFontFace accepts are
+// recorded.
+func (dc *Context) RecordGlyphs(record bool) {
+	dc.usedGlyphs = nil
+	if record {
+		dc.usedGlyphs = &glyphUsage{
+			runes:  map[font.Face]map[rune]bool{},
+			glyphs: map[font.Face]map[int]bool{},
+		}
+	}
+}
+
+// RecordedGlyphs returns the glyphs drawn since RecordGlyphs was called,
+// with a set for each face in the order they were first used.
+func (dc *Context) RecordedGlyphs() []GlyphSet {
+	u := dc.usedGlyphs
+	if u == nil {
+		return nil
+	}
+	var sets []GlyphSet
+	for _, face := range u.faces {
+		set := GlyphSet{Face: face}
+		for r := range u.runes[face] {
+			set.Runes = append(set.Runes, r)
+		}
+		for id := range u.glyphs[face] {
+			set.Glyphs = append(set.Glyphs, id)
+		}
+		sort.Slice(set.Runes, func(i, j int) bool { return set.Runes[i] < set.Runes[j] })
+		sort.Ints(set.Glyphs)
+		sets = append(sets, set)
+	}
+	return sets
+}
+
+// recordGlyph adds g to the glyphs recorded with RecordGlyphs.
+func (dc *Context) recordGlyph(g glyph) {
+	u := dc.usedGlyphs
+	if _, ok := newSubsetSource(g.face); !ok {
+		return
+	}
+	if u.runes[g.face] == nil {
+		u.faces = append(u.faces, g.face)
+		u.runes[g.face] = map[rune]bool{}
+		u.glyphs[g.face] = map[int]bool{}
+	}
+	if !ignorable(g.r) {
+		u.runes[g.face][g.r] = true
+	}
+	if _, ok := g.face.(*shapedFace); ok {
+		u.glyphs[g.face][int(g.id)] = true
+	}
+}
+
+// subsetSource gives the glyphs and metrics of a font in font units, with
+// Y growing up.
+type subsetSource interface {
+	unitsPerEm() int
+	// index returns the glyph index of r, or 0 if the font has no glyph
+	// for it.
+	index(r rune) int
+	glyph(id int) (subsetGlyph, error)
+	// metrics returns the ascent, the descent, which is negative, and the
+	// line gap of the font.
+	metrics() (ascent, descent, lineGap int)
+	style() fontStyle
+}
+
+// fontStyle is the style of a font that its subsets keep: its names, and
+// the weight, style flags and embedding permissions of its OS/2 table.
+type fontStyle struct {
+	family, subfamily         string
+	typoFamily, typoSubfamily string
+	weight                    int
+	fsType, fsSelection       int
+}
+
+// newFontStyle returns the style of a font with the names given by name ID
+// and the OS/2 table os2. Without an OS/2 table, the weight and the style
+// flags are taken from the subfamily name and embedding is not restricted.
+func newFontStyle(name func(id int) string, os2 []byte) fontStyle {
+	s := fontStyle{family: name(1), subfamily: name(2), typoFamily: name(16), typoSubfamily: name(17)}
+	if len(os2) >= 64 {
+		be := binary.BigEndian
+		s.weight = int(be.Uint16(os2[4:]))
+		s.fsType = int(be.Uint16(os2[8:]))
+		s.fsSelection = int(be.Uint16(os2[62:]))
+		return s
+	}
+	subfamily := strings.ToLower(s.subfamily)
+	s.weight = 400
+	if strings.Contains(subfamily, "bold") {
+		s.weight = 700
+		s.fsSelection |= 0x20
+	}
+	if strings.Contains(subfamily, "italic") || strings.Contains(subfamily, "oblique") {
+		s.fsSelection |= 0x01
+	}
+	if s.fsSelection == 0 {
+		s.fsSelection = 0x40
+	}
+	return s
+}
+
+// sfntTable returns the table of the font in data with the given tag, or
+// nil if it has none.
+func sfntTable(data []byte, tag string) []byte {
+	be := binary.BigEndian
+	if len(data) < 12 {
+		return nil
+	}
+	for i := 0; i < int(be.Uint16(data[4:])) && 28+16*i <= len(data); i++ {
+		rec := data[12+16*i:]
+		if string(rec[:4]) != tag {
+			continue
+		}
+		offset, length := uint64(be.Uint32(rec[8:])), uint64(be.Uint32(rec[12:]))
+		if offset+length > uint64(len(data)) {
+			return nil
+		}
+		return data[offset : offset+length]
+	}
+	return nil
+}
+
+// newSubsetSource returns the source of the glyphs of the font of face.
+// ok is false for faces without a font that can be subset.
+func newSubsetSource(face font.Face) (src subsetSource, ok bool) {
+	switch f := face.(type) {
+	case *shapedFace:
+		return &shapedSource{face: f}, true
+	case *truetypeFace:
+		return &truetypeSource{font: f.font}, true
+	case *sfntFace:
+		return &sfntSource{font: f.font}, true
+	}
+	return nil, false
+}
+
+// subsetPoint is a point of a TrueType contour.
+type subsetPoint struct {
+	x, y    int
+	onCurve bool
+}
+
+// subsetGlyph is the outline of a glyph as TrueType contours, and its
+// advance.
+type subsetGlyph struct {
+	contours [][]subsetPoint
+	advance  int
+}
+
+// truetypeSource reads glyphs from a font parsed with truetype.
+type truetypeSource struct {
+	font *truetype.Font
+	buf  truetype.GlyphBuf
+}
+
+func (s *truetypeSource) unitsPerEm() int {
+	return int(s.font.FUnitsPerEm())
+}
+
+func (s *truetypeSource) index(r rune) int {
+	return int(s.font.Index(r))
+}
+
+func (s *truetypeSource) glyph(id int) (subsetGlyph, error) {
+	// at a scale of one em per font unit, points are in font units
+	if err := s.buf.Load(s.font, fixed.Int26_6(s.font.FUnitsPerEm()), truetype.Index(id), font.HintingNone); err != nil {
+		return subsetGlyph{}, err
+	}
+	g := subsetGlyph{advance: int(s.buf.AdvanceWidth)}
+	start := 0
+	for _, end := range s.buf.Ends {
+		var contour []subsetPoint
+		for _, p := range s.buf.Points[start:end] {
+			contour = append(contour, subsetPoint{int(p.X), int(p.Y), p.Flags&1 != 0})
+		}
+		g.contours = append(g.contours, contour)
+		start = end
+	}
+	return g, nil
+}
+
+func (s *truetypeSource) metrics() (ascent, descent, lineGap int) {
+	face := truetype.NewFace(s.font, &truetype.Options{Size: float64(s.font.FUnitsPerEm())})
+	return faceMetrics(face.Metrics())
+}
+
+// style returns the style of the font from its names only, since truetype
+// does not give access to the OS/2 table.
+func (s *truetypeSource) style() fontStyle {
+	return newFontStyle(func(id int) string { return s.font.Name(truetype.NameID(id)) }, nil)
+}
+
+// faceMetrics returns the metrics of a face with a size of one pixel per
+// font unit, in font units, like subsetSource.metrics.
+func faceMetrics(m font.Metrics) (ascent, descent, lineGap int) {
+	lineGap = (m.Height - m.Ascent - m.Descent).Round()
+	if lineGap < 0 {
+		lineGap = 0
+	}
+	return m.Ascent.Round(), -m.Descent.Round(), lineGap
+}
+
+// sfntSource reads glyphs from a font parsed with sfnt.
+type sfntSource struct {
+	font *sfnt.Font
+	buf  sfnt.Buffer
+}
+
+func (s *sfntSource) unitsPerEm() int {
+	return int(s.font.UnitsPerEm())
+}
+
+// ppem returns the size at which the font has one pixel per font unit.
+func (s *sfntSource) ppem() fixed.Int26_6 {
+	return fixed.I(s.unitsPerEm())
+}
+
+func (s *sfntSource) index(r rune) int {
+	x, err := s.font.GlyphIndex(&s.buf, r)
+	if err != nil {
+		return 0
+	}
+	return int(x)
+}
+
+func (s *sfntSource) glyph(id int) (subsetGlyph, error) {
+	segments, err := s.font.LoadGlyph(&s.buf, sfnt.GlyphIndex(id), s.ppem(), nil)
+	if err != nil {
+		return subsetGlyph{}, err
+	}
+	advance, err := s.font.GlyphAdvance(&s.buf, sfnt.GlyphIndex(id), s.ppem(), font.HintingNone)
+	if err != nil {
+		return subsetGlyph{}, err
+	}
+	var c contourBuilder
+	pt := func(p fixed.Point26_6) Point {
+		return Point{unfix(p.X), -unfix(p.Y)}
+	}
+	for _, seg := range segments {
+		switch seg.Op {
+		case sfnt.SegmentOpMoveTo:
+			c.moveTo(pt(seg.Args[0]))
+		case sfnt.SegmentOpLineTo:
+			c.lineTo(pt(seg.Args[0]))
+		case sfnt.SegmentOpQuadTo:
+			c.quadTo(pt(seg.Args[0]), pt(seg.Args[1]))
+		case sfnt.SegmentOpCubeTo:
+			c.cubeTo(pt(seg.Args[0]), pt(seg.Args[1]), pt(seg.Args[2]))
+		}
+	}
+	return subsetGlyph{contours: c.close(), advance: advance.Round()}, nil
+}
+
+func (s *sfntSource) metrics() (ascent, descent, lineGap int) {
+	m, err := s.font.Metrics(&s.buf, s.ppem(), font.HintingNone)
+	if err != nil {
+		return 0, 0, 0
+	}
+	return faceMetrics(m)
+}
+
+func (s *sfntSource) style() fontStyle {
+	// sfnt does not read the OS/2 table, which is taken from the source of
+	// the font; fonts of collections have no source of their own
+	var data bytes.Buffer
+	var os2 []byte
+	if _, err := s.font.WriteSourceTo(&s.buf, &data); err == nil {
+		os2 = sfntTable(data.Bytes(), "OS/2")
+	}
+	return newFontStyle(func(id int) string {
+		name, _ := s.font.Name(&s.buf, sfnt.NameID(id))
+		return name
+	}, os2)
+}
+
+// shapedSource reads glyphs from the OpenType font of a shaped face.
+type shapedSource struct {
+	face *shapedFace
+}
+
+func (s *shapedSource) unitsPerEm() int {
+	return int(s.face.ot.Upem())
+}
+
+func (s *shapedSource) index(r rune) int {
+	id, _ := s.face.ot.NominalGlyph(r)
+	return int(id)
+}
+
+func (s *shapedSource) glyph(id int) (subsetGlyph, error) {
+	g := subsetGlyph{advance: int(math.Round(float64(s.face.ot.HorizontalAdvance(otfont.GID(id)))))}
+	outline, ok := s.face.outline(otfont.GID(id))
+	if !ok {
+		return g, nil
+	}
+	var c contourBuilder
+	pt := func(p opentype.SegmentPoint) Point {
+		return Point{float64(p.X), float64(p.Y)}
+	}
+	for _, seg := range outline.Segments {
+		switch seg.Op {
+		case opentype.SegmentOpMoveTo:
+			c.moveTo(pt(seg.Args[0]))
+		case opentype.SegmentOpLineTo:
+			c.lineTo(pt(seg.Args[0]))
+		case opentype.SegmentOpQuadTo:
+			c.quadTo(pt(seg.Args[0]), pt(seg.Args[1]))
+		case opentype.SegmentOpCubeTo:
+			c.cubeTo(pt(seg.Args[0]), pt(seg.Args[1]), pt(seg.Args[2]))
+		}
+	}
+	g.contours = c.close()
+	return g, nil
+}
+
+func (s *shapedSource) metrics() (ascent, descent, lineGap int) {
+	e, _ := s.face.ot.FontHExtents()
+	round := func(x float32) int { return int(math.Round(float64(x))) }
+	return round(e.Ascender), round(e.Descender), round(e.LineGap)
+}
+
+func (s *shapedSource) style() fontStyle {
+	return s.face.font.style
+}
+
+// contourBuilder turns outlines made of lines and quadratic and cubic
+// Bézier curves into TrueType contours. Cubic curves are approximated with
+// quadratic curves to within half a font unit.
+type contourBuilder struct {
+	contours [][]contourPoint
+	current  Point
+}
+
+// contourPoint is a point of a contour before it is rounded to font units.
+type contourPoint struct {
+	Point
+	onCurve bool
+}
+
+func (c *contourBuilder) add(p Point, onCurve bool) {
+	i := len(c.contours) - 1
+	c.contours[i] = append(c.contours[i], contourPoint{p, onCurve})
+	if onCurve {
+		c.current = p
+	}
+}
+
+func (c *contourBuilder) moveTo(p Point) {
+	c.contours = append(c.contours, nil)
+	c.add(p, true)
+}
+
+func (c *contourBuilder) lineTo(p Point) {
+	c.add(p, true)
+}
+
+func (c *contourBuilder) quadTo(p1, p2 Point) {
+	c.add(p1, false)
+	c.add(p2, true)
+}
+
+func (c *contourBuilder) cubeTo(p1, p2, p3 Point) {
+	p := [4]Point{c.current, p1, p2, p3}
+	// the distance between a cubic curve and the quadratic curve with the
+	// control point below is at most √3/36 |p3 - 3p2 + 3p1 - p0|, which
+	// splitting the curve in n pieces divides by n³
+	dx := p3.X - 3*p2.X + 3*p1.X - p[0].X
+	dy := p3.Y - 3*p2.Y + 3*p1.Y - p[0].Y
+	n := int(math.Ceil(math.Cbrt(math.Sqrt(3) / 36 * math.Hypot(dx, dy) / 0.5)))
+	if n < 1 {
+		n = 1
+	} else if n > 16 {
+		n = 16
+	}
+	for i := 0; i < n; i++ {
+		q := splitCubic(p, float64(i)/float64(n), float64(i+1)/float64(n))
+		control := Point{
+			(3*(q[1].X+q[2].X) - q[0].X - q[3].X) / 4,
+			(3*(q[1].Y+q[2].Y) - q[0].Y - q[3].Y) / 4,
+		}
+		c.quadTo(control, q[3])
+	}
+}
+
+// close returns the contours rounded to font units. Like in TrueType
+// fonts, the last point of contours that end where they start is left out,
+// and so are the points on the curve halfway between two control points,
+// which are implied.
+func (c *contourBuilder) close() [][]subsetPoint {
+	var contours [][]subsetPoint
+	for _, contour := range c.contours {
+		if n := len(contour); n > 1 && contour[n-1] == contour[0] {
+			contour = contour[:n-1]
+		}
+		var points []subsetPoint
+		for i, p := range contour {
+			if i > 0 && i < len(contour)-1 && p.onCurve {
+				before, after := contour[i-1], contour[i+1]
+				if !before.onCurve && !after.onCurve && before.Interpolate(after.Point, 0.5) == p.Point {
+					continue
+				}
+			}
+			points = append(points, subsetPoint{int(math.Round(p.X)), int(math.Round(p.Y)), p.onCurve})
+		}
+		if len(points) > 1 {
+			contours = append(contours, points)
+		}
+	}
+	return contours
+}
+
+// splitCubic returns the control points of the part of the cubic Bézier
+// curve p between t0 and t1.
+func splitCubic(p [4]Point, t0, t1 float64) [4]Point {
+	// split returns the parts of a curve before and after t
+	split := func(p [4]Point, t float64) (before, after [4]Point) {
+		a, b, c := p[0].Interpolate(p[1], t), p[1].Interpolate(p[2], t), p[2].Interpolate(p[3], t)
+		d, e := a.Interpolate(b, t), b.Interpolate(c, t)
+		f := d.Interpolate(e, t)
+		return [4]Point{p[0], a, d, f}, [4]Point{f, e, c, p[3]}
+	}
+	p, _ = split(p, t1)
+	if t1 > 0 {
+		_, p = split(p, t0/t1)
+	}
+	return p
+}
+
+// subsetFont builds a TrueType font with glyph 0, the glyphs of runes and
+// the given glyphs of src.
+func subsetFont(src subsetSource, runes []rune, glyphs []int) (*FontSubset, error) {
+	keep := map[int]bool{0: true}
+	cmap := map[rune]int{}
+	for _, r := range runes {
+		if id := src.index(r); id != 0 {
+			keep[id] = true
+			cmap[r] = id
+		}
+	}
+	for _, id := range glyphs {
+		if id < 0 || id > 0xffff {
+			return nil, fmt.Errorf("gg: invalid glyph index %d", id)
+		}
+		keep[id] = true
+	}
+	ids := make([]int, 0, len(keep))
+	for id := range keep {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	subset := &FontSubset{GlyphIDs: map[int]int{}}
+	var outlines []subsetGlyph
+	for i, id := range ids {
+		g, err := src.glyph(id)
+		if err != nil {
+			return nil, fmt.Errorf("gg: glyph %d: %v", id, err)
+		}
+		outlines = append(outlines, g)
+		subset.GlyphIDs[id] = i
+	}
+	for r, id := range cmap {
+		cmap[r] = subset.GlyphIDs[id]
+	}
+	subset.Data = writeTrueType(src, outlines, cmap)
+	return subset, nil
+}
+
+// sfntWriter writes big-endian values, as used by TrueType fonts.
+type sfntWriter struct {
+	bytes.Buffer
+}
+
+func (w *sfntWriter) put(values ...interface{}) {
+	for _, v := range values {
+		binary.Write(w, binary.BigEndian, v)
+	}
+}
+
+// pad pads w to a multiple of four bytes.
+func (w *sfntWriter) pad() {
+	w.Write(make([]byte, (4-w.Len()%4)%4))
+}
+
+// encodedGlyph is a glyph encoded for the glyf table, with its bounds.
+type encodedGlyph struct {
+	data                   []byte
+	xMin, yMin, xMax, yMax int
+	points                 int
+}
+
+// encodeGlyph encodes the contours of g as a simple glyph. Glyphs without
+// contours have no data.
+func encodeGlyph(g subsetGlyph) encodedGlyph {
+	var e encodedGlyph
+	if len(g.contours) == 0 {
+		return e
+	}
+	e.xMin, e.yMin = math.MaxInt32, math.MaxInt32
+	e.xMax, e.yMax = math.MinInt32, math.MinInt32
+	var flags []byte
+	var xs, ys sfntWriter
+	var ends []uint16
+	var x, y int
+	// coordinates are relative to the previous point, in one byte when
+	// they fit, and left out when they are the same
+	delta := func(d int, short, same byte, w *sfntWriter) byte {
+		switch {
+		case d == 0:
+			return same
+		case d > -256 && d < 256:
+			if d > 0 {
+				w.put(uint8(d))
+				return short | same
+			}
+			w.put(uint8(-d))
+			return short
+		}
+		w.put(int16(d))
+		return 0
+	}
+	for _, contour := range g.contours {
+		for _, p := range contour {
+			var flag byte
+			if p.onCurve {
+				flag = 1
+			}
+			flag |= delta(p.x-x, 0x02, 0x10, &xs)
+			flag |= delta(p.y-y, 0x04, 0x20, &ys)
+			flags = append(flags, flag)
+			x, y = p.x, p.y
+			e.xMin, e.yMin = minInt(e.xMin, x), minInt(e.yMin, y)
+			e.xMax, e.yMax = maxInt(e.xMax, x), maxInt(e.yMax, y)
+			e.points++
+		}
+		ends = append(ends, uint16(e.points-1))
+	}
+	var w sfntWriter
+	w.put(int16(len(g.contours)), int16(e.xMin), int16(e.yMin), int16(e.xMax), int16(e.yMax))
+	w.put(ends, uint16(0), flags)
+	w.Write(xs.Bytes())
+	w.Write(ys.Bytes())
+	w.pad()
+	e.data = w.Bytes()
+	return e
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// writeTrueType returns a TrueType font with the glyphs, the character map
+// and the metrics, names and style of src.
+func writeTrueType(src subsetSource, glyphs []subsetGlyph, cmap map[rune]int) []byte {
+	upem := src.unitsPerEm()
+	ascent, descent, lineGap := src.metrics()
+	style := src.style()
+	family, subfamily := style.family, style.subfamily
+	if family == "" {
+		family = "Subset"
+	}
+	if subfamily == "" {
+		subfamily = "Regular"
+	}
+	macStyle := 0
+	if style.fsSelection&0x20 != 0 {
+		macStyle |= 1 // bold
+	}
+	if style.fsSelection&0x01 != 0 {
+		macStyle |= 2 // italic
+	}
+
+	var glyf, loca, hmtx sfntWriter
+	xMin, yMin := math.MaxInt32, math.MaxInt32
+	xMax, yMax := math.MinInt32, math.MinInt32
+	maxAdvance, maxPoints, maxContours := 0, 0, 0
+	minLSB, minRSB, maxExtent := math.MaxInt32, math.MaxInt32, math.MinInt32
+	for _, g := range glyphs {
+		e := encodeGlyph(g)
+		loca.put(uint32(glyf.Len()))
+		glyf.Write(e.data)
+		hmtx.put(uint16(g.advance), int16(e.xMin))
+		maxAdvance = maxInt(maxAdvance, g.advance)
+		if e.data == nil {
+			continue
+		}
+		xMin, yMin = minInt(xMin, e.xMin), minInt(yMin, e.yMin)
+		xMax, yMax = maxInt(xMax, e.xMax), maxInt(yMax, e.yMax)
+		maxPoints = maxInt(maxPoints, e.points)
+		maxContours = maxInt(maxContours, len(g.contours))
+		minLSB = minInt(minLSB, e.xMin)
+		minRSB = minInt(minRSB, g.advance-e.xMax)
+		maxExtent = maxInt(maxExtent, e.xMax)
+	}
+	loca.put(uint32(glyf.Len()))
+	if xMin > xMax {
+		// no glyph has contours
+		xMin, yMin, xMax, yMax = 0, 0, 0, 0
+		minLSB, minRSB, maxExtent = 0, 0, 0
+	}
+
+	var head sfntWriter
+	head.put(uint32(0x00010000), uint32(0x00010000), uint32(0), uint32(0x5f0f3cf5))
+	// the baseline is at y = 0 and the left side bearing point at x = 0
+	head.put(uint16(3), uint16(upem), int64(0), int64(0))
+	head.put(int16(xMin), int16(yMin), int16(xMax), int16(yMax))
+	// mac style, smallest readable size, direction hint, long loca
+	// offsets and glyph data format
+	head.put(uint16(macStyle), uint16(8), int16(2), int16(1), int16(0))
+
+	var hhea sfntWriter
+	hhea.put(uint32(0x00010000), int16(ascent), int16(descent), int16(lineGap))
+	hhea.put(uint16(maxAdvance), int16(minLSB), int16(minRSB), int16(maxExtent))
+	hhea.put(int16(1), int16(0), int16(0), [4]int16{}, int16(0), uint16(len(glyphs)))
+
+	var maxp sfntWriter
+	maxp.put(uint32(0x00010000), uint16(len(glyphs)), uint16(maxPoints), uint16(maxContours))
+	// no composite glyphs, two zones and no instructions
+	maxp.put(uint16(0), uint16(0), uint16(2), [8]uint16{})
+
+	var post sfntWriter
+	// version 3 has no glyph names
+	post.put(uint32(0x00030000), int32(0), int16(-upem/10), int16(upem/20), [5]uint32{})
+
+	runes := make([]rune, 0, len(cmap))
+	for r := range cmap {
+		runes = append(runes, r)
+	}
+	sort.Slice(runes, func(i, j int) bool { return runes[i] < runes[j] })
+
+	var os2 sfntWriter
+	avgWidth := 0
+	for _, g := range glyphs {
+		avgWidth += g.advance
+	}
+	avgWidth /= len(glyphs)
+	first, last := 0xffff, 0
+	if len(runes) > 0 {
+		first, last = minInt(int(runes[0]), 0xffff), minInt(int(runes[len(runes)-1]), 0xffff)
+	}
+	scripts := upem * 65 / 100
+	os2.put(uint16(4), int16(avgWidth), uint16(style.weight), uint16(5), uint16(style.fsType))
+	os2.put(int16(scripts), int16(scripts), int16(0), int16(upem*14/100))
+	os2.put(int16(scripts), int16(scripts), int16(0), int16(upem*48/100))
+	os2.put(int16(upem/20), int16(upem*26/100), int16(0), [10]uint8{}, [4]uint32{}, []byte("    "))
+	// the style of src, with the typographic metrics as the line metrics
+	os2.put(uint16(style.fsSelection|0x80), uint16(first), uint16(last))
+	os2.put(int16(ascent), int16(descent), int16(lineGap), uint16(maxInt(ascent, yMax)), uint16(maxInt(-descent, -yMin)))
+	os2.put([2]uint32{}, int16(0), int16(0), uint16(0), uint16(' '), uint16(0))
+
+	postScript := func(s string) string {
+		return strings.Map(func(r rune) rune {
+			if r <= ' ' || r >= 0x7f || strings.ContainsRune("[](){}<>/%", r) {
+				return -1
+			}
+			return r
+		}, s)
+	}
+	fullName, psName := family, postScript(family)
+	if subfamily != "Regular" {
+		fullName += " " + subfamily
+		psName += "-" + postScript(subfamily)
+	}
+	tables := map[string][]byte{
+		"OS/2": os2.Bytes(),
+		"cmap": writeCmap(runes, cmap),
+		"glyf": glyf.Bytes(),
+		"head": head.Bytes(),
+		"hhea": hhea.Bytes(),
+		"hmtx": hmtx.Bytes(),
+		"loca": loca.Bytes(),
+		"maxp": maxp.Bytes(),
+		"name": writeNames([]string{
+			1: family, 2: subfamily, 3: psName, 4: fullName, 6: psName,
+			16: style.typoFamily, 17: style.typoSubfamily,
+		}),
+		"post": post.Bytes(),
+	}
+	return writeSFNT(tables)
+}
+
+// writeCmap returns a cmap table mapping the sorted runes to glyphs, with a
+// format 4 subtable for the Basic Multilingual Plane and a format 12
+// subtable for all of Unicode.
+func writeCmap(runes []rune, cmap map[rune]int) []byte {
+	// runs of consecutive runes with consecutive glyphs
+	type run struct{ start, end, glyph int }
+	var runs []run
+	for _, r := range runes {
+		n := len(runs)
+		if n > 0 && int(r) == runs[n-1].end+1 && cmap[r] == runs[n-1].glyph+int(r)-runs[n-1].start {
+			runs[n-1].end++
+			continue
+		}
+		runs = append(runs, run{int(r), int(r), cmap[r]})
+	}
+
+	var bmp []run
+	for _, r := range runs {
+		if r.start <= 0xfffe {
+			r.end = minInt(r.end, 0xfffe)
+			bmp = append(bmp, r)
+		}
+	}
+	// the last segment maps 0xffff to glyph 0
+	bmp = append(bmp, run{0xffff, 0xffff, 0})
+	segments := len(bmp)
+	searchRange, entrySelector := 2, 0
+	for searchRange*2 <= segments*2 {
+		searchRange *= 2
+		entrySelector++
+	}
+	var format4 sfntWriter
+	format4.put(uint16(4), uint16(16+8*segments), uint16(0))
+	format4.put(uint16(segments*2), uint16(searchRange), uint16(entrySelector), uint16(segments*2-searchRange))
+	for _, r := range bmp {
+		format4.put(uint16(r.end))
+	}
+	format4.put(uint16(0))
+	for _, r := range bmp {
+		format4.put(uint16(r.start))
+	}
+	for _, r := range bmp {
+		format4.put(uint16(r.glyph - r.start))
+	}
+	for range bmp {
+		format4.put(uint16(0))
+	}
+
+	var format12 sfntWriter
+	format12.put(uint16(12), uint16(0), uint32(16+12*len(runs)), uint32(0), uint32(len(runs)))
+	for _, r := range runs {
+		format12.put(uint32(r.start), uint32(r.end), uint32(r.glyph))
+	}
+
+	var w sfntWriter
+	// Windows Unicode BMP and full repertoire encodings
+	w.put(uint16(0), uint16(2))
+	w.put(uint16(3), uint16(1), uint32(20))
+	w.put(uint16(3), uint16(10), uint32(20+format4.Len()))
+	w.Write(format4.Bytes())
+	w.Write(format12.Bytes())
+	return w.Bytes()
+}
+
+// writeNames returns a name table with the non-empty names, indexed by
+// name ID, for the Windows platform in English.
+func writeNames(names []string) []byte {
+	var records, text sfntWriter
+	count := 0
+	for id, name := range names {
+		if name == "" {
+			continue
+		}
+		s := utf16.Encode([]rune(name))
+		records.put(uint16(3), uint16(1), uint16(0x409), uint16(id), uint16(2*len(s)), uint16(text.Len()))
+		text.put(s)
+		count++
+	}
+	var w sfntWriter
+	w.put(uint16(0), uint16(count), uint16(6+records.Len()))
+	w.Write(records.Bytes())
+	w.Write(text.Bytes())
+	return w.Bytes()
+}
+
+// writeSFNT returns a font with the tables, whose tags are their keys.
+func writeSFNT(tables map[string][]byte) []byte {
+	tags := make([]string, 0, len(tables))
+	for tag := range tables {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	checksum := func(data []byte) uint32 {
+		var sum uint32
+		for i := 0; i < len(data); i += 4 {
+			var word [4]byte
+			copy(word[:], data[i:])
+			sum += binary.BigEndian.Uint32(word[:])
+		}
+		return sum
+	}
+	searchRange, entrySelector := 16, 0
+	for searchRange*2 <= len(tags)*16 {
+		searchRange *= 2
+		entrySelector++
+	}
+	var w sfntWriter
+	w.put(uint32(0x00010000), uint16(len(tags)), uint16(searchRange), uint16(entrySelector), uint16(len(tags)*16-searchRange))
+	offset := 12 + 16*len(tags)
+	for _, tag := range tags {
+		data := tables[tag]
+		w.Write([]byte(tag))
+		w.put(checksum(data), uint32(offset), uint32(len(data)))
+		offset += (len(data) + 3) &^ 3
+	}
+	headOffset := 0
+	for _, tag := range tags {
+		if tag == "head" {
+			headOffset = w.Len()
+		}
+		w.Write(tables[tag])
+		w.pad()
+	}
+	data := w.Bytes()
+	// the whole font sums to 0xb1b0afba
+	binary.BigEndian.PutUint32(data[headOffset+8:], 0xb1b0afba-checksum(data))
+	return data
+}
@@ -0,0 +1,274 @@
+package svg
+
+import (
+	"image/color"
+	"math"
+
+	"github.com/fogleman/gg"
+)
+
+// The values of the spreadMethod attribute of gradients.
+const (
+	spreadPad = iota
+	spreadReflect
+	spreadRepeat
+)
+
+// maxGradientRefs is the longest chain of gradients whose attributes and
+// stops are inherited through their href attribute.
+const maxGradientRefs = 8
+
+// gradientStop is a stop of a gradient, with its color premultiplied by
+// its alpha.
+type gradientStop struct {
+	offset     float64
+	r, g, b, a float64
+}
+
+// gradient is the gg.Pattern of an SVG linear or radial gradient. It is
+// evaluated at the centers of device pixels, so it is drawn with the
+// identity matrix.
+type gradient struct {
+	// inverse maps device space to the space of the gradient vector or
+	// circles
+	inverse gg.Matrix
+	radial  bool
+	// x1, y1, x2, y2 is the vector of linear gradients
+	x1, y1, x2, y2 float64
+	// cx, cy, r is the end circle of radial gradients, and fx, fy, fr their
+	// focal circle
+	cx, cy, r, fx, fy, fr float64
+	spread                int
+	stops                 []gradientStop
+}
+
+// gradient returns the pattern of the linearGradient or radialGradient
+// element n with opacity, for a shape with bounds b in the user space of
+// the matrix m, or nil when nothing is painted.
+func (r *renderer) gradient(n *node, opacity float64, b box, m gg.Matrix) gg.Pattern {
+	// gradients inherit the attributes and stops they do not have from the
+	// gradient they reference
+	chain := []*node{n}
+	for len(chain) < maxGradientRefs {
+		ref := r.doc.ids[parseRef(chain[len(chain)-1].attrs["href"])]
+		if ref == nil || (ref.name != "linearGradient" && ref.name != "radialGradient") {
+			break
+		}
+		chain = append(chain, ref)
+	}
+	attr := func(name string) (string, bool) {
+		for _, g := range chain {
+			if v, ok := g.attrs[name]; ok {
+				return v, true
+			}
+		}
+		return "", false
+	}
+	var stops []gradientStop
+	for _, g := range chain {
+		if stops = gradientStops(g, opacity); len(stops) > 0 {
+			break
+		}
+	}
+	switch len(stops) {
+	case 0:
+		return nil
+	case 1:
+		return gg.NewSolidPattern(stops[0].color())
+	}
+	// gradients without length or radius are painted with their last stop
+	last := gg.NewSolidPattern(stops[len(stops)-1].color())
+
+	// lengths of objectBoundingBox gradients are fractions of the bounds
+	units := gg.Identity()
+	boundingBox := true
+	if v, _ := attr("gradientUnits"); v == "userSpaceOnUse" {
+		boundingBox = false
+	}
+	if boundingBox {
+		if b.x1 == b.x0 || b.y1 == b.y0 {
+			return nil
+		}
+		units = gg.Scale(b.x1-b.x0, b.y1-b.y0).Multiply(gg.Translate(b.x0, b.y0))
+	}
+	length := func(name, def string, ref float64) float64 {
+		v, ok := attr(name)
+		if !ok {
+			v = def
+		}
+		if boundingBox {
+			ref = 1
+		}
+		x, ok := parseLength(v, ref, defaultFontSize)
+		if !ok {
+			x, _ = parseLength(def, ref, defaultFontSize)
+		}
+		return x
+	}
+
+	g := &gradient{stops: stops}
+	switch v, _ := attr("spreadMethod"); v {
+	case "reflect":
+		g.spread = spreadReflect
+	case "repeat":
+		g.spread = spreadRepeat
+	}
+	if n.name == "linearGradient" {
+		g.x1, g.y1 = length("x1", "0%", r.width), length("y1", "0%", r.height)
+		g.x2, g.y2 = length("x2", "100%", r.width), length("y2", "0%", r.height)
+		if g.x1 == g.x2 && g.y1 == g.y2 {
+			return last
+		}
+	} else {
+		g.radial = true
+		g.cx, g.cy = length("cx", "50%", r.width), length("cy", "50%", r.height)
+		g.r = length("r", "50%", r.diagonal())
+		if g.r <= 0 {
+			return last
+		}
+		g.fx, g.fy = g.cx, g.cy
+		if _, ok := attr("fx"); ok {
+			g.fx = length("fx", "50%", r.width)
+		}
+		if _, ok := attr("fy"); ok {
+			g.fy = length("fy", "50%", r.height)
+		}
+		g.fr = math.Max(length("fr", "0%", r.diagonal()), 0)
+		// a focal point outside the end circle is moved onto it
+		if d := math.Hypot(g.fx-g.cx, g.fy-g.cy); d > g.r {
+			t := g.r / d * 0.999
+			g.fx, g.fy = g.cx+(g.fx-g.cx)*t, g.cy+(g.fy-g.cy)*t
+		}
+	}
+	transform, _ := attr("gradientTransform")
+	matrix := parseTransform(transform).Multiply(units).Multiply(m)
+	if matrix.Determinant() == 0 {
+		return nil
+	}
+	g.inverse = matrix.Inverse()
+	return g
+}
+
+// gradientStops returns the stops of the gradient n, with their opacity
+// multiplied by opacity.
+func gradientStops(n *node, opacity float64) []gradientStop {
+	var stops []gradientStop
+	for _, c := range n.children {
+		if c.name != "stop" {
+			continue
+		}
+		offset := 0.0
+		if v, ok := c.attrs["offset"]; ok {
+			offset = parseOpacity(v, 0)
+		}
+		// the offsets of stops never decrease
+		if len(stops) > 0 {
+			offset = math.Max(offset, stops[len(stops)-1].offset)
+		}
+		var col color.Color = color.Black
+		if v, ok := c.property("stop-color"); ok {
+			if v == "currentColor" {
+				v, _ = c.property("color")
+			}
+			if x, ok := parseColor(v); ok {
+				col = x
+			}
+		}
+		a := opacity
+		if v, ok := c.property("stop-opacity"); ok {
+			a *= parseOpacity(v, 1)
+		}
+		cr, cg, cb, ca := col.RGBA()
+		a *= float64(ca) / 0xffff
+		// the colors of the stops are premultiplied
+		unmultiply := 0.0
+		if ca != 0 {
+			unmultiply = a / float64(ca)
+		}
+		stops = append(stops, gradientStop{offset, float64(cr) * unmultiply, float64(cg) * unmultiply, float64(cb) * unmultiply, a})
+	}
+	return stops
+}
+
+func (g *gradient) ColorAt(x, y int) color.Color {
+	px, py := g.inverse.TransformPoint(float64(x)+0.5, float64(y)+0.5)
+	t, ok := g.offset(px, py)
+	if !ok {
+		return color.Transparent
+	}
+	switch g.spread {
+	case spreadPad:
+		t = math.Max(0, math.Min(1, t))
+	case spreadRepeat:
+		t -= math.Floor(t)
+	case spreadReflect:
+		t = math.Mod(math.Abs(t), 2)
+		if t > 1 {
+			t = 2 - t
+		}
+	}
+	stops := g.stops
+	i := 0
+	for i < len(stops) && stops[i].offset <= t {
+		i++
+	}
+	var s gradientStop
+	switch {
+	case i == 0:
+		s = stops[0]
+	case i == len(stops):
+		s = stops[len(stops)-1]
+	default:
+		s0, s1 := stops[i-1], stops[i]
+		u := (t - s0.offset) / (s1.offset - s0.offset)
+		s = gradientStop{
+			r: s0.r + (s1.r-s0.r)*u,
+			g: s0.g + (s1.g-s0.g)*u,
+			b: s0.b + (s1.b-s0.b)*u,
+			a: s0.a + (s1.a-s0.a)*u,
+		}
+	}
+	return s.color()
+}
+
+func (s gradientStop) color() color.Color {
+	return color.RGBA64{uint16(s.r * 0xffff), uint16(s.g * 0xffff), uint16(s.b * 0xffff), uint16(s.a * 0xffff)}
+}
+
+// offset returns the position of the point x, y along the gradient, which
+// is 0 at its start and 1 at its end. ok is false for points of radial
+// gradients that no circle of the gradient passes through.
+func (g *gradient) offset(x, y float64) (t float64, ok bool) {
+	if !g.radial {
+		dx, dy := g.x2-g.x1, g.y2-g.y1
+		return ((x-g.x1)*dx + (y-g.y1)*dy) / (dx*dx + dy*dy), true
+	}
+	// find the largest t for which the point is on the circle whose center
+	// and radius are interpolated from the focal circle to the end circle
+	// by t, and whose radius is not negative
+	cdx, cdy, dr := g.cx-g.fx, g.cy-g.fy, g.r-g.fr
+	px, py := x-g.fx, y-g.fy
+	a := cdx*cdx + cdy*cdy - dr*dr
+	b := px*cdx + py*cdy + g.fr*dr
+	c := px*px + py*py - g.fr*g.fr
+	if a == 0 {
+		if b == 0 {
+			return 0, false
+		}
+		t = c / (2 * b)
+		return t, g.fr+t*dr >= 0
+	}
+	discriminant := b*b - a*c
+	if discriminant < 0 {
+		return 0, false
+	}
+	sqrt := math.Sqrt(discriminant)
+	t0, t1 := (b+sqrt)/a, (b-sqrt)/a
+	if t0 < t1 {
+		t0, t1 = t1, t0
+	}
+	if g.fr+t0*dr >= 0 {
+		return t0, true
+	}
+	return t1, g.fr+t1*dr >= 0
+}
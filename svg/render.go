@@ -0,0 +1,510 @@
+package svg
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+
+	"github.com/fogleman/gg"
+)
+
+// maxUses is the deepest nesting of use elements that is followed, which
+// stops documents whose use elements reference themselves.
+const maxUses = 16
+
+// maxExpansions is the number of use elements followed in a drawing, which
+// stops documents whose use elements reference the same elements many
+// times over.
+const maxExpansions = 10000
+
+// mode is what a renderer does with the shapes of a document.
+type mode int
+
+const (
+	// drawing paints the shapes with their fill and stroke
+	drawing mode = iota
+	// clipping fills the shapes of a clip path opaquely with their
+	// clip-rule, ignoring opacity and nested clip paths
+	clipping
+	// measuring adds the bounds of the shapes to the bounds of the
+	// renderer
+	measuring
+)
+
+// renderer draws the elements of a document onto a context.
+type renderer struct {
+	dc   *gg.Context
+	doc  *Document
+	mode mode
+	// width and height are the size of the viewBox of the nearest
+	// viewport, which percentages are of
+	width, height float64
+	// bounds is where the measuring mode adds bounds, in the user space the
+	// renderer started in
+	bounds *box
+	uses   int
+	// expansions counts the use elements followed in the drawing, by this
+	// renderer and the renderers made from it
+	expansions *int
+}
+
+// box is a bounding box, which is empty until ok.
+type box struct {
+	x0, y0, x1, y1 float64
+	ok             bool
+}
+
+// add grows b to include the point x, y.
+func (b *box) add(x, y float64) {
+	if !b.ok {
+		*b = box{x, y, x, y, true}
+		return
+	}
+	b.x0, b.y0 = math.Min(b.x0, x), math.Min(b.y0, y)
+	b.x1, b.y1 = math.Max(b.x1, x), math.Max(b.y1, y)
+}
+
+// diagonal returns the length that percentages of lengths that are neither
+// horizontal nor vertical are of.
+func (r *renderer) diagonal() float64 {
+	return math.Sqrt((r.width*r.width + r.height*r.height) / 2)
+}
+
+// length returns the length of the attribute name of n in user units, or
+// def when n has no valid one. Only the first length of lists is used.
+func (r *renderer) length(n *node, s style, name string, ref, def float64) float64 {
+	list := splitList(n.attrs[name])
+	if len(list) == 0 {
+		return def
+	}
+	x, ok := parseLength(list[0], ref, s.fontSize)
+	if !ok {
+		return def
+	}
+	return x
+}
+
+// draw draws the element n, whose parent has style parent.
+func (r *renderer) draw(n *node, parent style) {
+	switch n.name {
+	case "svg":
+		r.element(n, parent, func(r *renderer, s style) {
+			r.viewport(n, s, nil)
+		})
+	case "g", "a":
+		r.element(n, parent, func(r *renderer, s style) {
+			r.children(n, s)
+		})
+	case "use":
+		r.element(n, parent, func(r *renderer, s style) {
+			r.use(n, s)
+		})
+	case "text":
+		r.element(n, parent, func(r *renderer, s style) {
+			r.text(n, s)
+		})
+	case "rect", "circle", "ellipse", "line", "polyline", "polygon", "path":
+		r.element(n, parent, func(r *renderer, s style) {
+			if r.shape(n, s) {
+				r.paint(s)
+			}
+		})
+	}
+}
+
+// children draws the children of n, which has style s.
+func (r *renderer) children(n *node, s style) {
+	for _, c := range n.children {
+		r.draw(c, s)
+	}
+}
+
+// element draws the content of n with its transform, opacity and clip
+// path. content draws with the given renderer, since elements with
+// opacity or a clip path are drawn onto a layer by another renderer.
+func (r *renderer) element(n *node, parent style, content func(r *renderer, s style)) {
+	if v, _ := n.property("display"); v == "none" {
+		return
+	}
+	s := parent.cascade(n, r)
+	dc := r.dc
+	dc.Push()
+	defer dc.Pop()
+	if v, ok := n.attrs["transform"]; ok {
+		dc.SetMatrix(parseTransform(v).Multiply(dc.GetMatrix()))
+	}
+	opacity := 1.0
+	if v, ok := n.property("opacity"); ok {
+		opacity = parseOpacity(v, 1)
+	}
+	var clip *node
+	if v, ok := n.property("clip-path"); ok {
+		if c := r.doc.ids[parseRef(v)]; c != nil && c.name == "clipPath" {
+			clip = c
+		}
+	}
+	if r.mode != drawing || (opacity >= 1 && clip == nil) {
+		content(r, s)
+		return
+	}
+	if opacity <= 0 {
+		return
+	}
+
+	// layers draw text with the fonts of dc
+	layer := dc.NewLayer(dc.Width(), dc.Height())
+	layer.SetMatrix(dc.GetMatrix())
+	if clip != nil {
+		layer.SetMask(r.clipMask(clip, s, content))
+	}
+	l := *r
+	l.dc = layer
+	content(&l, s)
+	if opacity < 1 {
+		layer.ApplyFilter(gg.NewOpacityFilter(opacity))
+	}
+	dc.Identity()
+	dc.DrawImage(layer.Image(), 0, 0)
+}
+
+// clipMask returns the mask of the clip path clip, for the element drawn
+// by content with style s.
+func (r *renderer) clipMask(clip *node, s style, content func(r *renderer, s style)) *image.Alpha {
+	dc := r.dc
+	mask := dc.NewLayer(dc.Width(), dc.Height())
+	m := dc.GetMatrix()
+	if clip.attrs["clipPathUnits"] == "objectBoundingBox" {
+		b := r.measure(s, content)
+		if !b.ok {
+			return mask.AsMask()
+		}
+		m = gg.Scale(b.x1-b.x0, b.y1-b.y0).Multiply(gg.Translate(b.x0, b.y0)).Multiply(m)
+	}
+	if v, ok := clip.attrs["transform"]; ok {
+		m = parseTransform(v).Multiply(m)
+	}
+	mask.SetMatrix(m)
+	c := &renderer{dc: mask, doc: r.doc, mode: clipping, width: r.width, height: r.height, expansions: r.expansions}
+	c.children(clip, defaultStyle().cascade(clip, c))
+	return mask.AsMask()
+}
+
+// measure returns the bounds in user space of what content draws with
+// style s.
+func (r *renderer) measure(s style, content func(r *renderer, s style)) box {
+	dc := r.dc.NewLayer(1, 1)
+	var b box
+	m := &renderer{dc: dc, doc: r.doc, mode: measuring, width: r.width, height: r.height, bounds: &b, expansions: r.expansions}
+	content(m, s)
+	return b
+}
+
+// viewport draws the children of n, an svg or symbol element, in a new
+// viewport. The size of the viewport may be given by use, the use element
+// that references n.
+func (r *renderer) viewport(n *node, s style, use *node) {
+	x := r.length(n, s, "x", r.width, 0)
+	y := r.length(n, s, "y", r.height, 0)
+	w := r.length(n, s, "width", r.width, r.width)
+	h := r.length(n, s, "height", r.height, r.height)
+	if use != nil {
+		w = r.length(use, s, "width", r.width, w)
+		h = r.length(use, s, "height", r.height, h)
+	}
+	if w <= 0 || h <= 0 {
+		return
+	}
+	v := *r
+	v.width, v.height = w, h
+	dc := r.dc
+	if vb, ok := parseViewBox(n.attrs["viewBox"]); ok {
+		m := viewBoxMatrix(vb, n.attrs["preserveAspectRatio"], x, y, w, h)
+		dc.SetMatrix(m.Multiply(dc.GetMatrix()))
+		v.width, v.height = vb[2], vb[3]
+	} else {
+		dc.Translate(x, y)
+	}
+	v.children(n, s)
+}
+
+// use draws the element that the use element n references.
+func (r *renderer) use(n *node, s style) {
+	ref := r.doc.ids[parseRef(n.attrs["href"])]
+	if ref == nil || r.uses >= maxUses || *r.expansions >= maxExpansions {
+		return
+	}
+	*r.expansions++
+	r.dc.Translate(r.length(n, s, "x", r.width, 0), r.length(n, s, "y", r.height, 0))
+	u := *r
+	u.uses++
+	if ref.name == "symbol" {
+		u.element(ref, s, func(r *renderer, s style) {
+			r.viewport(ref, s, n)
+		})
+		return
+	}
+	u.draw(ref, s)
+}
+
+// shape adds the outline of n, a basic shape or path, to the current path.
+// It returns false when the shape is not drawn, for example a rectangle
+// without area.
+func (r *renderer) shape(n *node, s style) bool {
+	dc := r.dc
+	length := func(name string, ref float64) float64 {
+		return r.length(n, s, name, ref, 0)
+	}
+	switch n.name {
+	case "rect":
+		x, y := length("x", r.width), length("y", r.height)
+		w, h := length("width", r.width), length("height", r.height)
+		if w <= 0 || h <= 0 {
+			return false
+		}
+		rx, ry := length("rx", r.width), length("ry", r.height)
+		if _, ok := n.attrs["rx"]; !ok {
+			rx = ry
+		}
+		if _, ok := n.attrs["ry"]; !ok {
+			ry = rx
+		}
+		rx, ry = math.Min(math.Max(rx, 0), w/2), math.Min(math.Max(ry, 0), h/2)
+		if rx == 0 || ry == 0 {
+			dc.DrawRectangle(x, y, w, h)
+			return true
+		}
+		dc.DrawSVGPath(fmt.Sprintf("M%g %gH%gA%g %g 0 0 1 %g %gV%gA%g %g 0 0 1 %g %gH%gA%g %g 0 0 1 %g %gV%gA%g %g 0 0 1 %g %gZ",
+			x+rx, y, x+w-rx, rx, ry, x+w, y+ry, y+h-ry, rx, ry, x+w-rx, y+h,
+			x+rx, rx, ry, x, y+h-ry, y+ry, rx, ry, x+rx, y))
+	case "circle":
+		radius := length("r", r.diagonal())
+		if radius <= 0 {
+			return false
+		}
+		dc.DrawCircle(length("cx", r.width), length("cy", r.height), radius)
+	case "ellipse":
+		rx, ry := length("rx", r.width), length("ry", r.height)
+		if rx <= 0 || ry <= 0 {
+			return false
+		}
+		dc.DrawEllipse(length("cx", r.width), length("cy", r.height), rx, ry)
+	case "line":
+		dc.MoveTo(length("x1", r.width), length("y1", r.height))
+		dc.LineTo(length("x2", r.width), length("y2", r.height))
+	case "polyline", "polygon":
+		points := parseNumbers(n.attrs["points"])
+		if len(points) < 2 {
+			return false
+		}
+		dc.MoveTo(points[0], points[1])
+		for i := 2; i+1 < len(points); i += 2 {
+			dc.LineTo(points[i], points[i+1])
+		}
+		if n.name == "polygon" {
+			dc.ClosePath()
+		}
+	case "path":
+		// like other renderers, draw the path up to an error in its data
+		dc.DrawSVGPath(n.attrs["d"])
+	}
+	return true
+}
+
+// paint fills and strokes the current path with the paints of s, and
+// clears it.
+func (r *renderer) paint(s style) {
+	dc := r.dc
+	defer dc.ClearPath()
+	m := dc.GetMatrix()
+	x0, y0, x1, y1, ok := dc.PathBounds()
+	if !ok {
+		return
+	}
+	switch {
+	case r.mode == measuring:
+		for _, p := range [][2]float64{{x0, y0}, {x1, y0}, {x1, y1}, {x0, y1}} {
+			r.bounds.add(m.TransformPoint(p[0], p[1]))
+		}
+		return
+	case s.hidden:
+		return
+	case r.mode == clipping:
+		dc.SetFillRule(s.clipRule)
+		dc.SetFillColor(color.Black)
+		dc.FillPreserve()
+		return
+	}
+
+	// the patterns are evaluated in device space
+	b := box{x0, y0, x1, y1, true}
+	dc.Identity()
+	if fill := r.pattern(s.fill, s.fillOpacity, b, m); fill != nil {
+		dc.SetFillRule(s.fillRule)
+		dc.SetFillStyle(fill)
+		dc.FillPreserve()
+	}
+	if s.strokeWidth <= 0 {
+		return
+	}
+	if stroke := r.pattern(s.stroke, s.strokeOpacity, b, m); stroke != nil {
+		// the stroke is outlined in user space, so that it is stretched
+		// and skewed with the shape, and its outline filled
+		dc.SetMatrix(m)
+		dc.SetDash(s.dashes...)
+		dc.SetLineWidth(s.strokeWidth)
+		dc.SetLineCap(s.lineCap)
+		dc.SetLineJoin(s.lineJoin)
+		dc.StrokeToPath()
+		dc.Identity()
+		dc.SetFillRule(gg.FillRuleWinding)
+		dc.SetFillStyle(stroke)
+		dc.FillPreserve()
+	}
+}
+
+// pattern returns the pattern of the paint p with opacity, for a shape with
+// bounds b in the user space of the matrix m, or nil for none.
+func (r *renderer) pattern(p paint, opacity float64, b box, m gg.Matrix) gg.Pattern {
+	if n := r.doc.ids[p.ref]; n != nil && (n.name == "linearGradient" || n.name == "radialGradient") {
+		return r.gradient(n, opacity, b, m)
+	}
+	if p.color == nil {
+		return nil
+	}
+	return gg.NewSolidPattern(withOpacity(p.color, opacity))
+}
+
+// textRun is a run of the text of a text element in one style, with the
+// position given by the element that starts it.
+type textRun struct {
+	text       string
+	style      style
+	x, y       float64
+	hasX, hasY bool
+	dx, dy     float64
+}
+
+// text draws the text element n. Its text is laid out in runs of the
+// text and tspan elements, and runs that start at an absolute position
+// start a new chunk, which is aligned as its text-anchor tells.
+func (r *renderer) text(n *node, s style) {
+	runs := r.textRuns(n, s, nil)
+	// collapse white space, like CSS does
+	space := true
+	last := -1
+	for i := range runs {
+		var b strings.Builder
+		for _, c := range runs[i].text {
+			if c == ' ' || c == '\t' || c == '\n' || c == '\r' {
+				if space {
+					continue
+				}
+				c = ' '
+			}
+			space = c == ' '
+			b.WriteRune(c)
+		}
+		runs[i].text = b.String()
+		if runs[i].text != "" {
+			last = i
+		}
+	}
+	if last >= 0 {
+		runs[last].text = strings.TrimRight(runs[last].text, " ")
+	}
+
+	type chunk struct {
+		runs   []textRun
+		x0, x1 float64
+	}
+	var chunks []*chunk
+	var x, y float64
+	var position textRun
+	dc := r.dc
+	for _, run := range runs {
+		// the position of runs without text moves to the next run
+		if run.hasX {
+			position.x, position.hasX = run.x, true
+		}
+		if run.hasY {
+			position.y, position.hasY = run.y, true
+		}
+		position.dx += run.dx
+		position.dy += run.dy
+		if run.text == "" {
+			continue
+		}
+		if position.hasX {
+			x = position.x
+		}
+		if position.hasY {
+			y = position.y
+		}
+		x, y = x+position.dx, y+position.dy
+		if position.hasX || position.hasY || len(chunks) == 0 {
+			chunks = append(chunks, &chunk{x0: x, x1: x})
+		}
+		position = textRun{}
+		run.x, run.y = x, y
+		dc.Push()
+		r.setFont(run.style)
+		w, _ := dc.MeasureString(run.text)
+		dc.Pop()
+		x += w
+		c := chunks[len(chunks)-1]
+		c.runs = append(c.runs, run)
+		c.x1 = x
+	}
+	for _, c := range chunks {
+		shift := -c.runs[0].style.anchor * (c.x1 - c.x0)
+		for _, run := range c.runs {
+			dc.Push()
+			r.setFont(run.style)
+			dc.CreateStringPath(run.text, run.x+shift, run.y)
+			r.paint(run.style)
+			dc.Pop()
+		}
+	}
+}
+
+// textRuns appends the runs of the text of n, which has style s, to runs.
+func (r *renderer) textRuns(n *node, s style, runs []textRun) []textRun {
+	run := textRun{style: s}
+	if _, ok := n.attrs["x"]; ok {
+		run.x, run.hasX = r.length(n, s, "x", r.width, 0), true
+	}
+	if _, ok := n.attrs["y"]; ok {
+		run.y, run.hasY = r.length(n, s, "y", r.height, 0), true
+	}
+	run.dx = r.length(n, s, "dx", r.width, 0)
+	run.dy = r.length(n, s, "dy", r.height, 0)
+	runs = append(runs, run)
+	for _, c := range n.children {
+		switch c.name {
+		case "":
+			runs = append(runs, textRun{text: c.text, style: s})
+		case "tspan":
+			if v, _ := c.property("display"); v != "none" {
+				runs = r.textRuns(c, s.cascade(c, r), runs)
+			}
+		}
+	}
+	return runs
+}
+
+// setFont selects the first font of the font-family of s that is in the
+// font registry, at the font size of s. Without one, the current font is
+// kept, at the font size of s when it is scalable.
+func (r *renderer) setFont(s style) {
+	for _, family := range strings.Split(s.fontFamily, ",") {
+		family = strings.Trim(strings.TrimSpace(family), `"'`)
+		if family != "" && r.dc.SetFontFamily(family, s.fontWeight, s.italic) == nil {
+			break
+		}
+	}
+	if r.dc.HasScalableFont() {
+		r.dc.SetFontSize(s.fontSize)
+	}
+}
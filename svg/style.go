@@ -0,0 +1,490 @@
+package svg
+
+import (
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/fogleman/gg"
+	"golang.org/x/image/colornames"
+)
+
+// defaultFontSize is the font size of the medium keyword of CSS.
+const defaultFontSize = 16
+
+// fontSizes are the font sizes of the absolute size keywords of CSS.
+var fontSizes = map[string]float64{
+	"xx-small": 9,
+	"x-small":  10,
+	"small":    13,
+	"medium":   16,
+	"large":    18,
+	"x-large":  24,
+	"xx-large": 32,
+}
+
+// units are the lengths of the absolute units of CSS in pixels.
+var units = map[string]float64{
+	"":   1,
+	"px": 1,
+	"pt": 4.0 / 3,
+	"pc": 16,
+	"mm": 96 / 25.4,
+	"cm": 96 / 2.54,
+	"in": 96,
+}
+
+// style holds the values of the inherited properties of an element.
+type style struct {
+	fill, stroke  paint
+	fillOpacity   float64
+	strokeOpacity float64
+	fillRule      gg.FillRule
+	clipRule      gg.FillRule
+	strokeWidth   float64
+	lineCap       gg.LineCap
+	lineJoin      gg.LineJoin
+	dashes        []float64
+	color         color.Color
+	fontFamily    string
+	fontSize      float64
+	fontWeight    int
+	italic        bool
+	// anchor is the fraction of the width of text chunks left of their
+	// position: 0 for start, 0.5 for middle and 1 for end
+	anchor float64
+	hidden bool
+}
+
+// paint is the value of a fill or stroke property. A paint without color
+// or reference is none.
+type paint struct {
+	color color.Color
+	// ref is the id of the gradient of url() paints, whose color is the
+	// fallback for a missing gradient
+	ref string
+}
+
+// defaultStyle returns the initial values of the properties. gg has no
+// miter joins, so stroke-linejoin defaults to bevel joins.
+func defaultStyle() style {
+	return style{
+		fill:          paint{color: color.Black},
+		fillOpacity:   1,
+		strokeOpacity: 1,
+		strokeWidth:   1,
+		lineCap:       gg.LineCapButt,
+		lineJoin:      gg.LineJoinBevel,
+		color:         color.Black,
+		fontSize:      defaultFontSize,
+		fontWeight:    400,
+	}
+}
+
+// cascade returns the style of n, whose parent has style s. Invalid values
+// are ignored, so that the inherited values are kept.
+func (s style) cascade(n *node, r *renderer) style {
+	// em lengths are relative to the font size
+	if v, ok := n.property("font-size"); ok {
+		if size, ok := fontSizes[v]; ok {
+			s.fontSize = size
+		} else if v == "larger" {
+			s.fontSize *= 1.2
+		} else if v == "smaller" {
+			s.fontSize /= 1.2
+		} else if size, ok := parseLength(v, s.fontSize, s.fontSize); ok && size >= 0 {
+			s.fontSize = size
+		}
+	}
+	if v, ok := n.property("color"); ok {
+		if c, ok := parseColor(v); ok {
+			s.color = c
+		}
+	}
+	if v, ok := n.property("fill"); ok {
+		if p, ok := s.parsePaint(v); ok {
+			s.fill = p
+		}
+	}
+	if v, ok := n.property("stroke"); ok {
+		if p, ok := s.parsePaint(v); ok {
+			s.stroke = p
+		}
+	}
+	if v, ok := n.property("fill-opacity"); ok {
+		s.fillOpacity = parseOpacity(v, s.fillOpacity)
+	}
+	if v, ok := n.property("stroke-opacity"); ok {
+		s.strokeOpacity = parseOpacity(v, s.strokeOpacity)
+	}
+	if v, ok := n.property("fill-rule"); ok {
+		s.fillRule = parseFillRule(v, s.fillRule)
+	}
+	if v, ok := n.property("clip-rule"); ok {
+		s.clipRule = parseFillRule(v, s.clipRule)
+	}
+	if v, ok := n.property("stroke-width"); ok {
+		if w, ok := parseLength(v, r.diagonal(), s.fontSize); ok && w >= 0 {
+			s.strokeWidth = w
+		}
+	}
+	if v, ok := n.property("stroke-linecap"); ok {
+		switch v {
+		case "butt":
+			s.lineCap = gg.LineCapButt
+		case "round":
+			s.lineCap = gg.LineCapRound
+		case "square":
+			s.lineCap = gg.LineCapSquare
+		}
+	}
+	if v, ok := n.property("stroke-linejoin"); ok {
+		switch v {
+		case "round":
+			s.lineJoin = gg.LineJoinRound
+		case "miter", "miter-clip", "arcs", "bevel":
+			s.lineJoin = gg.LineJoinBevel
+		}
+	}
+	if v, ok := n.property("stroke-dasharray"); ok {
+		s.dashes = s.parseDashes(v, r.diagonal())
+	}
+	if v, ok := n.property("font-family"); ok {
+		s.fontFamily = v
+	}
+	if v, ok := n.property("font-weight"); ok {
+		switch v {
+		case "normal":
+			s.fontWeight = 400
+		case "bold":
+			s.fontWeight = 700
+		case "bolder":
+			s.fontWeight = int(math.Min(float64(s.fontWeight+300), 900))
+		case "lighter":
+			s.fontWeight = int(math.Max(float64(s.fontWeight-300), 100))
+		default:
+			if w, err := strconv.Atoi(v); err == nil && w >= 1 && w <= 1000 {
+				s.fontWeight = w
+			}
+		}
+	}
+	if v, ok := n.property("font-style"); ok {
+		s.italic = v == "italic" || strings.HasPrefix(v, "oblique")
+	}
+	if v, ok := n.property("text-anchor"); ok {
+		switch v {
+		case "start":
+			s.anchor = 0
+		case "middle":
+			s.anchor = 0.5
+		case "end":
+			s.anchor = 1
+		}
+	}
+	if v, ok := n.property("visibility"); ok {
+		s.hidden = v == "hidden" || v == "collapse"
+	}
+	return s
+}
+
+// property returns the value of the property name of n, from its style
+// attribute or else its presentation attribute. ok is false for the
+// inherit keyword, so that the inherited value is kept.
+func (n *node) property(name string) (string, bool) {
+	v, ok := n.style[name]
+	if !ok {
+		v, ok = n.attrs[name]
+	}
+	v = strings.TrimSpace(v)
+	if !ok || v == "" || v == "inherit" {
+		return "", false
+	}
+	return v, true
+}
+
+// parseStyle adds the declarations of the value s of a style attribute to
+// style.
+func parseStyle(style map[string]string, s string) {
+	for _, declaration := range strings.Split(s, ";") {
+		i := strings.IndexByte(declaration, ':')
+		if i < 0 {
+			continue
+		}
+		name := strings.TrimSpace(declaration[:i])
+		value := strings.TrimSpace(declaration[i+1:])
+		style[name] = strings.TrimSpace(strings.TrimSuffix(value, "!important"))
+	}
+}
+
+// parsePaint parses the value of a fill or stroke property.
+func (s style) parsePaint(v string) (paint, bool) {
+	switch v {
+	case "none":
+		return paint{}, true
+	case "currentColor":
+		return paint{color: s.color}, true
+	}
+	if strings.HasPrefix(v, "url(") {
+		i := strings.IndexByte(v, ')')
+		if i < 0 {
+			return paint{}, false
+		}
+		p := paint{ref: parseRef(v[:i+1])}
+		if fallback := strings.TrimSpace(v[i+1:]); fallback != "" {
+			f, ok := s.parsePaint(fallback)
+			if !ok {
+				return paint{}, false
+			}
+			p.color = f.color
+		}
+		return p, true
+	}
+	c, ok := parseColor(v)
+	return paint{color: c}, ok
+}
+
+// parseRef returns the id of a url(#id) reference or an #id link, or "".
+func parseRef(v string) string {
+	v = strings.TrimSpace(v)
+	if strings.HasPrefix(v, "url(") && strings.HasSuffix(v, ")") {
+		v = strings.Trim(v[4:len(v)-1], ` "'`)
+	}
+	if !strings.HasPrefix(v, "#") {
+		return ""
+	}
+	return v[1:]
+}
+
+// parseColor parses a color given by its name, in hexadecimal or with the
+// rgb() and rgba() functions.
+func parseColor(v string) (color.Color, bool) {
+	v = strings.ToLower(v)
+	if c, ok := colornames.Map[v]; ok {
+		return c, true
+	}
+	if v == "transparent" {
+		return color.Transparent, true
+	}
+	if strings.HasPrefix(v, "#") {
+		h := v[1:]
+		if len(h) == 3 || len(h) == 4 {
+			long := make([]byte, 0, 8)
+			for i := range h {
+				long = append(long, h[i], h[i])
+			}
+			h = string(long)
+		}
+		if len(h) == 6 {
+			h += "ff"
+		}
+		x, err := strconv.ParseUint(h, 16, 32)
+		if len(h) != 8 || err != nil {
+			return nil, false
+		}
+		return color.NRGBA{uint8(x >> 24), uint8(x >> 16), uint8(x >> 8), uint8(x)}, true
+	}
+	if !strings.HasSuffix(v, ")") {
+		return nil, false
+	}
+	i := strings.IndexByte(v, '(')
+	if i < 0 || (v[:i] != "rgb" && v[:i] != "rgba") {
+		return nil, false
+	}
+	args := strings.FieldsFunc(v[i+1:len(v)-1], func(r rune) bool {
+		return r == ',' || r == '/' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	})
+	if len(args) != 3 && len(args) != 4 {
+		return nil, false
+	}
+	var c [4]uint8
+	c[3] = 0xff
+	for i, arg := range args {
+		ref := 255.0
+		if i == 3 {
+			ref = 1
+		}
+		x, ok := parseLength(arg, ref, 0)
+		if !ok {
+			return nil, false
+		}
+		c[i] = uint8(math.Round(math.Max(0, math.Min(1, x/ref)) * 255))
+	}
+	return color.NRGBA{c[0], c[1], c[2], c[3]}, true
+}
+
+// withOpacity returns c with its alpha multiplied by opacity.
+func withOpacity(c color.Color, opacity float64) color.Color {
+	if opacity >= 1 {
+		return c
+	}
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	n.A = uint8(math.Round(float64(n.A) * opacity))
+	return n
+}
+
+// parseOpacity parses an opacity given as a number or a percentage, and
+// clamps it to [0, 1]. It returns def for invalid values.
+func parseOpacity(v string, def float64) float64 {
+	x, ok := parseLength(v, 1, 0)
+	if !ok {
+		return def
+	}
+	return math.Max(0, math.Min(1, x))
+}
+
+func parseFillRule(v string, def gg.FillRule) gg.FillRule {
+	switch v {
+	case "nonzero":
+		return gg.FillRuleWinding
+	case "evenodd":
+		return gg.FillRuleEvenOdd
+	}
+	return def
+}
+
+// parseDashes parses the value of a stroke-dasharray property. It returns
+// nil for none and for invalid values. Lists of odd length are repeated,
+// as SVG requires.
+func (s style) parseDashes(v string, ref float64) []float64 {
+	if v == "none" {
+		return nil
+	}
+	var dashes []float64
+	total := 0.0
+	for _, field := range splitList(v) {
+		x, ok := parseLength(field, ref, s.fontSize)
+		if !ok || x < 0 {
+			return nil
+		}
+		dashes = append(dashes, x)
+		total += x
+	}
+	if total == 0 {
+		return nil
+	}
+	if len(dashes)%2 != 0 {
+		dashes = append(dashes, dashes...)
+	}
+	return dashes
+}
+
+// parseLength parses a length in user units. Percentages are of ref, and
+// em and ex are relative to fontSize.
+func parseLength(v string, ref, fontSize float64) (float64, bool) {
+	v = strings.TrimSpace(v)
+	n := numberLength(v)
+	x, err := strconv.ParseFloat(v[:n], 64)
+	if err != nil {
+		return 0, false
+	}
+	unit := strings.ToLower(strings.TrimSpace(v[n:]))
+	switch unit {
+	case "%":
+		return x * ref / 100, true
+	case "em":
+		return x * fontSize, true
+	case "ex":
+		return x * fontSize / 2, true
+	}
+	scale, ok := units[unit]
+	return x * scale, ok
+}
+
+// numberLength returns the length of the number at the start of s.
+func numberLength(s string) int {
+	i := 0
+	if i < len(s) && (s[i] == '+' || s[i] == '-') {
+		i++
+	}
+	digits := func() {
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+	}
+	digits()
+	if i < len(s) && s[i] == '.' {
+		i++
+		digits()
+	}
+	// an e is an exponent only when digits follow, so that 1em is a length
+	if i+1 < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		j := i + 1
+		if s[j] == '+' || s[j] == '-' {
+			j++
+		}
+		if j < len(s) && s[j] >= '0' && s[j] <= '9' {
+			i = j
+			digits()
+		}
+	}
+	return i
+}
+
+// parseNumbers parses a list of numbers separated by whitespace or commas,
+// like the points of a polygon, up to the first invalid number.
+func parseNumbers(s string) []float64 {
+	var numbers []float64
+	for {
+		s = strings.TrimLeft(s, ", \t\r\n")
+		if s == "" {
+			return numbers
+		}
+		n := numberLength(s)
+		x, err := strconv.ParseFloat(s[:n], 64)
+		if err != nil {
+			return numbers
+		}
+		numbers = append(numbers, x)
+		s = s[n:]
+	}
+}
+
+// splitList splits a list of values separated by whitespace or commas.
+func splitList(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	})
+}
+
+// parseTransform parses the value of a transform attribute. Invalid
+// transforms are the identity.
+func parseTransform(v string) gg.Matrix {
+	m := gg.Identity()
+	for {
+		v = strings.TrimLeft(v, ", \t\r\n")
+		i := strings.IndexByte(v, '(')
+		j := strings.IndexByte(v, ')')
+		if i < 0 || j < i {
+			return m
+		}
+		name := strings.TrimSpace(v[:i])
+		a := parseNumbers(v[i+1 : j])
+		v = v[j+1:]
+		var t gg.Matrix
+		switch {
+		case name == "matrix" && len(a) == 6:
+			t = gg.Matrix{XX: a[0], YX: a[1], XY: a[2], YY: a[3], X0: a[4], Y0: a[5]}
+		case name == "translate" && len(a) == 1:
+			t = gg.Translate(a[0], 0)
+		case name == "translate" && len(a) == 2:
+			t = gg.Translate(a[0], a[1])
+		case name == "scale" && len(a) == 1:
+			t = gg.Scale(a[0], a[0])
+		case name == "scale" && len(a) == 2:
+			t = gg.Scale(a[0], a[1])
+		case name == "rotate" && len(a) == 1:
+			t = gg.Rotate(gg.Radians(a[0]))
+		case name == "rotate" && len(a) == 3:
+			t = gg.Translate(-a[1], -a[2]).Multiply(gg.Rotate(gg.Radians(a[0]))).Multiply(gg.Translate(a[1], a[2]))
+		case name == "skewX" && len(a) == 1:
+			t = gg.Shear(math.Tan(gg.Radians(a[0])), 0)
+		case name == "skewY" && len(a) == 1:
+			t = gg.Shear(0, math.Tan(gg.Radians(a[0])))
+		default:
+			return gg.Identity()
+		}
+		// the transforms of the list apply from the last to the first
+		m = t.Multiply(m)
+	}
+}
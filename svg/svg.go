@@ -0,0 +1,227 @@
+// Package svg draws SVG documents, such as logos and icons, onto a
+// gg.Context at any transform.
+//
+// It supports the static subset of SVG: the rect, circle, ellipse, line,
+// polyline, polygon, path and text elements, grouping with g, a, use,
+// symbol, defs and nested svg elements, fills and strokes with colors and
+// linear and radial gradients, clip paths, opacity, transforms, and the
+// viewBox and preserveAspectRatio of viewports. Presentation attributes
+// and style attributes are read, but style sheets are not. Scripts,
+// animation, filters, masks, markers, patterns and images are ignored.
+package svg
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"strings"
+
+	"github.com/fogleman/gg"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+const (
+	svgNamespace   = "http://www.w3.org/2000/svg"
+	xlinkNamespace = "http://www.w3.org/1999/xlink"
+)
+
+// Document is a parsed SVG document.
+type Document struct {
+	// Width and Height are the size of the document in pixels, from the
+	// width and height attributes of its root svg element, or from its
+	// viewBox when they are missing or percentages.
+	Width, Height float64
+
+	// Fonts is the registry the fonts of text are selected from by their
+	// font-family, or nil for gg.DefaultFontRegistry. Text in a family
+	// that is not registered is drawn with the current font face of the
+	// context.
+	Fonts *gg.FontRegistry
+
+	root    *node
+	ids     map[string]*node
+	viewBox [4]float64
+}
+
+// node is an element of a document, or the character data of a text
+// element, which has no name.
+type node struct {
+	name     string
+	attrs    map[string]string
+	style    map[string]string
+	children []*node
+	text     string
+}
+
+// Load reads the SVG document in the file path.
+func Load(path string) (*Document, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Parse reads the SVG document in data.
+func Parse(data []byte) (*Document, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Entity = xml.HTMLEntity
+	decoder.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+		e, err := htmlindex.Get(label)
+		if err != nil {
+			return nil, err
+		}
+		return e.NewDecoder().Reader(input), nil
+	}
+	d := &Document{ids: map[string]*node{}}
+	var stack []*node
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("svg: %v", err)
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			n := &node{attrs: map[string]string{}, style: map[string]string{}}
+			// elements of other namespaces, such as those of editors, get
+			// names that are never drawn
+			n.name = t.Name.Space + ":" + t.Name.Local
+			if t.Name.Space == "" || t.Name.Space == svgNamespace {
+				n.name = t.Name.Local
+			}
+			for _, a := range t.Attr {
+				switch a.Name.Space {
+				case "", xlinkNamespace, "xlink":
+					n.attrs[a.Name.Local] = a.Value
+				}
+			}
+			parseStyle(n.style, n.attrs["style"])
+			if id := n.attrs["id"]; id != "" {
+				if _, ok := d.ids[id]; !ok {
+					d.ids[id] = n
+				}
+			}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, n)
+			} else if d.root == nil {
+				d.root = n
+			}
+			stack = append(stack, n)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, &node{text: string(t)})
+			}
+		}
+	}
+	if d.root == nil || d.root.name != "svg" {
+		return nil, fmt.Errorf("svg: not an SVG document")
+	}
+
+	d.Width, d.Height = 300, 150
+	vb, hasViewBox := parseViewBox(d.root.attrs["viewBox"])
+	if hasViewBox {
+		d.Width, d.Height = vb[2], vb[3]
+	}
+	size := func(name string, v *float64) {
+		s := d.root.attrs[name]
+		if strings.HasSuffix(strings.TrimSpace(s), "%") {
+			return
+		}
+		if l, ok := parseLength(s, 0, defaultFontSize); ok && l > 0 {
+			*v = l
+		}
+	}
+	size("width", &d.Width)
+	size("height", &d.Height)
+	if !hasViewBox {
+		vb = [4]float64{0, 0, d.Width, d.Height}
+	}
+	d.viewBox = vb
+	return d, nil
+}
+
+// Draw draws the document into the rectangle x, y, w, h of the user space
+// of dc, fitting its viewBox into the rectangle as its preserveAspectRatio
+// attribute tells. A document without a viewBox is scaled from its Width
+// and Height. The drawing is not clipped to the rectangle. Draw clears the
+// current path, and restores the other state of dc when it returns.
+func (d *Document) Draw(dc *gg.Context, x, y, w, h float64) {
+	if w <= 0 || h <= 0 {
+		return
+	}
+	dc.Push()
+	defer dc.Pop()
+	dc.ClearPath()
+	dc.SetFontRegistry(d.Fonts)
+	m := viewBoxMatrix(d.viewBox, d.root.attrs["preserveAspectRatio"], x, y, w, h)
+	dc.SetMatrix(m.Multiply(dc.GetMatrix()))
+	d.render(dc)
+}
+
+// render draws the document onto dc in the user space of its viewBox. It
+// returns the number of use elements that were followed.
+func (d *Document) render(dc *gg.Context) (expansions int) {
+	r := &renderer{dc: dc, doc: d, width: d.viewBox[2], height: d.viewBox[3], expansions: &expansions}
+	r.element(d.root, defaultStyle(), func(r *renderer, s style) {
+		r.children(d.root, s)
+	})
+	return expansions
+}
+
+// parseViewBox parses the value of a viewBox attribute. ok is false when
+// it is missing or invalid, or its width or height is not positive.
+func parseViewBox(s string) (vb [4]float64, ok bool) {
+	v := parseNumbers(s)
+	if len(v) != 4 || v[2] <= 0 || v[3] <= 0 {
+		return vb, false
+	}
+	copy(vb[:], v)
+	return vb, true
+}
+
+// viewBoxMatrix returns the matrix that maps the viewBox vb into the
+// viewport x, y, w, h, aligned as the value par of a preserveAspectRatio
+// attribute tells.
+func viewBoxMatrix(vb [4]float64, par string, x, y, w, h float64) gg.Matrix {
+	sx, sy := w/vb[2], h/vb[3]
+	fields := strings.Fields(par)
+	if len(fields) > 0 && fields[0] == "defer" {
+		fields = fields[1:]
+	}
+	align, slice := "xMidYMid", false
+	if len(fields) > 0 {
+		align = fields[0]
+	}
+	if len(fields) > 1 {
+		slice = fields[1] == "slice"
+	}
+	if align != "none" {
+		s := math.Min(sx, sy)
+		if slice {
+			s = math.Max(sx, sy)
+		}
+		sx, sy = s, s
+		position := func(name string) float64 {
+			switch {
+			case strings.Contains(align, name+"Min"):
+				return 0
+			case strings.Contains(align, name+"Max"):
+				return 1
+			}
+			return 0.5
+		}
+		x += (w - vb[2]*s) * position("x")
+		y += (h - vb[3]*s) * position("Y")
+	}
+	return gg.Translate(-vb[0], -vb[1]).Multiply(gg.Scale(sx, sy)).Multiply(gg.Translate(x, y))
+}
@@ -0,0 +1,306 @@
+package svg
+
+import (
+	"crypto/md5"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/fogleman/gg"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+var save bool
+
+func init() {
+	flag.BoolVar(&save, "save", false, "save PNG output for each test case")
+}
+
+func checkHash(t *testing.T, dc *gg.Context, expected string) {
+	actual := fmt.Sprintf("%x", md5.Sum(dc.Image().(*image.RGBA).Pix))
+	if actual != expected {
+		t.Fatalf("expected hash: %s != actual hash: %s", expected, actual)
+	}
+}
+
+func saveImage(dc *gg.Context, name string) error {
+	if save {
+		return dc.SavePNG(name + ".png")
+	}
+	return nil
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		svg           string
+		width, height float64
+	}{
+		{`<svg xmlns="http://www.w3.org/2000/svg" width="40" height="30"/>`, 40, 30},
+		{`<svg xmlns="http://www.w3.org/2000/svg" width="1in" height="6pt"/>`, 96, 8},
+		{`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 24 12"/>`, 24, 12},
+		{`<svg xmlns="http://www.w3.org/2000/svg" width="100%" viewBox="-5 -5 24,12"/>`, 24, 12},
+		{`<svg xmlns="http://www.w3.org/2000/svg"/>`, 300, 150},
+	}
+	for _, test := range tests {
+		d, err := Parse([]byte(test.svg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.Width != test.width || d.Height != test.height {
+			t.Errorf("%s: got size %gx%g, expected %gx%g", test.svg, d.Width, d.Height, test.width, test.height)
+		}
+	}
+	for _, s := range []string{"", "<html/>", "<svg><g></svg>"} {
+		if _, err := Parse([]byte(s)); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestParseValues(t *testing.T) {
+	colors := map[string]color.Color{
+		"#f00":                  color.NRGBA{255, 0, 0, 255},
+		"#00ff0080":             color.NRGBA{0, 255, 0, 128},
+		"rgb(0, 0, 255)":        color.NRGBA{0, 0, 255, 255},
+		"rgba(100%,50%,0%,0.5)": color.NRGBA{255, 128, 0, 128},
+		"rgb(10 20 30 / 20%)":   color.NRGBA{10, 20, 30, 51},
+		"SteelBlue":             color.RGBA{70, 130, 180, 255},
+		"transparent":           color.Transparent,
+	}
+	for s, want := range colors {
+		if c, ok := parseColor(s); !ok || c != want {
+			t.Errorf("parseColor(%q) = %v, %v, expected %v", s, c, ok, want)
+		}
+	}
+	for _, s := range []string{"#ff", "rgb(1,2)", "hsl(0,0%,0%)", "notacolor"} {
+		if _, ok := parseColor(s); ok {
+			t.Errorf("parseColor(%q) succeeded", s)
+		}
+	}
+
+	lengths := map[string]float64{"12": 12, "1.5e1px": 15, "2em": 20, "1ex": 5, "50%": 100, "2.54cm": 96, "-3pt": -4}
+	for s, want := range lengths {
+		if x, ok := parseLength(s, 200, 10); !ok || x != want {
+			t.Errorf("parseLength(%q) = %g, %v, expected %g", s, x, ok, want)
+		}
+	}
+	if got := parseNumbers("10,20 -5-6.5.5e1"); fmt.Sprint(got) != "[10 20 -5 -6.5 5]" {
+		t.Errorf("parseNumbers returned %v", got)
+	}
+
+	m := parseTransform("translate(10, 20) rotate(90 5 5) scale(2)")
+	x, y := m.TransformPoint(1, 0)
+	if x != 20 || y != 22 {
+		t.Errorf("transform maps 1,0 to %g,%g, expected 20,22", x, y)
+	}
+	if m := parseTransform("scale(2) bogus(1)"); m != gg.Identity() {
+		t.Errorf("invalid transform is %v, expected the identity", m)
+	}
+}
+
+const testSVG = `<?xml version="1.0" encoding="UTF-8"?>
+<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink"
+     width="200" height="150" viewBox="0 0 200 150" preserveAspectRatio="xMinYMid meet">
+  <defs>
+    <linearGradient id="stops">
+      <stop offset="0" stop-color="#e33"/>
+      <stop offset="50%" style="stop-color: gold"/>
+      <stop offset="1" stop-color="rgb(30, 120, 220)"/>
+    </linearGradient>
+    <linearGradient id="diagonal" xlink:href="#stops" x2="1" y2="1"/>
+    <radialGradient id="glow" href="#stops" fx="0.4" fy="0.4" spreadMethod="reflect" r="0.3"/>
+    <linearGradient id="fade" gradientUnits="userSpaceOnUse" x1="110" x2="190">
+      <stop offset="0" stop-color="navy"/>
+      <stop offset="1" stop-color="navy" stop-opacity="0"/>
+    </linearGradient>
+    <clipPath id="circle" clipPathUnits="objectBoundingBox">
+      <circle cx=".5" cy=".5" r=".5"/>
+    </clipPath>
+    <clipPath id="band">
+      <rect x="0" y="60" width="200" height="20"/>
+    </clipPath>
+    <symbol id="star" viewBox="-10 -10 20 20">
+      <polygon points="0,-10 3,-3 10,-3 4,2 6,9 0,5 -6,9 -4,2 -10,-3 -3,-3"/>
+    </symbol>
+    <path id="tick" d="M0 5l4 4 8-9" fill="none" stroke-width="2" stroke-linecap="round"/>
+  </defs>
+  <rect x="5" y="5" width="60" height="40" rx="10" ry="6" fill="url(#diagonal)" stroke="black"/>
+  <circle cx="100" cy="25" r="20" fill="url(#glow)"/>
+  <ellipse cx="160" cy="25" rx="30" ry="15" fill="url(#missing) purple" stroke="teal" stroke-width="3" stroke-dasharray="6 3"/>
+  <g transform="translate(5 55) rotate(-10)" opacity="0.6" fill="green" stroke="black">
+    <polygon points="0,0 40,0 40,40 0,40" fill-rule="evenodd"/>
+    <polygon points="10,10 30,10 30,30 10,30" fill="inherit"/>
+  </g>
+  <g fill="orange" style="stroke: brown; stroke-width: 2">
+    <polyline points="60 60 70 90 80 60 90 90" fill="none"/>
+    <line x1="95" y1="60" x2="105" y2="90"/>
+  </g>
+  <image href="picture.png" width="10" height="10"/>
+  <rect x="110" y="55" width="80" height="40" fill="url(#fade)" clip-path="url(#band)"/>
+  <g clip-path="url(#circle)">
+    <rect x="140" y="100" width="40" height="40" fill="crimson"/>
+    <rect x="160" y="120" width="40" height="40" fill="steelblue"/>
+  </g>
+  <use xlink:href="#star" x="5" y="100" width="40" height="40" fill="gold" stroke="black"/>
+  <use href="#tick" x="50" y="110" stroke="green"/>
+  <use href="#tick" x="50" y="125" stroke="red" display="none"/>
+  <svg x="70" y="100" width="60" height="20" viewBox="0 0 10 10" preserveAspectRatio="none">
+    <circle cx="5" cy="5" r="5" fill="currentColor" color="indigo"/>
+  </svg>
+  <text x="100" y="140" font-family="'Nonexistent', Go" font-size="14" text-anchor="middle"
+        fill="url(#stops)">Hello, <tspan font-weight="bold" fill="black">SVG</tspan>!</text>
+</svg>`
+
+func TestDraw(t *testing.T) {
+	dir := t.TempDir()
+	fonts := gg.NewFontRegistry()
+	for name, data := range map[string][]byte{"Go-Regular.ttf": goregular.TTF, "Go-Bold.ttf": gobold.TTF} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := fonts.AddFile(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(dir, "test.svg")
+	if err := ioutil.WriteFile(path, []byte(testSVG), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Fonts = fonts
+
+	dc := gg.NewContext(300, 200)
+	dc.SetRGB(1, 1, 1)
+	dc.Clear()
+	dc.SetRGB(1, 0, 0)
+	// the document is drawn at its natural size, and again smaller and
+	// rotated, into a wider rectangle
+	d.Draw(dc, 0, 0, d.Width, d.Height)
+	dc.RotateAbout(gg.Radians(20), 250, 150)
+	d.Draw(dc, 200, 100, 100, 50)
+	if _, _, _, _, ok := dc.PathBounds(); ok {
+		t.Error("Draw left a path")
+	}
+	dc.DrawRectangle(200, 100, 100, 50)
+	dc.Stroke()
+	saveImage(dc, "TestDraw")
+	checkHash(t, dc, "e6daea80a7645afb573ffe22d98d47f5")
+}
+
+func TestDrawUseExpansions(t *testing.T) {
+	// each level of nesting draws the group three times, which would be
+	// 3^16 groups without a limit on the use elements followed
+	d, err := Parse([]byte(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 10 10">
+<g id="g"><rect width="1" height="1"/><use href="#g"/><use href="#g"/><use href="#g"/></g>
+</svg>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dc := gg.NewContext(10, 10)
+	if n := d.render(dc); n != maxExpansions {
+		t.Errorf("followed %d use elements, expected %d", n, maxExpansions)
+	}
+	if ink(dc) == 0 {
+		t.Error("nothing was drawn")
+	}
+}
+
+// ink returns the number of pixels of dc that are not transparent.
+func ink(dc *gg.Context) int {
+	im := dc.Image().(*image.RGBA)
+	n := 0
+	for i := 3; i < len(im.Pix); i += 4 {
+		if im.Pix[i] != 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func TestDrawTextLayers(t *testing.T) {
+	// text in layers and clip paths is drawn with the font of the context
+	// like other text
+	draw := func(body string) int {
+		d, err := Parse([]byte(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 150 50">
+<clipPath id="user"><rect width="150" height="50"/></clipPath>
+<clipPath id="box" clipPathUnits="objectBoundingBox"><rect width="1" height="1"/></clipPath>
+` + body + `
+</svg>`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		dc := gg.NewContext(150, 50)
+		if err := dc.LoadFontData(goregular.TTF); err != nil {
+			t.Fatal(err)
+		}
+		d.Draw(dc, 0, 0, 150, 50)
+		return ink(dc)
+	}
+	const text = `<text x="5" y="40" font-family="Missing" font-size="40">Hello</text>`
+	plain := draw(text)
+	if plain == 0 {
+		t.Fatal("the text was not drawn")
+	}
+	for _, body := range []string{
+		`<g opacity="0.9">` + text + `</g>`,
+		`<g clip-path="url(#user)">` + text + `</g>`,
+		`<g clip-path="url(#box)">` + text + `</g>`,
+	} {
+		if n := draw(body); n < plain*9/10 {
+			t.Errorf("%s: %d pixels drawn, expected about %d", body, n, plain)
+		}
+	}
+}
+
+func TestDrawFontSize(t *testing.T) {
+	// without a font of the font-family in the registry, the text is drawn
+	// with the font of the context at the font size of the document
+	draw := func(size int) string {
+		d, err := Parse([]byte(fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 100 50">
+<text x="5" y="40" font-family="Missing" font-size="%d">Hi</text>
+</svg>`, size)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		dc := gg.NewContext(100, 50)
+		if err := dc.LoadFontData(goregular.TTF); err != nil {
+			t.Fatal(err)
+		}
+		d.Draw(dc, 0, 0, 100, 50)
+		return fmt.Sprintf("%x", md5.Sum(dc.Image().(*image.RGBA).Pix))
+	}
+	if draw(10) == draw(40) {
+		t.Error("the font size is not applied")
+	}
+}
+
+func TestDrawStrokeTransform(t *testing.T) {
+	// strokes are stretched with the shapes by a non-uniform scale
+	d, err := Parse([]byte(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 10 10" preserveAspectRatio="none">
+<line x1="5" y1="0" x2="5" y2="10" stroke="black" stroke-width="2"/>
+<line x1="0" y1="5" x2="10" y2="5" stroke="black" stroke-width="2"/>
+</svg>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dc := gg.NewContext(40, 10)
+	d.Draw(dc, 0, 0, 40, 10)
+	im := dc.Image().(*image.RGBA)
+	for _, p := range []struct {
+		x, y int
+		a    uint8
+	}{{16, 1, 255}, {23, 1, 255}, {15, 1, 0}, {24, 1, 0}, {2, 4, 255}, {2, 5, 255}, {2, 3, 0}, {2, 6, 0}} {
+		if a := im.RGBAAt(p.x, p.y).A; a != p.a {
+			t.Errorf("alpha at %d,%d is %d, expected %d", p.x, p.y, a, p.a)
+		}
+	}
+}
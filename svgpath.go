@@ -0,0 +1,433 @@
+package gg
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// svgPathArgs is the number of arguments of the commands of SVG path data.
+var svgPathArgs = map[byte]int{'M': 2, 'L': 2, 'H': 1, 'V': 1, 'C': 6, 'S': 4, 'Q': 4, 'T': 2, 'A': 7, 'Z': 0}
+
+// DrawSVGPath adds the shapes described by SVG path data, the value of the
+// d attribute of a path element, to the current path. All the commands of
+// SVG path data are supported, in their absolute and relative forms:
+// moveto (M), lineto (L, H and V), cubic (C and S) and quadratic (Q and T)
+// Bézier curves, elliptical arcs (A) and closepath (Z). The coordinates are
+// in user space, like those of MoveTo and LineTo. Like SVG renderers, it adds
+// the path up to the first error in the data and returns the error.
+func (dc *Context) DrawSVGPath(d string) error {
+	p := svgPathParser{data: d}
+	var start, current, control Point
+	var last byte
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.data) {
+			return nil
+		}
+		command := p.data[p.pos]
+		if strings.IndexByte("MmZzLlHhVvCcSsQqTtAa", command) >= 0 {
+			p.pos++
+		} else if last == 0 || last == 'Z' || last == 'z' {
+			return p.errorf("expected a command")
+		} else {
+			// the command is repeated, and a moveto is followed by linetos
+			command = last
+			switch command {
+			case 'M':
+				command = 'L'
+			case 'm':
+				command = 'l'
+			}
+		}
+		if last == 0 && command != 'M' && command != 'm' {
+			return p.errorf("path data must start with a moveto")
+		}
+
+		// the coordinates of relative commands are relative to the current
+		// point
+		relative := command >= 'a'
+		origin := Point{}
+		if relative {
+			origin = current
+		}
+		args := make([]float64, svgPathArgs[command&^0x20])
+		for i := range args {
+			var err error
+			if command&^0x20 == 'A' && (i == 3 || i == 4) {
+				args[i], err = p.flag()
+			} else {
+				args[i], err = p.number()
+			}
+			if err != nil {
+				return err
+			}
+		}
+		pt := func(i int) Point {
+			return Point{origin.X + args[i], origin.Y + args[i+1]}
+		}
+		// the reflection of the last control point of the previous curve of
+		// the same kind, or the current point
+		reflect := func(kinds string) Point {
+			if last != 0 && strings.IndexByte(kinds, last&^0x20) >= 0 {
+				return Point{2*current.X - control.X, 2*current.Y - control.Y}
+			}
+			return current
+		}
+
+		switch command &^ 0x20 {
+		case 'M':
+			current = pt(0)
+			start = current
+			dc.MoveTo(current.X, current.Y)
+		case 'Z':
+			dc.ClosePath()
+			current = start
+		case 'L':
+			current = pt(0)
+			dc.LineTo(current.X, current.Y)
+		case 'H':
+			current.X = origin.X + args[0]
+			dc.LineTo(current.X, current.Y)
+		case 'V':
+			current.Y = origin.Y + args[0]
+			dc.LineTo(current.X, current.Y)
+		case 'C':
+			c1 := pt(0)
+			control, current = pt(2), pt(4)
+			dc.CubicTo(c1.X, c1.Y, control.X, control.Y, current.X, current.Y)
+		case 'S':
+			c1 := reflect("CS")
+			control, current = pt(0), pt(2)
+			dc.CubicTo(c1.X, c1.Y, control.X, control.Y, current.X, current.Y)
+		case 'Q':
+			control, current = pt(0), pt(2)
+			dc.QuadraticTo(control.X, control.Y, current.X, current.Y)
+		case 'T':
+			control, current = reflect("QT"), pt(0)
+			dc.QuadraticTo(control.X, control.Y, current.X, current.Y)
+		case 'A':
+			end := Point{origin.X + args[5], origin.Y + args[6]}
+			dc.svgArc(current, end, args[0], args[1], Radians(args[2]), args[3] != 0, args[4] != 0)
+			current = end
+		}
+		last = command
+		p.skipSeparator()
+	}
+}
+
+// svgArc adds an SVG elliptical arc from p0 to p1 with radii rx and ry, its
+// x axis rotated by phi, to the current path as cubic Bézier curves. The
+// flags choose between the four arcs that join the points, as described in
+// the implementation notes of the SVG specification.
+func (dc *Context) svgArc(p0, p1 Point, rx, ry, phi float64, large, sweep bool) {
+	if p0 == p1 {
+		return
+	}
+	rx, ry = math.Abs(rx), math.Abs(ry)
+	if rx == 0 || ry == 0 {
+		dc.LineTo(p1.X, p1.Y)
+		return
+	}
+	sin, cos := math.Sincos(phi)
+	// the midpoint of the chord in the coordinates of the ellipse
+	dx, dy := (p0.X-p1.X)/2, (p0.Y-p1.Y)/2
+	x1 := cos*dx + sin*dy
+	y1 := -sin*dx + cos*dy
+	// radii too small to join the points are scaled up
+	if l := x1*x1/(rx*rx) + y1*y1/(ry*ry); l > 1 {
+		rx *= math.Sqrt(l)
+		ry *= math.Sqrt(l)
+	}
+	num := rx*rx*ry*ry - rx*rx*y1*y1 - ry*ry*x1*x1
+	den := rx*rx*y1*y1 + ry*ry*x1*x1
+	k := math.Sqrt(math.Max(num/den, 0))
+	if large == sweep {
+		k = -k
+	}
+	cx1, cy1 := k*rx*y1/ry, -k*ry*x1/rx
+	cx := cos*cx1 - sin*cy1 + (p0.X+p1.X)/2
+	cy := sin*cx1 + cos*cy1 + (p0.Y+p1.Y)/2
+	angle := func(ux, uy, vx, vy float64) float64 {
+		return math.Atan2(ux*vy-uy*vx, ux*vx+uy*vy)
+	}
+	theta := angle(1, 0, (x1-cx1)/rx, (y1-cy1)/ry)
+	delta := angle((x1-cx1)/rx, (y1-cy1)/ry, (-x1-cx1)/rx, (-y1-cy1)/ry)
+	if !sweep && delta > 0 {
+		delta -= 2 * math.Pi
+	} else if sweep && delta < 0 {
+		delta += 2 * math.Pi
+	}
+
+	// each quarter of the ellipse, or less, is a cubic curve
+	n := int(math.Ceil(math.Abs(delta)/(math.Pi/2) - 1e-9))
+	step := delta / float64(n)
+	t := 4.0 / 3 * math.Tan(step/4)
+	point := func(a float64) (x, y, tx, ty float64) {
+		s, c := math.Sincos(a)
+		// the point at angle a, and the derivative of the ellipse there
+		ex, ey := rx*c, ry*s
+		dx, dy := -rx*s, ry*c
+		return cx + cos*ex - sin*ey, cy + sin*ex + cos*ey, cos*dx - sin*dy, sin*dx + cos*dy
+	}
+	for i := 0; i < n; i++ {
+		a0, a1 := theta+float64(i)*step, theta+float64(i+1)*step
+		x0, y0, tx0, ty0 := point(a0)
+		x3, y3, tx3, ty3 := point(a1)
+		if i == n-1 {
+			// end exactly on p1
+			x3, y3 = p1.X, p1.Y
+		}
+		dc.CubicTo(x0+t*tx0, y0+t*ty0, x3-t*tx3, y3-t*ty3, x3, y3)
+	}
+}
+
+// svgPathParser reads the numbers and flags of SVG path data.
+type svgPathParser struct {
+	data string
+	pos  int
+}
+
+func (p *svgPathParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("gg: invalid SVG path data at offset %d: "+format, append([]interface{}{p.pos}, args...)...)
+}
+
+func (p *svgPathParser) skipSpace() {
+	for p.pos < len(p.data) && strings.IndexByte(" \t\r\n\f", p.data[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+// skipSeparator skips white space with at most one comma.
+func (p *svgPathParser) skipSeparator() {
+	p.skipSpace()
+	if p.pos < len(p.data) && p.data[p.pos] == ',' {
+		p.pos++
+		p.skipSpace()
+	}
+}
+
+// number reads a number after an optional separator. Numbers need no
+// separator when the next one starts with a sign, or with a point after a
+// number with a fraction, as in "1-2" and "0.5.5".
+func (p *svgPathParser) number() (float64, error) {
+	p.skipSeparator()
+	start := p.pos
+	digits := func() bool {
+		from := p.pos
+		for p.pos < len(p.data) && p.data[p.pos] >= '0' && p.data[p.pos] <= '9' {
+			p.pos++
+		}
+		return p.pos > from
+	}
+	sign := func() {
+		if p.pos < len(p.data) && (p.data[p.pos] == '+' || p.data[p.pos] == '-') {
+			p.pos++
+		}
+	}
+	sign()
+	ok := digits()
+	if p.pos < len(p.data) && p.data[p.pos] == '.' {
+		p.pos++
+		ok = digits() || ok
+	}
+	if !ok {
+		p.pos = start
+		return 0, p.errorf("expected a number")
+	}
+	if p.pos < len(p.data) && (p.data[p.pos] == 'e' || p.data[p.pos] == 'E') {
+		mark := p.pos
+		p.pos++
+		sign()
+		if !digits() {
+			// the e does not start an exponent
+			p.pos = mark
+		}
+	}
+	v, err := strconv.ParseFloat(p.data[start:p.pos], 64)
+	if err != nil {
+		return 0, p.errorf("%v", err)
+	}
+	return v, nil
+}
+
+// flag reads an arc flag, 0 or 1, after an optional separator. Flags need
+// no separator after them.
+func (p *svgPathParser) flag() (float64, error) {
+	p.skipSeparator()
+	if p.pos < len(p.data) && (p.data[p.pos] == '0' || p.data[p.pos] == '1') {
+		p.pos++
+		return float64(p.data[p.pos-1] - '0'), nil
+	}
+	return 0, p.errorf("expected a flag")
+}
+
+// SVGPath returns the current path as compact SVG path data, in the user
+// space of the current matrix. Quadratic and cubic curves are written as
+// curves, arcs are written as the curves that make them up, and the
+// coordinates are rounded to three decimals.
+func (dc *Context) SVGPath() string {
+	var w svgPathWriter
+	inverse := dc.matrix.Inverse()
+	pt := func(p Point) svgPoint {
+		x, y := inverse.TransformPoint(p.X, p.Y)
+		return svgPoint{svgRound(x), svgRound(y)}
+	}
+	var start, current svgPoint
+	for _, s := range dc.segments {
+		switch s.op {
+		case segmentMoveTo:
+			p := pt(s.points[0])
+			w.moveTo(current, p)
+			start, current = p, p
+		case segmentLineTo:
+			p := pt(s.points[0])
+			w.lineTo(current, p)
+			current = p
+		case segmentQuadTo:
+			p1, p2 := pt(s.points[0]), pt(s.points[1])
+			w.quadTo(current, p1, p2)
+			current = p2
+		case segmentCubeTo:
+			p1, p2, p3 := pt(s.points[0]), pt(s.points[1]), pt(s.points[2])
+			w.cubeTo(current, p1, p2, p3)
+			current = p3
+		case segmentClose:
+			w.closePath()
+			current = start
+		}
+	}
+	return w.String()
+}
+
+// pathSegment is a segment of the current path in device space. Unlike the
+// raster paths, it keeps cubic curves and the full precision of the points,
+// so that SVGPath can write the path back in user space.
+type pathSegment struct {
+	op     byte
+	points [3]Point
+}
+
+// The operations of path segments.
+const (
+	segmentMoveTo = iota
+	segmentLineTo
+	segmentQuadTo
+	segmentCubeTo
+	segmentClose
+)
+
+// svgPoint is a point of SVG path data in thousandths of a unit, so that
+// relative coordinates are computed exactly from the rounded ones.
+type svgPoint struct {
+	X, Y int64
+}
+
+// svgRound rounds a coordinate to thousandths of a unit.
+func svgRound(x float64) int64 {
+	return int64(math.Round(x * 1000))
+}
+
+// svgPathWriter writes SVG path data, using the shorter of the absolute and
+// relative forms of each command and leaving out repeated commands and the
+// separators that are not needed.
+type svgPathWriter struct {
+	strings.Builder
+	last byte
+	// number is true when the data ends with a number, and fraction when
+	// that number has a fraction, so that a number that starts with a
+	// point needs no separator after it
+	number, fraction bool
+}
+
+// svgNumber formats a value in thousandths of a unit without a leading
+// zero.
+func svgNumber(x int64) string {
+	s := strconv.FormatFloat(float64(x)/1000, 'f', -1, 64)
+	if strings.HasPrefix(s, "0.") {
+		s = s[1:]
+	} else if strings.HasPrefix(s, "-0.") {
+		s = "-" + s[2:]
+	}
+	return s
+}
+
+// command formats a command with its arguments, to be written after the
+// data written so far. fraction reports whether the last argument has a
+// fraction.
+func (w *svgPathWriter) command(c byte, args ...int64) (s string, fraction bool) {
+	var b strings.Builder
+	number, fraction := w.number, w.fraction
+	// a moveto repeated would be taken for a lineto
+	if c != w.last || c == 'M' || c == 'm' {
+		b.WriteByte(c)
+		number = false
+	}
+	for _, x := range args {
+		s := svgNumber(x)
+		if number && s[0] != '-' && (s[0] != '.' || !fraction) {
+			b.WriteByte(' ')
+		}
+		b.WriteString(s)
+		number, fraction = true, strings.IndexByte(s, '.') >= 0
+	}
+	return b.String(), fraction
+}
+
+// write writes the shorter of the absolute and the relative forms of a
+// command with points, taking the relative points relative to current.
+func (w *svgPathWriter) write(c byte, current svgPoint, points ...svgPoint) {
+	var abs, rel []int64
+	for _, p := range points {
+		abs = append(abs, p.X, p.Y)
+		rel = append(rel, p.X-current.X, p.Y-current.Y)
+	}
+	w.writeArgs(c, abs, rel)
+}
+
+// writeArgs writes the shorter of the absolute and the relative forms of a
+// command with their arguments.
+func (w *svgPathWriter) writeArgs(c byte, abs, rel []int64) {
+	s, fraction := w.command(c, abs...)
+	lower := c + 'a' - 'A'
+	if r, f := w.command(lower, rel...); len(r) < len(s) {
+		s, fraction, c = r, f, lower
+	}
+	w.WriteString(s)
+	w.last = c
+	w.number, w.fraction = true, fraction
+}
+
+func (w *svgPathWriter) moveTo(current, p svgPoint) {
+	if w.Len() == 0 {
+		// the first moveto is absolute even in its relative form
+		current = svgPoint{}
+	}
+	w.write('M', current, p)
+}
+
+func (w *svgPathWriter) lineTo(current, p svgPoint) {
+	switch {
+	case p.Y == current.Y:
+		w.writeArgs('H', []int64{p.X}, []int64{p.X - current.X})
+	case p.X == current.X:
+		w.writeArgs('V', []int64{p.Y}, []int64{p.Y - current.Y})
+	default:
+		w.write('L', current, p)
+	}
+}
+
+func (w *svgPathWriter) quadTo(current, p1, p2 svgPoint) {
+	w.write('Q', current, p1, p2)
+}
+
+func (w *svgPathWriter) cubeTo(current, p1, p2, p3 svgPoint) {
+	w.write('C', current, p1, p2, p3)
+}
+
+func (w *svgPathWriter) closePath() {
+	w.WriteByte('z')
+	w.last = 'z'
+	w.number = false
+}
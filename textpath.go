@@ -0,0 +1,173 @@
+package gg
+
+import (
+	"image"
+	"log"
+	"math"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+)
+
+// TextSide selects the side of a path that text is placed on.
+type TextSide int
+
+const (
+	// TextSideLeft places text on the left of the path, seen in the
+	// direction of travel, so text along a path drawn from left to right
+	// stands upright on top of it.
+	TextSideLeft TextSide = iota
+	// TextSideRight places text on the right of the path. The text runs
+	// along the path in the opposite direction.
+	TextSideRight
+)
+
+// TextOnPath describes how text is placed along a path by DrawStringOnPath
+// and CreateStringPathOnPath.
+type TextOnPath struct {
+	// Points is the path to follow, in user space. If it is nil the current
+	// path is followed instead.
+	Points []Point
+
+	// Offset is the distance along the path at which the text is anchored.
+	Offset float64
+
+	// Align selects the point of the text that is anchored at Offset: its
+	// start with AlignLeft, its middle with AlignCenter or its end with
+	// AlignRight.
+	Align Align
+
+	// Side selects the side of the path the text is placed on.
+	Side TextSide
+}
+
+// DrawStringOnPath draws the specified text along a path, moving and
+// rotating each glyph so that its baseline follows the path. Glyphs that
+// would fall beyond either end of the path are not drawn. The current path
+// is left unchanged, so it can be stroked afterwards.
+func (dc *Context) DrawStringOnPath(s string, p TextOnPath) {
+	placed := dc.placeOnPath(dc.faces(), s, p)
+	matrix := dc.matrix
+	var bounds image.Rectangle
+	for _, pg := range placed {
+		bounds = bounds.Union(glyphsBounds([]glyph{pg.glyph}, 0, 0, pg.matrix))
+	}
+	dc.composite(bounds, func(dst *image.RGBA, mask *image.Alpha) {
+		dc.paintGlyphs(dst, mask, func(im draw.Image, src image.Image) {
+			for _, pg := range placed {
+				dc.matrix = pg.matrix
+				dc.drawGlyphs(im, []glyph{pg.glyph}, 0, 0, src)
+			}
+			dc.matrix = matrix
+		})
+	})
+}
+
+// CreateStringPathOnPath creates a path from the glyph outlines of the
+// specified text placed along a path, like DrawStringOnPath. When
+// p.Points is nil the current path is followed and then replaced by the
+// glyph outlines.
+func (dc *Context) CreateStringPathOnPath(s string, p TextOnPath) {
+	placed := dc.placeOnPath(dc.pathFaces(), s, p)
+	if p.Points == nil {
+		dc.ClearPath()
+	}
+	matrix := dc.matrix
+	defer func() { dc.matrix = matrix }()
+	for _, pg := range placed {
+		dc.matrix = pg.matrix
+		g := pg.glyph
+		if err := dc.glyphPath(g, unfix(g.dot.X), unfix(g.dot.Y)); err != nil {
+			log.Println(err)
+			return
+		}
+	}
+}
+
+// placedGlyph is a glyph with the matrix that positions it on a path. The
+// origin of the glyph is at the origin of the matrix.
+type placedGlyph struct {
+	glyph  glyph
+	matrix Matrix
+}
+
+// placeOnPath lays out s with faces and positions each glyph on the path
+// described by p. Glyphs are placed by the middle of their advance, so that
+// they follow curves smoothly.
+func (dc *Context) placeOnPath(faces []font.Face, s string, p TextOnPath) []placedGlyph {
+	paths := [][]Point{p.Points}
+	if p.Points == nil {
+		paths = dc.userPath()
+	}
+	if p.Side == TextSideRight {
+		paths = reversePaths(paths)
+	}
+	glyphs, advance := dc.layoutString(faces, s)
+	start := p.Offset
+	switch p.Align {
+	case AlignCenter:
+		start -= unfix(advance) / 2
+	case AlignRight:
+		start -= unfix(advance)
+	}
+	var result []placedGlyph
+	for _, g := range glyphs {
+		half := unfix(g.advance) / 2
+		pt, angle, ok := pointAtDistance(paths, start+unfix(g.dot.X)+half)
+		if !ok {
+			continue
+		}
+		m := dc.matrix.Translate(pt.X, pt.Y).Rotate(angle)
+		g.dot.X = fix(-half)
+		result = append(result, placedGlyph{g, m})
+	}
+	return result
+}
+
+// userPath returns the current path flattened to polylines in user space.
+func (dc *Context) userPath() [][]Point {
+	inverse := dc.matrix.Inverse()
+	paths := flattenPath(dc.strokePath)
+	for _, path := range paths {
+		for i, p := range path {
+			path[i].X, path[i].Y = inverse.TransformPoint(p.X, p.Y)
+		}
+	}
+	return paths
+}
+
+// reversePaths returns polylines that run the other way.
+func reversePaths(paths [][]Point) [][]Point {
+	result := make([][]Point, len(paths))
+	for i, path := range paths {
+		r := make([]Point, len(path))
+		for j, p := range path {
+			r[len(path)-1-j] = p
+		}
+		result[len(paths)-1-i] = r
+	}
+	return result
+}
+
+// pointAtDistance returns the point at distance d along polylines, which
+// are walked one after another, and the angle of the path there. ok is false
+// if d is before the start or past the end of the path.
+func pointAtDistance(paths [][]Point, d float64) (p Point, angle float64, ok bool) {
+	if d < 0 {
+		return Point{}, 0, false
+	}
+	for _, path := range paths {
+		for i := 1; i < len(path); i++ {
+			a, b := path[i-1], path[i]
+			l := a.Distance(b)
+			if l == 0 {
+				continue
+			}
+			if d <= l {
+				return a.Interpolate(b, d/l), math.Atan2(b.Y-a.Y, b.X-a.X), true
+			}
+			d -= l
+		}
+	}
+	return Point{}, 0, false
+}
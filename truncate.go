@@ -0,0 +1,205 @@
+package gg
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/go-text/typesetting/segmenter"
+)
+
+// TruncatePosition selects where text that does not fit is cut.
+type TruncatePosition int
+
+const (
+	// TruncateEnd keeps the start of the text and puts the ellipsis at the
+	// end of the last visible line.
+	TruncateEnd TruncatePosition = iota
+	// TruncateStart keeps the end of the text and puts the ellipsis at the
+	// start of the first visible line.
+	TruncateStart
+	// TruncateMiddle keeps the start and the end of the text and puts the
+	// ellipsis between them on the last visible line.
+	TruncateMiddle
+)
+
+// Truncation limits the number of lines of word-wrapped text drawn by
+// DrawStringTruncated and returned by WordWrapTruncated.
+type Truncation struct {
+	// MaxLines is the largest number of lines. Zero means no limit.
+	MaxLines int
+
+	// MaxHeight is the largest height of the text, measured like in
+	// MeasureMultilineString. Zero means no limit.
+	MaxHeight float64
+
+	// Ellipsis replaces the text that is cut. If it is empty "…" is used.
+	Ellipsis string
+
+	// Position selects where the text is cut.
+	Position TruncatePosition
+}
+
+// maxLines returns the number of lines of the given height and spacing that
+// t allows, or -1 if there is no limit.
+func (t Truncation) maxLines(height, lineSpacing float64) int {
+	n := -1
+	if t.MaxLines > 0 {
+		n = t.MaxLines
+	}
+	if t.MaxHeight > 0 {
+		// invert the h formula of MeasureMultilineString
+		m := int(math.Floor((t.MaxHeight+(lineSpacing-1)*height)/(height*lineSpacing) + 1e-9))
+		if m < 0 {
+			m = 0
+		}
+		if n < 0 || m < n {
+			n = m
+		}
+	}
+	return n
+}
+
+// WordWrapTruncated wraps the specified string to the given max width and
+// then cuts it to the lines allowed by t, which are spaced by lineSpacing
+// when t has a MaxHeight. It reports whether any text was cut.
+func (dc *Context) WordWrapTruncated(s string, width, lineSpacing float64, t Truncation) (lines []string, truncated bool) {
+	wrapped, truncated := dc.truncateLines(s, dc.wrapLines(s, width, AlignLeft), width, lineSpacing, t)
+	for _, line := range wrapped {
+		lines = append(lines, line.text)
+	}
+	return lines, truncated
+}
+
+// DrawStringTruncated word-wraps and draws the specified string like
+// DrawStringWrapped, showing only the lines allowed by t. The text that does
+// not fit is replaced by an ellipsis. It reports whether any text was cut,
+// so that the full text can be shown elsewhere, such as in a tooltip.
+func (dc *Context) DrawStringTruncated(s string, x, y, ax, ay, width, lineSpacing float64, align Align, t Truncation) bool {
+	lines, truncated := dc.truncateLines(s, dc.wrapLines(s, width, align), width, lineSpacing, t)
+	dc.drawLines(lines, x, y, ax, ay, width, lineSpacing, align)
+	return truncated
+}
+
+// truncateLines cuts lines, wrapped from s, to the number allowed by t and
+// adds the ellipsis.
+func (dc *Context) truncateLines(s string, lines []textLine, width, lineSpacing float64, t Truncation) ([]textLine, bool) {
+	n := t.maxLines(dc.fontHeight, lineSpacing)
+	if n < 0 || len(lines) <= n {
+		return lines, false
+	}
+	if n == 0 {
+		return nil, true
+	}
+	ellipsis := t.Ellipsis
+	if ellipsis == "" {
+		ellipsis = "…"
+	}
+	var result []textLine
+	switch t.Position {
+	case TruncateStart:
+		k := len(lines) - n
+		result = append(result, lines[k:]...)
+		result[0].text = dc.truncateStart(joinLines(s, lines[:k+1]), ellipsis, width)
+	case TruncateMiddle:
+		result = append(result, lines[:n]...)
+		result[n-1].text = dc.truncateMiddle(joinLines(s, lines[n-1:]), ellipsis, width)
+	default:
+		result = append(result, lines[:n]...)
+		result[n-1].text = dc.truncateEnd(joinLines(s, lines[n-1:]), ellipsis, width)
+	}
+	line := &result[len(result)-1]
+	if t.Position == TruncateStart {
+		line = &result[0]
+	}
+	line.hyphen, line.justify = false, false
+	return result, true
+}
+
+// joinLines returns the text of word-wrapped lines, taken from s, the text
+// they were wrapped from, so that nothing is added between lines broken
+// where there was no space, nor kept of the hyphens added by word-wrapping.
+// Line breaks between paragraphs become spaces.
+func joinLines(s string, lines []textLine) string {
+	text := s[lines[0].start:lines[len(lines)-1].end]
+	return strings.Replace(text, "\n", " ", -1)
+}
+
+// graphemes returns the byte offsets of the grapheme cluster boundaries of s,
+// including 0 and len(s), so that text is never cut inside a character.
+func graphemes(s string) []int {
+	text := []rune(s)
+	var seg segmenter.Segmenter
+	seg.Init(text)
+	offsets := []int{0}
+	offset := 0
+	iter := seg.GraphemeIterator()
+	for iter.Next() {
+		offset += len(string(iter.Grapheme().Text))
+		offsets = append(offsets, offset)
+	}
+	return offsets
+}
+
+// fits reports whether s is no longer than width.
+func (dc *Context) fits(s string, width float64) bool {
+	return dc.lineLength(s) <= width
+}
+
+// truncateEnd returns the longest start of s followed by ellipsis that fits
+// width.
+func (dc *Context) truncateEnd(s, ellipsis string, width float64) string {
+	b := graphemes(s)
+	cut := func(k int) string {
+		return strings.TrimRightFunc(s[:b[k]], unicode.IsSpace) + ellipsis
+	}
+	k := sort.Search(len(b), func(k int) bool {
+		return !dc.fits(cut(k), width)
+	})
+	if k > 0 {
+		k--
+	}
+	return cut(k)
+}
+
+// truncateStart returns ellipsis followed by the longest end of s that fits
+// width.
+func (dc *Context) truncateStart(s, ellipsis string, width float64) string {
+	b := graphemes(s)
+	cut := func(k int) string {
+		return ellipsis + strings.TrimLeftFunc(s[b[k]:], unicode.IsSpace)
+	}
+	k := sort.Search(len(b), func(k int) bool {
+		return dc.fits(cut(k), width)
+	})
+	if k == len(b) {
+		k--
+	}
+	return cut(k)
+}
+
+// truncateMiddle returns the start and the end of s joined by ellipsis, as
+// long as fits width. Both parts are grown in turn so that they keep about
+// the same length.
+func (dc *Context) truncateMiddle(s, ellipsis string, width float64) string {
+	b := graphemes(s)
+	cut := func(i, j int) string {
+		return strings.TrimRightFunc(s[:b[i]], unicode.IsSpace) + ellipsis +
+			strings.TrimLeftFunc(s[b[j]:], unicode.IsSpace)
+	}
+	i, j := 0, len(b)-1
+	for i+1 < j {
+		headFirst := i <= len(b)-1-j
+		if headFirst && dc.fits(cut(i+1, j), width) {
+			i++
+		} else if dc.fits(cut(i, j-1), width) {
+			j--
+		} else if !headFirst && dc.fits(cut(i+1, j), width) {
+			i++
+		} else {
+			break
+		}
+	}
+	return cut(i, j)
+}
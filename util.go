@@ -128,19 +128,40 @@ func unfix(x fixed.Int26_6) float64 {
 // the specified point size. Note that the returned `font.Face` objects
 // are not thread safe and cannot be used in parallel across goroutines.
 // You can usually just use the Context.LoadFontFace function instead of
-// this package-level function.
+// this package-level function. TrueType and OpenType fonts, with TrueType or
+// CFF outlines, are supported; for font collections the first font is used.
 func LoadFontFace(path string, points float64) (font.Face, error) {
+	return LoadFontFaceIndex(path, 0, points)
+}
+
+// LoadFontFaceIndex is like LoadFontFace, but loads the font with the given
+// index from a font collection (.ttc or .otc file).
+func LoadFontFaceIndex(path string, index int, points float64) (font.Face, error) {
 	fontBytes, err := ioutil.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
-	f, err := truetype.Parse(fontBytes)
-	if err != nil {
-		return nil, err
+	var face font.Face
+	if index == 0 {
+		// truetype reads the first font of collections
+		if f, err := truetype.Parse(fontBytes); err == nil {
+			face = truetype.NewFace(f, &truetype.Options{
+				Size: points,
+				// Hinting: font.HintingFull,
+			})
+		}
+	}
+	if face == nil {
+		// fonts with CFF outlines, and all but the first font of
+		// collections, are only read by sfnt
+		sf, err := parseSFNT(fontBytes, index)
+		if err != nil {
+			return nil, err
+		}
+		face = newSFNTFace(sf, points)
+	}
+	if otf, err := parseOTFont(fontBytes, index); err == nil {
+		return newShapedFace(face, otf, points), nil
 	}
-	face := truetype.NewFace(f, &truetype.Options{
-		Size: points,
-		// Hinting: font.HintingFull,
-	})
 	return face, nil
 }
@@ -0,0 +1,78 @@
+package gg
+
+import (
+	"fmt"
+	"sort"
+
+	otfont "github.com/go-text/typesetting/font"
+	"github.com/go-text/typesetting/font/opentype"
+	"golang.org/x/image/font"
+)
+
+// parseVariations converts variation axis values keyed by their four letter
+// tags, such as "wght" or "wdth", to the settings of a go-text face. The
+// settings are sorted by tag so that they do not depend on map order.
+func parseVariations(variations map[string]float64) ([]otfont.Variation, error) {
+	tags := make([]string, 0, len(variations))
+	for tag := range variations {
+		if len(tag) != 4 {
+			return nil, fmt.Errorf("gg: invalid variation axis tag %q", tag)
+		}
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	var settings []otfont.Variation
+	for _, tag := range tags {
+		settings = append(settings, otfont.Variation{
+			Tag:   opentype.MustNewTag(tag),
+			Value: float32(variations[tag]),
+		})
+	}
+	return settings, nil
+}
+
+// SetFontVariations sets the values of the variation axes of variable fonts,
+// keyed by axis tag, such as "wght" for the weight, "wdth" for the width and
+// "slnt" for the slant. Axes that are not set, or that the font does not
+// have, keep their default values, and values out of the range of an axis
+// are clamped. The variations apply to the current font and to the fonts
+// loaded afterwards; text drawn and outlines created with them use the
+// varied glyph outlines (gvar or CFF2) and advances (HVAR). Use nil to
+// restore the default instance. Only fonts loaded with LoadFont, LoadFontData
+// or LoadFontFace, which are shaped, can be varied.
+func (dc *Context) SetFontVariations(variations map[string]float64) error {
+	settings, err := parseVariations(variations)
+	if err != nil {
+		return err
+	}
+	dc.fontVariations = settings
+	if f, ok := dc.fontFace.(*shapedFace); ok {
+		dc.fontFace = f.vary(settings)
+	}
+	return nil
+}
+
+// vary returns a copy of f using the given variation settings.
+func (f *shapedFace) vary(settings []otfont.Variation) *shapedFace {
+	v := newShapedFace(f.Face, f.font, f.size)
+	v.ot.SetVariations(settings)
+	return v
+}
+
+// LoadFontFaceVariations is like LoadFontFace, but sets the values of the
+// variation axes of a variable font, as described for
+// Context.SetFontVariations.
+func LoadFontFaceVariations(path string, points float64, variations map[string]float64) (font.Face, error) {
+	settings, err := parseVariations(variations)
+	if err != nil {
+		return nil, err
+	}
+	face, err := LoadFontFace(path, points)
+	if err != nil {
+		return nil, err
+	}
+	if f, ok := face.(*shapedFace); ok {
+		return f.vary(settings), nil
+	}
+	return face, nil
+}
@@ -0,0 +1,313 @@
+package gg
+
+import (
+	"image"
+	"math"
+
+	"github.com/go-text/typesetting/di"
+	"github.com/go-text/typesetting/font/opentype"
+	"github.com/go-text/typesetting/language"
+	"github.com/go-text/typesetting/shaping"
+	"github.com/go-text/typesetting/unicodedata"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// WritingMode selects whether lines of text are laid out horizontally or
+// vertically.
+type WritingMode int
+
+const (
+	// WritingModeHorizontal lays out text in horizontal lines that follow
+	// each other from top to bottom.
+	WritingModeHorizontal WritingMode = iota
+	// WritingModeVerticalRL lays out text in vertical columns, read from top
+	// to bottom, that follow each other from right to left, as is usual for
+	// Chinese and Japanese.
+	WritingModeVerticalRL
+	// WritingModeVerticalLR lays out text in vertical columns that follow
+	// each other from left to right.
+	WritingModeVerticalLR
+)
+
+// SetWritingMode sets how DrawString, DrawStringAnchored, DrawStringWrapped,
+// DrawStringTruncated and MeasureString lay out text.
+//
+// In the vertical writing modes, characters of scripts that are written
+// vertically, such as Chinese ideographs and Japanese kana, are set upright
+// one below the other, using the vertical metrics (vhea and vmtx) and the
+// vertical alternates (vert and vrt2) of the font when it has them. Other
+// characters, such as Latin letters, are rotated 90° clockwise, following
+// Unicode Technical Report #50. For DrawString and DrawStringAnchored, the
+// column is placed in the box of its width, the font height, and its
+// length: the anchor point is x - w * ax, y - h * ay. DrawStringWrapped wraps
+// the text in columns of the given width, which is then their height, and
+// AlignLeft, AlignCenter and AlignRight align the columns to the top, the
+// middle or the bottom. AlignJustify aligns them to the top.
+func (dc *Context) SetWritingMode(mode WritingMode) {
+	dc.writingMode = mode
+}
+
+// vertical reports whether text is laid out in columns.
+func (dc *Context) vertical() bool {
+	return dc.writingMode != WritingModeHorizontal
+}
+
+// verticalGlyph is a glyph of a column of text. Upright glyphs are
+// positioned relative to the top of the center line of the column; rotated
+// glyphs are positioned in a frame rotated 90° clockwise around origin.
+type verticalGlyph struct {
+	glyph
+	sideways bool
+	origin   Point
+}
+
+// lineLength returns the advance of s along a line, or along a column in
+// the vertical writing modes.
+func (dc *Context) lineLength(s string) float64 {
+	return dc.cachedLength(s, func() float64 {
+		if dc.vertical() {
+			_, length := layoutColumn(dc.faces(), []rune(s))
+			return unfix(length)
+		}
+		_, a := dc.shapeString(s)
+		return float64(a >> 6)
+	})
+}
+
+// layoutColumn lays out text in a column with faces. It returns the glyphs
+// and the length of the column.
+func layoutColumn(faces []font.Face, text []rune) ([]verticalGlyph, fixed.Int26_6) {
+	replaceMissing(faces, text)
+	var glyphs []verticalGlyph
+	var length fixed.Int26_6
+	for _, fr := range splitByFace(faces, text, bidiRun{0, len(text), 0}) {
+		var g []verticalGlyph
+		var l fixed.Int26_6
+		if f, ok := faces[fr.face].(*shapedFace); ok {
+			g, l = f.shapeVertical(text, fr.start, fr.end)
+		} else {
+			g, l = runeColumn(faces[fr.face], text, fr.start, fr.end)
+		}
+		for i := range g {
+			if g[i].sideways {
+				g[i].origin.Y += unfix(length)
+			} else {
+				g[i].dot.Y += length
+			}
+		}
+		glyphs = append(glyphs, g...)
+		length += l
+	}
+	return glyphs, length
+}
+
+// sidewaysBaseline returns the horizontal position of the baseline of
+// rotated text relative to the center line of a column, which centers the
+// ascent and descent of face in the column.
+func sidewaysBaseline(face font.Face) float64 {
+	m := face.Metrics()
+	return unfix(m.Descent-m.Ascent) / 2
+}
+
+// sideways reports, for each rune of text, whether it is rotated in vertical
+// text. Characters common to several scripts, such as punctuation and
+// digits, take the orientation of the script of the text around them, as
+// when text is shaped.
+func sideways(text []rune) []bool {
+	scripts := make([]language.Script, len(text))
+	last := language.Unknown
+	for i, r := range text {
+		scripts[i] = language.LookupScript(r)
+		if scripts[i] == language.Common || scripts[i] == language.Inherited {
+			scripts[i] = last
+		} else {
+			last = scripts[i]
+		}
+	}
+	next := language.Unknown
+	for i := len(text) - 1; i >= 0; i-- {
+		if scripts[i] == language.Unknown {
+			scripts[i] = next
+		} else {
+			next = scripts[i]
+		}
+	}
+	result := make([]bool, len(text))
+	for i, r := range text {
+		result[i] = unicodedata.LookupVerticalOrientation(scripts[i]).Orientation(r)
+	}
+	return result
+}
+
+// runeColumn lays out text[start:end] in a column one rune at a time.
+// Upright glyphs take the height of the font.
+func runeColumn(face font.Face, text []rune, start, end int) ([]verticalGlyph, fixed.Int26_6) {
+	m := face.Metrics()
+	baseline := sidewaysBaseline(face)
+	rotated := sideways(text[start:end])
+	var glyphs []verticalGlyph
+	var length fixed.Int26_6
+	for i := start; i < end; i++ {
+		a, ok := face.GlyphAdvance(text[i])
+		if !ok {
+			continue
+		}
+		g := glyph{face: face, r: text[i], advance: a, cluster: i}
+		if rotated[i-start] {
+			glyphs = append(glyphs, verticalGlyph{glyph: g, sideways: true, origin: Point{baseline, unfix(length)}})
+			length += a
+			continue
+		}
+		g.dot = fixed.Point26_6{X: -a / 2, Y: length + m.Ascent}
+		g.advance = m.Ascent + m.Descent
+		glyphs = append(glyphs, verticalGlyph{glyph: g})
+		length += g.advance
+	}
+	return glyphs, length
+}
+
+// shapeVertical shapes text[start:end] in a column. The text is split into
+// runs of upright and rotated characters; upright runs are shaped top to
+// bottom, with the vertical alternates of the font, and rotated runs
+// horizontally.
+func (f *shapedFace) shapeVertical(text []rune, start, end int) ([]verticalGlyph, fixed.Int26_6) {
+	input := shaping.Input{
+		Text:      text,
+		RunStart:  start,
+		RunEnd:    end,
+		Direction: di.DirectionTTB,
+		Face:      f.ot,
+		Size:      fixed.Int26_6(f.size * 64),
+	}
+	vert, vrt2 := opentype.MustNewTag("vert"), opentype.MustNewTag("vrt2")
+	for _, feature := range f.font.GSUB.Features {
+		if feature.Tag == vrt2 {
+			// vrt2 replaces vert in the fonts that have it
+			input.FontFeatures = []shaping.FontFeature{{Tag: vert, Value: 0}, {Tag: vrt2, Value: 1}}
+			break
+		}
+	}
+	baseline := sidewaysBaseline(f)
+	var glyphs []verticalGlyph
+	var length fixed.Int26_6
+	for _, in := range f.segmenter.Split(input, f) {
+		sidewaysRun := in.Direction.IsSideways()
+		if sidewaysRun {
+			rtl := in.Direction.Progression() == di.TowardTopLeft
+			in.Direction = di.DirectionLTR
+			if rtl {
+				in.Direction = di.DirectionRTL
+			}
+		} else {
+			in.Direction = di.DirectionTTB
+			in.Direction.SetSideways(false)
+		}
+		out := f.shaper.Shape(in)
+		var advance fixed.Int26_6
+		for _, g := range out.Glyphs {
+			vg := verticalGlyph{glyph: glyph{
+				face:    f,
+				id:      g.GlyphID,
+				r:       text[g.ClusterIndex],
+				cluster: g.ClusterIndex,
+			}}
+			if sidewaysRun {
+				vg.sideways = true
+				vg.origin = Point{baseline, unfix(length)}
+				vg.dot = fixed.Point26_6{X: advance + g.XOffset, Y: -g.YOffset}
+				vg.advance = g.XAdvance
+				advance += g.XAdvance
+			} else {
+				// the offsets place the glyph below the dot, centered
+				vg.dot = fixed.Point26_6{X: g.XOffset, Y: length + advance - g.YOffset}
+				vg.advance = -g.YAdvance
+				advance -= g.YAdvance
+			}
+			glyphs = append(glyphs, vg)
+		}
+		length += advance
+	}
+	return glyphs, length
+}
+
+// columnMatrix returns the matrix that positions g in a column whose center
+// line starts at x, y.
+func (dc *Context) columnMatrix(g verticalGlyph, x, y float64) Matrix {
+	if g.sideways {
+		return dc.matrix.Translate(x+g.origin.X, y+g.origin.Y).Rotate(math.Pi / 2)
+	}
+	return dc.matrix.Translate(x, y)
+}
+
+// drawColumn draws glyphs laid out by layoutColumn onto im, with the center
+// line of the column starting at x, y.
+func (dc *Context) drawColumn(im draw.Image, glyphs []verticalGlyph, x, y float64, src image.Image) {
+	matrix := dc.matrix
+	for _, g := range glyphs {
+		dc.matrix = dc.columnMatrix(g, x, y)
+		dc.drawGlyphs(im, []glyph{g.glyph}, 0, 0, src)
+		dc.matrix = matrix
+	}
+}
+
+// drawStringVertical draws s in a single column, like DrawStringAnchored in
+// the vertical writing modes.
+func (dc *Context) drawStringVertical(s string, x, y, ax, ay float64) {
+	glyphs, length := layoutColumn(dc.faces(), []rune(s))
+	x += (0.5 - ax) * dc.fontHeight
+	y -= ay * unfix(length)
+	dc.drawColumns([][]verticalGlyph{glyphs}, []float64{x}, []float64{y})
+}
+
+// drawColumns draws columns of glyphs with the center lines starting at the
+// corresponding xs and ys, with the fill pattern.
+func (dc *Context) drawColumns(columns [][]verticalGlyph, xs, ys []float64) {
+	var bounds image.Rectangle
+	for i, glyphs := range columns {
+		for _, g := range glyphs {
+			bounds = bounds.Union(glyphsBounds([]glyph{g.glyph}, 0, 0, dc.columnMatrix(g, xs[i], ys[i])))
+		}
+	}
+	dc.composite(bounds, func(dst *image.RGBA, mask *image.Alpha) {
+		dc.paintGlyphs(dst, mask, func(im draw.Image, src image.Image) {
+			for i, glyphs := range columns {
+				dc.drawColumn(im, glyphs, xs[i], ys[i], src)
+			}
+		})
+	})
+}
+
+// drawLinesVertical draws word-wrapped lines as columns, like
+// DrawStringWrapped in the vertical writing modes. height is the height of
+// the columns.
+func (dc *Context) drawLinesVertical(lines []textLine, x, y, ax, ay, height, lineSpacing float64, align Align) {
+	// sync w formula with MeasureMultilineString
+	w := float64(len(lines)) * dc.fontHeight * lineSpacing
+	w -= (lineSpacing - 1) * dc.fontHeight
+
+	x -= ax * w
+	y -= ay * height
+	var columns [][]verticalGlyph
+	var xs, ys []float64
+	for i, line := range lines {
+		glyphs, length := layoutColumn(dc.faces(), []rune(line.text))
+		offset := float64(i)*dc.fontHeight*lineSpacing + dc.fontHeight/2
+		cx := x + w - offset
+		if dc.writingMode == WritingModeVerticalLR {
+			cx = x + offset
+		}
+		cy := y
+		switch align {
+		case AlignCenter:
+			cy += (height - unfix(length)) / 2
+		case AlignRight:
+			cy += height - unfix(length)
+		}
+		columns = append(columns, glyphs)
+		xs = append(xs, cx)
+		ys = append(ys, cy)
+	}
+	dc.drawColumns(columns, xs, ys)
+}
@@ -1,58 +1,272 @@
 package gg
 
 import (
+	"sort"
 	"strings"
 	"unicode"
+	"unicode/utf8"
+
+	"github.com/go-text/typesetting/segmenter"
+	"golang.org/x/image/math/fixed"
+)
+
+// WrapMode selects how lines are broken when text is word-wrapped.
+type WrapMode int
+
+const (
+	// WrapGreedy fills each line with as many words as fit before moving on
+	// to the next line.
+	WrapGreedy WrapMode = iota
+	// WrapOptimal chooses the breaks of each paragraph together, Knuth-Plass
+	// style, so that the lines are as even as possible. The empty space at
+	// the end of each line but the last is minimized.
+	WrapOptimal
 )
 
-type measureStringer interface {
-	MeasureString(s string) (w, h float64)
+// Hyphenator returns the byte offsets in word at which it may be hyphenated.
+// A line broken at such an offset ends with a hyphen.
+type Hyphenator func(word string) []int
+
+// hyphenPenalty is added to the cost of lines that end with a hyphen by
+// WrapOptimal, as a fraction of the squared wrapping width.
+const hyphenPenalty = 0.05
+
+// SetWrapMode sets how lines are broken by WordWrap and DrawStringWrapped.
+func (dc *Context) SetWrapMode(mode WrapMode) {
+	dc.wrapMode = mode
 }
 
-func splitOnSpace(x string) []string {
-	var result []string
-	pi := 0
-	ps := false
-	for i, c := range x {
-		s := unicode.IsSpace(c)
-		if s != ps && i > 0 {
-			result = append(result, x[pi:i])
-			pi = i
-		}
-		ps = s
+// SetHyphenator sets the function used to find the places where words may be
+// hyphenated when text is word-wrapped. Words are only hyphenated when that
+// lets more text fit on a line, or with WrapOptimal when that makes lines
+// more even. Use nil, the default, to never hyphenate.
+func (dc *Context) SetHyphenator(h Hyphenator) {
+	dc.hyphenator = h
+}
+
+// wrapLine is a line of word-wrapped text, given by the byte offsets of its
+// start and end without leading and trailing white space.
+type wrapLine struct {
+	start, end int
+	hyphen     bool // the line ends inside a word and is drawn with a hyphen
+	last       bool // the line is the last of its paragraph
+}
+
+// text returns the text of the line in s, including the hyphen.
+func (l wrapLine) text(s string) string {
+	if l.hyphen {
+		return s[l.start:l.end] + "-"
 	}
-	result = append(result, x[pi:])
-	return result
+	return s[l.start:l.end]
 }
 
-func wordWrap(m measureStringer, s string, width float64) []string {
-	var result []string
-	for _, line := range strings.Split(s, "\n") {
-		fields := splitOnSpace(line)
-		if len(fields)%2 == 1 {
-			fields = append(fields, "")
-		}
-		x := ""
-		for i := 0; i < len(fields); i += 2 {
-			w, _ := m.MeasureString(x + fields[i])
-			if w > width {
-				if x == "" {
-					result = append(result, fields[i])
-					x = ""
-					continue
-				} else {
-					result = append(result, x)
-					x = ""
+// wordWrap wraps s to width with the line breaking settings of dc. measure
+// returns the width of s[i:j], followed by a hyphen if hyphen is true.
+func (dc *Context) wordWrap(s string, width float64, measure func(i, j int, hyphen bool) float64) []wrapLine {
+	return wrapRanges(s, width, measure, dc.hyphenator, dc.wrapMode)
+}
+
+// breakPoint is a place where a paragraph may be broken. A line ending at
+// the break point ends at end, and the next line starts at next.
+type breakPoint struct {
+	end, next int
+	hyphen    bool
+	mandatory bool
+}
+
+// breakPoints returns the line break opportunities of the paragraph
+// s[start:end] found by the Unicode line breaking algorithm, together with
+// the hyphenation points of its words, in order. The first break point is the
+// start of the paragraph and the last one its end.
+func breakPoints(s string, start, end int, hyphenate Hyphenator) []breakPoint {
+	text := []rune(s[start:end])
+	offsets := make([]int, len(text)+1) // byte offset of each rune
+	offsets[0] = start
+	for i, r := range text {
+		offsets[i+1] = offsets[i] + utf8.RuneLen(r)
+	}
+	points := []breakPoint{{end: start, next: start}}
+	var seg segmenter.Segmenter
+	seg.Init(text)
+	iter := seg.LineIterator()
+	for iter.Next() {
+		line := iter.Line()
+		i, j := line.Offset, line.Offset+len(line.Text)
+		k := j
+		for k > i && unicode.IsSpace(text[k-1]) {
+			k--
+		}
+		if hyphenate != nil && k > i {
+			word := s[offsets[i]:offsets[k]]
+			hyphens := append([]int(nil), hyphenate(word)...)
+			sort.Ints(hyphens)
+			for _, h := range hyphens {
+				if h > 0 && h < len(word) && utf8.RuneStart(word[h]) {
+					p := offsets[i] + h
+					points = append(points, breakPoint{end: p, next: p, hyphen: true})
 				}
 			}
-			x += fields[i] + fields[i+1]
 		}
-		if x != "" {
-			result = append(result, x)
+		points = append(points, breakPoint{end: offsets[k], next: offsets[j], mandatory: line.IsMandatoryBreak})
+	}
+	points[len(points)-1].mandatory = true
+	return points
+}
+
+// wrapRanges word-wraps s and returns its lines. Paragraphs, separated by
+// "\n", are broken where the Unicode line breaking algorithm allows and at
+// the hyphenation points returned by hyphenate. A word that is wider than
+// width on its own is put on a line by itself.
+func wrapRanges(s string, width float64, measure func(i, j int, hyphen bool) float64, hyphenate Hyphenator, mode WrapMode) []wrapLine {
+	var result []wrapLine
+	start := 0
+	for _, paragraph := range strings.Split(s, "\n") {
+		end := start + len(paragraph)
+		if start < end {
+			points := breakPoints(s, start, end, hyphenate)
+			var breaks []int
+			if mode == WrapOptimal {
+				breaks = optimalBreaks(points, width, measure)
+			} else {
+				breaks = greedyBreaks(points, width, measure)
+			}
+			i := 0
+			for _, j := range breaks {
+				result = append(result, wrapLine{
+					start:  points[i].next,
+					end:    points[j].end,
+					hyphen: points[j].hyphen,
+					last:   points[j].mandatory,
+				})
+				i = j
+			}
 		}
+		start = end + 1
 	}
-	for i, line := range result {
-		result[i] = strings.TrimSpace(line)
+	for i, r := range result {
+		for r.start < r.end {
+			c, n := utf8.DecodeRuneInString(s[r.start:r.end])
+			if !unicode.IsSpace(c) {
+				break
+			}
+			r.start += n
+		}
+		for r.start < r.end {
+			c, n := utf8.DecodeLastRuneInString(s[r.start:r.end])
+			if !unicode.IsSpace(c) {
+				break
+			}
+			r.end -= n
+		}
+		result[i] = r
 	}
 	return result
 }
+
+// greedyBreaks returns the indexes of the break points at which each line
+// ends, putting as much text as fits on each line.
+func greedyBreaks(points []breakPoint, width float64, measure func(i, j int, hyphen bool) float64) []int {
+	var breaks []int
+	for i := 0; i < len(points)-1; {
+		j := i + 1
+		for !points[j].mandatory {
+			p := points[j+1]
+			if measure(points[i].next, p.end, p.hyphen) > width {
+				break
+			}
+			j++
+		}
+		breaks = append(breaks, j)
+		i = j
+	}
+	return breaks
+}
+
+// optimalBreaks returns the indexes of the break points at which each line
+// ends, minimizing the sum of the squared empty space at the end of each line
+// but the last, with a penalty for hyphens.
+func optimalBreaks(points []breakPoint, width float64, measure func(i, j int, hyphen bool) float64) []int {
+	n := len(points)
+	cost := make([]float64, n)
+	prev := make([]int, n)
+	for j := 1; j < n; j++ {
+		cost[j] = -1
+	}
+	for i := 0; i < n-1; i++ {
+		if cost[i] < 0 {
+			continue
+		}
+		for j := i + 1; j < n; j++ {
+			p := points[j]
+			w := measure(points[i].next, p.end, p.hyphen)
+			if w > width && j > i+1 {
+				break
+			}
+			c := 0.0
+			switch {
+			case w > width:
+				// a word that does not fit on a line of its own
+				c = (w - width) * (w - width)
+			case !p.mandatory:
+				c = (width - w) * (width - w)
+			}
+			if p.hyphen {
+				c += hyphenPenalty * width * width
+			}
+			if c += cost[i]; cost[j] < 0 || c < cost[j] {
+				cost[j], prev[j] = c, i
+			}
+			if p.mandatory {
+				break
+			}
+		}
+	}
+	var breaks []int
+	for j := n - 1; j > 0; j = prev[j] {
+		breaks = append(breaks, j)
+	}
+	for i, j := 0, len(breaks)-1; i < j; i, j = i+1, j-1 {
+		breaks[i], breaks[j] = breaks[j], breaks[i]
+	}
+	return breaks
+}
+
+// justify spreads a line of glyphs, laid out in visual order, by extra so
+// that the line fills its box. The extra space goes to the spaces between
+// words or, in text without spaces such as Chinese or Japanese, between all
+// the characters.
+func justify(glyphs []glyph, extra fixed.Int26_6) {
+	if extra <= 0 {
+		return
+	}
+	spaces := false
+	for _, g := range glyphs {
+		if unicode.IsSpace(g.r) {
+			spaces = true
+			break
+		}
+	}
+	// gap reports whether extra space goes between glyphs i-1 and i
+	gap := func(i int) bool {
+		if spaces {
+			return unicode.IsSpace(glyphs[i-1].r)
+		}
+		return glyphs[i].cluster != glyphs[i-1].cluster
+	}
+	n := 0
+	for i := 1; i < len(glyphs); i++ {
+		if gap(i) {
+			n++
+		}
+	}
+	if n == 0 {
+		return
+	}
+	k := 0
+	for i := 1; i < len(glyphs); i++ {
+		if gap(i) {
+			k++
+		}
+		glyphs[i].dot.X += extra * fixed.Int26_6(k) / fixed.Int26_6(n)
+	}
+}